@@ -2,12 +2,23 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/vvidovic/gps-stats/internal/errs"
 	"github.com/vvidovic/gps-stats/internal/stats"
 	"github.com/vvidovic/gps-stats/internal/version"
 )
@@ -15,60 +26,319 @@ import (
 var (
 	helpFlag              *bool
 	versionFlag           *bool
+	versionJSONFlag       *bool
 	statTypeFlag          *string
 	cleanupDeltaSpeedFlag *float64
+	cleanupAccelFlag      *float64
+	teleportFlag          *float64
+	mergeFlag             *bool
+	cleanupFlag           *string
+	maxHdopFlag           *float64
+	maxSpeedFlag          *float64
+	trimFlag              *time.Duration
+	warmupFlag            *time.Duration
+	bboxFlag              *string
+	excludeFlag           circlesFlag
+	trimSpeedFlag         *float64
+	dopplerFactorFlag     *float64
+	dopplerOffsetFlag     *float64
+	validateFlag          *bool
+	smoothFlag            *bool
+	interpolateFlag       *bool
+	detailsFlag           *bool
 	speedUnitsFlag        *string
 	saveFilteredGpxFlag   *bool
+	saveMeasuredFlag      *bool
+	saveInvalidFlag       *bool
+	fromFlag              *string
+	toFlag                *string
+	gatesFlag             *string
+	gatesSpeedFlag        *float64
+	gatesJSONFlag         *bool
+	summaryFlag           *bool
+	perSessionFlag        *bool
+	sessionGapFlag        *time.Duration
+	splitSessionsFlag     *bool
+	outputFlag            *string
+	compareSpeedFlag      *bool
+	spotsFlag             *string
+	precisionFlag         *int
+	bestFlag              *bool
+	outFlag               *string
+	hrMaxFlag             *int
+	outDirFlag            *string
+	modeFlag              *string
+	gapTrimFlag           *string
+	followFlag            *time.Duration
+	watchFlag             *string
+	reprocessFlag         *bool
+	compareFlag           *bool
+	leapFlag              *int
+	dumpFlag              *string
+	topNFlag              *int
+	distModelFlag         *string
+	parallelFlag          *int
+	verboseFlag           *bool
 )
 
+// out is where statistics are printed (stdout, -out or -outdir file).
+var out io.Writer = os.Stdout
+
+// fileErrors is the number of files which statistics couldn't be printed
+// for, gps-stats exits with status 1 if there are any. Files processed in
+// parallel update it atomically.
+var fileErrors int32
+
+// showProgress is false while files are processed in parallel, so progress
+// of different files is not mixed on stderr.
+var showProgress = true
+
+// runCtx is canceled on interrupt, so long operations stop before the next
+// file is processed or export file is written.
+var runCtx = context.Background()
+
+// progressMinSize is the min size of a file in bytes and progressMinPoints
+// the min number of points to show progress of long operations on stderr.
+const (
+	progressMinSize   = 50 << 20
+	progressMinPoints = 500000
+)
+
+// watchStateFile is the file in the directory watched with -watch
+// recording already processed track files.
+const watchStateFile = ".gps-stats-watch"
+
+// watchInterval is the interval between checks of the directory watched
+// with -watch, a file is processed once it didn't change for an interval.
+const watchInterval = 2 * time.Second
+
+// areaRemovedWarning is the part of all points which, when removed by the
+// area filter, is reported (to notice typos in area coordinates).
+const areaRemovedWarning = 0.1
+
+// modePreset contains cleanup defaults (in kts) tuned for a discipline, used
+// when the matching flag is not set.
+type modePreset struct {
+	deltaSpeedMax float64
+	maxSpeed      float64
+	trimSpeed     float64
+	dopplerOffset float64
+}
+
+// modePresets are presets selected by -mode.
+var modePresets = map[string]modePreset{
+	"fin":  {deltaSpeedMax: 5, maxSpeed: 60, trimSpeed: 2, dopplerOffset: 3},
+	"foil": {deltaSpeedMax: 8, maxSpeed: 60, trimSpeed: 4, dopplerOffset: 4},
+	"kite": {deltaSpeedMax: 7, maxSpeed: 70, trimSpeed: 3, dopplerOffset: 4},
+}
+
+// dumpTracks are statistics which track points can be printed with -dump.
+var dumpTracks = map[string]func(s stats.Stats) stats.Track{
+	"2s":    stats.Stats.Speed2s,
+	"alpha": stats.Stats.Alpha500,
+	"100m":  stats.Stats.Speed100m,
+	"nm":    stats.Stats.Speed1NM,
+}
+
+// fileStatsJSON is a single line of the NDJSON output.
+type fileStatsJSON struct {
+	File          string       `json:"file"`
+	Session       int          `json:"session,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	Points        int          `json:"points,omitempty"`
+	PointsCleaned int          `json:"pointsCleaned,omitempty"`
+	Stats         *stats.Stats `json:"stats,omitempty"`
+	Laps          *stats.Laps  `json:"laps,omitempty"`
+}
+
+// versionJSON is the JSON version output.
+type versionJSON struct {
+	Version   string `json:"version"`
+	Platform  string `json:"platform"`
+	BuildTime string `json:"buildTime"`
+}
+
 func main() {
+	// Exit after deferred functions (like closing the output file) are done.
+	defer func() {
+		if atomic.LoadInt32(&fileErrors) > 0 {
+			os.Exit(1)
+		}
+	}()
+
 	helpFlag = flag.Bool("h", false, "Show gps-stats usage with examples")
 	versionFlag = flag.Bool("v", false, "Show gps-stats version")
+	versionJSONFlag = flag.Bool("version-json", false, "Show gps-stats version as JSON")
 	statTypeFlag = flag.String("t", "all",
-		"Set the statistics type to print (all, 2s, 10sAvg, 10s1, 10s2, 10s3, 10s4, 10s5, 15m, 1h, 100m, 1nm, alpha - default all)")
+		"Set the statistics types to print, comma-separated (all, 2s, 10sAvg, 10s1, 10s2, 10s3, 10s4, 10s5, 15m, 1h, 100m, 1nm, alpha, rose, dur - default all)")
 	cleanupDeltaSpeedFlag = flag.Float64("cs", 0,
 		"Clean up points where speed changes are more than given number of speed units (default 5 kts)")
+	cleanupAccelFlag = flag.Float64("ca", 0,
+		"Clean up points reached with acceleration greater than given m/s² instead of -cs (e.g. 6)")
+	cleanupFlag = flag.String("cleanup", stats.DefaultCleanUp,
+		"Set the comma-separated cleanup filters (fill, dups, gaps, doppler, spikes, accel or none)")
+	teleportFlag = flag.Float64("teleport", 0,
+		"Ignore position jumps longer than given multiple of the median speed around them, e.g. 3 (default 0 - disabled)")
+	maxHdopFlag = flag.Float64("max-hdop", 0,
+		"Remove points with GPX hdop greater than given value (default 0 - disabled)")
+	validateFlag = flag.Bool("validate", false,
+		"Print anomalies found in track points without calculating statistics")
+	maxSpeedFlag = flag.Float64("max-speed", 0,
+		"Remove points reached faster than given number of speed units (default 60 kts)")
+	bboxFlag = flag.String("bbox", "",
+		"Remove points outside the area 'minLat,minLon,maxLat,maxLon' or 'lat,lon,radius'")
+	flag.Var(&excludeFlag, "exclude",
+		"Remove points inside the circle 'lat,lon,radius' after other clean up filters (repeatable)")
+	warmupFlag = flag.Duration("warmup", 0,
+		"Remove points at the start of the track until positions are stable for given duration")
+	trimFlag = flag.Duration("trim", 0,
+		"Trim stationary periods at the start and end of the track longer than given duration")
+	trimSpeedFlag = flag.Float64("trim-speed", 0,
+		"Set the speed below which points are stationary for -trim (default 2 kts)")
+	dopplerFactorFlag = flag.Float64("doppler-factor", 0,
+		"Set the allowed difference between positional and device speed relative to device speed (default 0.3)")
+	dopplerOffsetFlag = flag.Float64("doppler-offset", 0,
+		"Set the allowed difference between positional and device speed in speed units (default 3 kts)")
+	detailsFlag = flag.Bool("d", false,
+		"Print all ranges of points removed by cleanup")
+	interpolateFlag = flag.Bool("interpolate", false,
+		"Calculate 2 second peak and 5x10 tracks from exactly 2 and 10 seconds, interpolating positions between points")
+	smoothFlag = flag.Bool("smooth", false,
+		"Smooth positions after cleanup using a Kalman filter")
 	speedUnitsFlag = flag.String("su", "kts",
 		"Set the speed units printed (kts, kmh, ms - default kts)")
 	saveFilteredGpxFlag = flag.Bool("sf", false, "Save filtered track to a new GPX file")
+	saveMeasuredFlag = flag.Bool("sm", false,
+		"Save only measured points with -sf, without points interpolated by cleanup")
+	saveInvalidFlag = flag.Bool("si", false,
+		"Save also points removed by cleanup with -sf (not smoothed)")
+	fromFlag = flag.String("from", "",
+		"Ignore points before given time (RFC3339 or HH:MM in the track's day)")
+	toFlag = flag.String("to", "",
+		"Ignore points after given time (RFC3339 or HH:MM in the track's day)")
+	gatesFlag = flag.String("gates", "",
+		"Report lap times through 1 or 2 gates (lat1,lon1,lat2,lon2[;lat1,lon1,lat2,lon2] or JSON file)")
+	gatesSpeedFlag = flag.Float64("gates-speed", 0,
+		"Ignore gate crossings slower than given number of speed units (default 0)")
+	gatesJSONFlag = flag.Bool("gates-json", false, "Print gate crossings and laps as JSON")
+	summaryFlag = flag.Bool("summary", false,
+		"Print a single tab-delimited summary line per file (date, 2s, 10sAvg, 100m, 1nm, alpha, units, file)")
+	bestFlag = flag.Bool("best", false,
+		"Print only the headline statistics (date, 2s, 10sAvg, 1nm, alpha)")
+	mergeFlag = flag.Bool("merge", false,
+		"Merge points of all files into a single session (sorted, duplicate timestamps removed)")
+	perSessionFlag = flag.Bool("per-session", false,
+		"Print statistics also for each session (track segment or part between long gaps)")
+	sessionGapFlag = flag.Duration("session-gap", 30*time.Minute,
+		"Minimum gap between points starting a new session (default 30m)")
+	splitSessionsFlag = flag.Bool("split-sessions", false,
+		"Print statistics only per session for files with gaps longer than -session-gap")
+	compareSpeedFlag = flag.Bool("compare-speed", false,
+		"Show average device-reported speed next to each statistic")
+	spotsFlag = flag.String("spots", "",
+		"Show spot name from a CSV file with 'name,lat,lon,radius' lines next to the session location")
+	precisionFlag = flag.Int("precision", 3,
+		"Set the number of decimal places for printed speeds and distances (default 3)")
+	verboseFlag = flag.Bool("verbose", false,
+		"Print each of the 5x10 runs with -t 10sAvg")
+	topNFlag = flag.Int("topn", 5,
+		"Set the number of the fastest alphas printed with -t alpha")
+	dumpFlag = flag.String("dump", "",
+		"Print all points of the 2s, alpha, 100m or nm track")
+	leapFlag = flag.Int("leap", 0,
+		"Subtract given number of leap seconds from SBN timestamps in GPS time (e.g. 18, default 0)")
+	distModelFlag = flag.String("distmodel", "simple",
+		"Set the model used to calculate distances (simple, haversine - default simple)")
+	hrMaxFlag = flag.Int("hrmax", 0,
+		"Show time spent in heart rate zones based on given max heart rate")
+	parallelFlag = flag.Int("p", runtime.GOMAXPROCS(0),
+		"Set the number of files processed in parallel (default number of CPUs)")
+	outFlag = flag.String("out", "",
+		"Write statistics to the file instead of stdout")
+	outDirFlag = flag.String("outdir", "",
+		"Write statistics for each input file to a file in the directory instead of stdout")
+	gapTrimFlag = flag.String("gap-trim", "1,3",
+		"Set the number of points removed before and after missing points by the gaps cleanup (default 1,3)")
+	followFlag = flag.Duration("follow", 0,
+		"Re-read the growing file every given interval and print headline statistics")
+	watchFlag = flag.String("watch", "",
+		"Watch the directory and print statistics for new or modified track files")
+	reprocessFlag = flag.Bool("reprocess", false,
+		"Process again track files already processed by -watch")
+	compareFlag = flag.Bool("compare", false,
+		"Print statistics of 2 files side by side with differences")
+	modeFlag = flag.String("mode", "fin",
+		"Set the cleanup defaults for the discipline (fin, foil, kite - default fin)")
+	outputFlag = flag.String("o", "txt",
+		"Set the output format (txt, ndjson, gpssurfing - default txt)")
 
 	flag.Parse()
 
-	if *versionFlag {
-		showVersion()
+	if *versionFlag || *versionJSONFlag {
+		showVersion(*versionJSONFlag)
 	} else if *helpFlag {
 		showUsage(0)
-	} else if len(flag.Args()) < 1 {
+	} else if len(flag.Args()) < 1 && *watchFlag == "" {
 		showUsage(1)
 	} else {
 		statType := stats.StatNone
-		switch *statTypeFlag {
-		case "all":
+		statNames := strings.Split(*statTypeFlag, ",")
+		for i := 0; i < len(statNames); i++ {
+			switch strings.TrimSpace(statNames[i]) {
+			case "all":
+				statType |= stats.StatAll
+			case "2s":
+				statType |= stats.Stat2s
+			case "10sAvg":
+				statType |= stats.Stat10sAvg
+			case "10s1":
+				statType |= stats.Stat10s1
+			case "10s2":
+				statType |= stats.Stat10s2
+			case "10s3":
+				statType |= stats.Stat10s3
+			case "10s4":
+				statType |= stats.Stat10s4
+			case "10s5":
+				statType |= stats.Stat10s5
+			case "15m":
+				statType |= stats.Stat15m
+			case "1h":
+				statType |= stats.Stat1h
+			case "100m":
+				statType |= stats.Stat100m
+			case "1nm":
+				statType |= stats.Stat1nm
+			case "alpha":
+				statType |= stats.StatAlpha
+			case "rose":
+				statType |= stats.StatRose
+			case "dur":
+				statType |= stats.StatDuration
+			case "dist":
+				statType |= stats.StatDistance
+			default:
+				fmt.Printf("Error parsing statistics types '%s': Unknown statistics type '%s'.\n",
+					*statTypeFlag, strings.TrimSpace(statNames[i]))
+				os.Exit(2)
+			}
+		}
+		if *bestFlag {
+			statType = stats.StatBest
+		}
+		if *summaryFlag {
+			statType = stats.StatSummary
+		}
+
+		if *precisionFlag < 0 || *topNFlag < 1 || *parallelFlag < 1 {
+			showUsage(2)
+			return
+		}
+
+		switch *outputFlag {
+		case "txt", "ndjson":
+		case "gpssurfing":
 			statType = stats.StatAll
-		case "2s":
-			statType = stats.Stat2s
-		case "10sAvg":
-			statType = stats.Stat10sAvg
-		case "10s1":
-			statType = stats.Stat10s1
-		case "10s2":
-			statType = stats.Stat10s2
-		case "10s3":
-			statType = stats.Stat10s3
-		case "10s4":
-			statType = stats.Stat10s4
-		case "10s5":
-			statType = stats.Stat10s5
-		case "15m":
-			statType = stats.Stat15m
-		case "1h":
-			statType = stats.Stat1h
-		case "100m":
-			statType = stats.Stat100m
-		case "1nm":
-			statType = stats.Stat1nm
-		case "alpha":
-			statType = stats.StatAlpha
 		default:
 			showUsage(2)
 			return
@@ -87,81 +357,1270 @@ func main() {
 			return
 		}
 
-		for i := 0; i < len(flag.Args()); i++ {
-			printStatsForFile(flag.Args()[i], statType, speedUnits)
+		if *cleanupAccelFlag != 0 && *cleanupDeltaSpeedFlag != 0 {
+			fmt.Println("Error: -ca can't be used with -cs, -ca replaces the spikes filter using -cs")
+			os.Exit(2)
+		}
+		cleanupNames := *cleanupFlag
+		if *cleanupAccelFlag != 0 {
+			var ok bool
+			cleanupNames, ok = replaceCleanUpFilter(cleanupNames, "spikes", "accel")
+			if !ok && !hasCleanUpFilter(cleanupNames, "accel") {
+				fmt.Printf("Error: -ca replaces the spikes filter, cleanup filters '%s' have no spikes or accel filter\n",
+					cleanupNames)
+				os.Exit(2)
+			}
+		}
+
+		mode, ok := modePresets[*modeFlag]
+		if !ok {
+			fmt.Printf("Unknown mode '%s' (fin, foil, kite)\n", *modeFlag)
+			os.Exit(2)
+		}
+		if _, ok := dumpTracks[*dumpFlag]; *dumpFlag != "" && !ok {
+			fmt.Printf("Error parsing dump statistic '%s': expected 2s, alpha, 100m or nm\n", *dumpFlag)
+			os.Exit(2)
+		}
+
+		cleanUpCfg := stats.CleanUpConfig{
+			DeltaSpeedMax:  *cleanupDeltaSpeedFlag,
+			SpeedUnits:     speedUnits,
+			MaxHdop:        *maxHdopFlag,
+			MaxSpeed:       *maxSpeedFlag,
+			TrimStationary: *trimFlag,
+			Warmup:         *warmupFlag,
+			TrimSpeed:      *trimSpeedFlag,
+			DopplerFactor:  *dopplerFactorFlag,
+			DopplerOffset:  *dopplerOffsetFlag,
+			MaxAccel:       *cleanupAccelFlag,
+			TeleportFactor: *teleportFlag,
+		}
+		if cleanUpCfg.DeltaSpeedMax == 0 {
+			cleanUpCfg.DeltaSpeedMax = stats.ConvertSpeed(mode.deltaSpeedMax, stats.UnitsKts, speedUnits)
+		}
+		if cleanUpCfg.MaxSpeed == 0 {
+			cleanUpCfg.MaxSpeed = stats.ConvertSpeed(mode.maxSpeed, stats.UnitsKts, speedUnits)
+		}
+		if cleanUpCfg.TrimSpeed == 0 {
+			cleanUpCfg.TrimSpeed = stats.ConvertSpeed(mode.trimSpeed, stats.UnitsKts, speedUnits)
+		}
+		if cleanUpCfg.DopplerOffset == 0 {
+			cleanUpCfg.DopplerOffset = stats.ConvertSpeed(mode.dopplerOffset, stats.UnitsKts, speedUnits)
+		}
+		gapTrim, err := parseGapTrim(*gapTrimFlag)
+		if err != nil {
+			fmt.Printf("Error parsing gap trim '%s': %v\n", *gapTrimFlag, err)
+			os.Exit(2)
+		}
+		cleanUpCfg.GapTrim = &gapTrim
+		distModel, err := stats.ParseDistanceModel(*distModelFlag)
+		if err == nil {
+			err = stats.SetDistanceModel(distModel)
+		}
+		if err != nil {
+			fmt.Printf("Error setting distance model '%s': %v\n", *distModelFlag, err)
+			os.Exit(2)
+		}
+		if *bboxFlag != "" {
+			area, err := stats.ParseArea(*bboxFlag)
+			if err != nil {
+				fmt.Printf("Error parsing area '%s': %v\n", *bboxFlag, err)
+				os.Exit(2)
+			}
+			cleanUpCfg.Area = &area
+		}
+		cleanUpCfg.Exclude = excludeFlag
+		filters, err := stats.NewCleanUpFilters(cleanupNames, cleanUpCfg)
+		if err != nil {
+			fmt.Printf("Error parsing cleanup filters '%s': %v\n", cleanupNames, err)
+			os.Exit(2)
+		}
+
+		gates := []stats.Gate{}
+		if *gatesFlag != "" {
+			var err error
+			gates, err = parseGates(*gatesFlag)
+			if err != nil {
+				fmt.Printf("Error parsing gates '%s': %v\n", *gatesFlag, err)
+				os.Exit(2)
+			}
 		}
+
+		spots := []stats.Spot{}
+		if *spotsFlag != "" {
+			var err error
+			spots, err = readSpots(*spotsFlag)
+			if err != nil {
+				fmt.Printf("Error reading spots from '%s': %v\n", *spotsFlag, err)
+				os.Exit(2)
+			}
+		}
+
+		if *outFlag != "" && *outDirFlag != "" || *mergeFlag && *outDirFlag != "" {
+			showUsage(2)
+			return
+		}
+		if *outFlag != "" {
+			openFile := createOutput
+			if *watchFlag != "" {
+				// Statistics of each watched file are added to the session log.
+				openFile = appendOutput
+			}
+			f, err := openFile(*outFlag)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			defer closeOutput(f)
+			out = f
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		runCtx = ctx
+
+		if *followFlag > 0 {
+			if len(flag.Args()) != 1 || *mergeFlag {
+				showUsage(2)
+				return
+			}
+			followFile(flag.Args()[0], statType, speedUnits, filters, *followFlag)
+			return
+		}
+
+		if *watchFlag != "" {
+			if len(flag.Args()) > 0 || *mergeFlag {
+				showUsage(2)
+				return
+			}
+			watchDir(*watchFlag, *reprocessFlag, func(out io.Writer, filePath string) {
+				printStatsForFileOutput(out, filePath, statType, speedUnits, filters, gates, spots)
+			})
+			return
+		}
+
+		if *compareFlag {
+			if len(flag.Args()) != 2 || *mergeFlag || *outputFlag != "txt" {
+				showUsage(2)
+				return
+			}
+			compareFiles(flag.Args(), statType, speedUnits, filters)
+			return
+		}
+
+		if *mergeFlag {
+			printMergedStats(flag.Args(), statType, speedUnits, filters, gates, spots)
+			return
+		}
+
+		workers := *parallelFlag
+		if workers > len(flag.Args()) {
+			workers = len(flag.Args())
+		}
+		printStatsForFiles(flag.Args(), workers, func(out io.Writer, filePath string) {
+			printStatsForFileOutput(out, filePath, statType, speedUnits, filters, gates, spots)
+		})
 	}
 }
 
-func printStatsForFile(filePath string, statType stats.StatFlag, speedUnits stats.UnitsFlag) {
-	f, err := os.Open(filePath)
+// printStatsForFiles prints statistics for each file, processing up to
+// workers files in parallel. Output of files processed in parallel is
+// collected and printed in the order of files.
+func printStatsForFiles(filePaths []string, workers int, process func(out io.Writer, filePath string)) {
+	if workers <= 1 {
+		for i := 0; i < len(filePaths) && runCtx.Err() == nil; i++ {
+			process(out, filePaths[i])
+		}
+		return
+	}
+
+	showProgress = false
+	results := make([]chan *bytes.Buffer, len(filePaths))
+	for i := 0; i < len(results); i++ {
+		results[i] = make(chan *bytes.Buffer, 1)
+	}
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				buf := &bytes.Buffer{}
+				// Files not started before the interrupt are skipped.
+				if runCtx.Err() == nil {
+					process(buf, filePaths[i])
+				}
+				results[i] <- buf
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < len(filePaths); i++ {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	for i := 0; i < len(results); i++ {
+		buf := <-results[i]
+		_, _ = out.Write(buf.Bytes())
+	}
+}
+
+// printStatsForFileOutput prints statistics for the file to out or, with
+// -outdir, to a file in the directory named after it.
+func printStatsForFileOutput(out io.Writer, filePath string,
+	statType stats.StatFlag, speedUnits stats.UnitsFlag,
+	filters []stats.Filter, gates []stats.Gate, spots []stats.Spot) {
+	if *outDirFlag == "" {
+		printStatsForFile(out, filePath, statType, speedUnits, filters, gates, spots)
+		return
+	}
+	ext := ".stats.txt"
+	if *outputFlag == "ndjson" {
+		ext = ".stats.ndjson"
+	}
+	f, err := createOutput(filepath.Join(*outDirFlag, filepath.Base(filePath)+ext))
 	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		atomic.AddInt32(&fileErrors, 1)
 		return
 	}
+	printStatsForFile(f, filePath, statType, speedUnits, filters, gates, spots)
+	closeOutput(f)
+}
 
-	fileName := filepath.Base(f.Name())
+// createOutput creates the output file, creating directories as needed.
+func createOutput(path string) (*os.File, error) {
+	return openOutput(path, os.O_TRUNC)
+}
 
-	r := bufio.NewReader(f)
+// appendOutput opens the output file for appending, creating it and
+// directories as needed.
+func appendOutput(path string) (*os.File, error) {
+	return openOutput(path, os.O_APPEND)
+}
 
-	points, err := stats.ReadPoints(r)
+// openOutput opens the output file for writing with the additional flag,
+// creating it and directories as needed.
+func openOutput(path string, flag int) (*os.File, error) {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, errs.Errorf("Error creating output directory '%s': %v", dir, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0o666)
+	if err != nil {
+		return nil, errs.Errorf("Error creating output file '%s': %v", path, err)
+	}
+	return f, nil
+}
 
-	if err != nil && err != io.EOF {
-		fmt.Printf("Error reading track points from '%s': %v\n", fileName, err)
-		if statType == stats.StatAll {
-			fmt.Println("")
+// closeOutput closes the output file, printing an error if all statistics
+// could not be written.
+func closeOutput(f *os.File) {
+	err := f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output file '%s': %v\n", f.Name(), err)
+		atomic.AddInt32(&fileErrors, 1)
+	}
+}
+
+func printStatsForFile(out io.Writer, filePath string,
+	statType stats.StatFlag, speedUnits stats.UnitsFlag,
+	filters []stats.Filter, gates []stats.Gate, spots []stats.Spot) {
+	points, ok := readPointsFile(out, filePath, statType)
+	if !ok {
+		return
+	}
+	printStatsForPoints(out, filePath, filepath.Base(filePath), points,
+		statType, speedUnits, filters, gates, spots)
+}
+
+// printMergedStats prints statistics for points of all files merged into
+// a single session. Files saved with -sf and -o gpssurfing are named after
+// the first file. Unreadable files are reported and counted as errors, the
+// rest are merged.
+func printMergedStats(filePaths []string, statType stats.StatFlag, speedUnits stats.UnitsFlag,
+	filters []stats.Filter, gates []stats.Gate, spots []stats.Spot) {
+	pointsList := []stats.Points{}
+	readPaths := []string{}
+	fileNames := []string{}
+	for i := 0; i < len(filePaths); i++ {
+		points, ok := readPointsFile(out, filePaths[i], statType)
+		if !ok {
+			continue
 		}
+		pointsList = append(pointsList, points)
+		readPaths = append(readPaths, filePaths[i])
+		fileNames = append(fileNames, filepath.Base(filePaths[i]))
+	}
+	if len(pointsList) == 0 {
 		return
 	}
 
+	points, duplicates := stats.MergePoints(pointsList)
+	fileName := strings.Join(fileNames, "+")
+	if *outputFlag == "txt" && duplicates > 0 {
+		fmt.Fprintf(out, "Merged %d files, removed %d points with duplicate timestamps.\n",
+			len(pointsList), duplicates)
+	}
+	printStatsForPoints(out, readPaths[0], fileName, points, statType, speedUnits, filters, gates, spots)
+}
+
+// compareFiles prints statistics of 2 files side by side with differences
+// of the second file from the first one.
+func compareFiles(filePaths []string, statType stats.StatFlag, speedUnits stats.UnitsFlag,
+	filters []stats.Filter) {
+	fileNames := []string{}
+	ss := []stats.Stats{}
+	for i := 0; i < len(filePaths); i++ {
+		fileName := filepath.Base(filePaths[i])
+		points, ok := readPointsFile(out, filePaths[i], statType)
+		if !ok {
+			return
+		}
+		points, err := filterTimeWindow(points)
+		if err != nil {
+			printFileError(out, fileName, statType,
+				fmt.Sprintf("Error filtering track points from '%s': %v", fileName, err))
+			return
+		}
+		ps, _ := stats.CleanUpWith(points, filters)
+		if *smoothFlag {
+			ps = stats.Smooth(ps)
+		}
+		if len(ps) < 2 {
+			printFileError(out, fileName, statType,
+				fmt.Sprintf("Not enough track points in '%s' to calculate statistics, %d of %d left after cleanup.",
+					fileName, len(ps), len(points.Ps)))
+			return
+		}
+		s, err := calculateStats(ps, fileName, statType, speedUnits)
+		if err != nil {
+			printFileError(out, fileName, statType,
+				fmt.Sprintf("Error calculating statistics from '%s': %v", fileName, err))
+			return
+		}
+		fileNames = append(fileNames, fileName)
+		ss = append(ss, s)
+	}
+	printComparison(out, fileNames, ss[0], ss[1], statType)
+}
+
+// compareRow is a statistic of 2 sessions compared with -compare, a value
+// is not available if its ok is false. Greater values are better.
+type compareRow struct {
+	name string
+	a    float64
+	okA  bool
+	b    float64
+	okB  bool
+}
+
+// compareTracks returns the row comparing speeds of 2 tracks.
+func compareTracks(name string, a, b stats.Track) compareRow {
+	return compareRow{name: name, a: a.Speed(), okA: a.Valid(), b: b.Speed(), okB: b.Valid()}
+}
+
+// compareTop10s returns the row comparing speeds of the 10 second tracks at
+// the index, not available if there is no such track.
+func compareTop10s(idx int, a, b stats.Stats) compareRow {
+	row := compareRow{name: fmt.Sprintf("Top %d 5x10", idx+1)}
+	if tracks := a.Speed5x10s(); idx < len(tracks) {
+		row.a, row.okA = tracks[idx].Speed(), tracks[idx].Valid()
+	}
+	if tracks := b.Speed5x10s(); idx < len(tracks) {
+		row.b, row.okB = tracks[idx].Speed(), tracks[idx].Valid()
+	}
+	return row
+}
+
+// printComparison prints statistics of 2 sessions in aligned columns with
+// the difference of the second one from the first one (absolute and in
+// percents) and '*' next to the better value.
+func printComparison(out io.Writer, fileNames []string, a, b stats.Stats, statType stats.StatFlag) {
+	avgA, okA := stats.CalcTracksAvg(a.Speed5x10s())
+	avgB, okB := stats.CalcTracksAvg(b.Speed5x10s())
+	rows := []compareRow{
+		{name: "Total Distance km", a: a.Distance() / 1000, okA: true, b: b.Distance() / 1000, okB: true},
+		{name: "Total Duration h", a: a.Duration().Hours(), okA: true, b: b.Duration().Hours(), okB: true},
+		{name: "Moving Duration h", a: a.MovingDuration().Hours(), okA: true,
+			b: b.MovingDuration().Hours(), okB: true},
+	}
+	if statType&stats.Stat2s != 0 {
+		rows = append(rows, compareTracks("2 Second Peak", a.Speed2s(), b.Speed2s()))
+	}
+	if statType&stats.Stat10sAvg != 0 {
+		rows = append(rows, compareRow{name: "5x10 Average", a: avgA, okA: okA, b: avgB, okB: okB})
+	}
+	top10s := []stats.StatFlag{stats.Stat10s1, stats.Stat10s2, stats.Stat10s3, stats.Stat10s4, stats.Stat10s5}
+	for i := 0; i < len(top10s); i++ {
+		if statType&top10s[i] != 0 {
+			rows = append(rows, compareTop10s(i, a, b))
+		}
+	}
+	if statType&stats.Stat15m != 0 {
+		rows = append(rows, compareTracks("15 Min", a.Speed15m(), b.Speed15m()))
+	}
+	if statType&stats.Stat1h != 0 {
+		rows = append(rows, compareTracks("1 Hr", a.Speed1h(), b.Speed1h()))
+	}
+	if statType&stats.Stat100m != 0 {
+		rows = append(rows, compareTracks("100m peak", a.Speed100m(), b.Speed100m()))
+	}
+	if statType&stats.Stat1nm != 0 {
+		rows = append(rows, compareTracks("Nautical Mile", a.Speed1NM(), b.Speed1NM()))
+	}
+	if statType&stats.StatAlpha != 0 {
+		rows = append(rows, compareTracks("Alpha 500", a.Alpha500(), b.Alpha500()))
+	}
+
+	width := 12
+	for i := 0; i < len(fileNames); i++ {
+		if len(fileNames[i])+2 > width {
+			width = len(fileNames[i]) + 2
+		}
+	}
+	// value formats the value of the row with the better value marked.
+	value := func(v float64, ok, better bool) string {
+		if !ok {
+			return "n/a  "
+		}
+		if better {
+			return fmt.Sprintf("%.*f *", *precisionFlag, v)
+		}
+		return fmt.Sprintf("%.*f  ", *precisionFlag, v)
+	}
+
+	fmt.Fprintf(out, "Comparing '%s' with '%s' (speeds in %s, delta is the second minus the first).\n",
+		fileNames[0], fileNames[1], a.SpeedUnits())
+	fmt.Fprintf(out, "%-20s%*s%*s  %s\n", "", width, fileNames[0], width, fileNames[1], "Delta")
+	for i := 0; i < len(rows); i++ {
+		r := rows[i]
+		delta := ""
+		if r.okA && r.okB {
+			delta = fmt.Sprintf("%+.*f", *precisionFlag, r.b-r.a)
+			if r.a != 0 {
+				delta += fmt.Sprintf(" (%+.1f%%)", (r.b-r.a)/r.a*100)
+			}
+		}
+		both := r.okA && r.okB
+		line := fmt.Sprintf("%-20s%*s%*s  %s", r.name+":",
+			width, value(r.a, r.okA, both && r.a > r.b),
+			width, value(r.b, r.okB, both && r.b > r.a), delta)
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+}
+
+// followFile re-reads the file every interval while it is growing (e.g.
+// written by a live GPS) and prints a summary line after new points are
+// added. Statistics are updated only from new cleaned up points, points read
+// before the end of a partially written file are used.
+func followFile(filePath string, statType stats.StatFlag, speedUnits stats.UnitsFlag,
+	filters []stats.Filter, interval time.Duration) {
+	b := stats.NewStatsBuilder(statType, speedUnits, stats.DefaultCalcOptions())
+	fileName := filepath.Base(filePath)
+	var last time.Time
+	var modTime time.Time
+	for ; runCtx.Err() == nil; sleepCtx(interval) {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading '%s': %v\n", fileName, err)
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			continue
+		}
+		points, _ := stats.ReadPoints(bufio.NewReader(f), stats.WithSbnLeapSeconds(*leapFlag))
+		f.Close()
+		modTime = info.ModTime()
+
+		ps, _ := stats.CleanUpWith(points, filters)
+		added := 0
+		for i := 0; i < len(ps); i++ {
+			if ps[i].Time().After(last) {
+				b.Add(ps[i])
+				last = ps[i].Time()
+				added++
+			}
+		}
+		if added > 0 {
+			s := b.Snapshot().WithPrecision(*precisionFlag)
+			fmt.Fprintf(out, "%s\t%s\t%s\n", last.Format("15:04:05"), s.TxtSummary(), fileName)
+		}
+	}
+}
+
+// watchDir checks the directory every watchInterval for new or modified
+// track files and prints statistics for each of them once its size and
+// modification time stop changing, until interrupted. Processed files are
+// recorded with their modification time in watchStateFile in the directory,
+// so they are not processed again after a restart unless reprocess is set.
+func watchDir(dir string, reprocess bool, process func(out io.Writer, filePath string)) {
+	statePath := filepath.Join(dir, watchStateFile)
+	processed := map[string]time.Time{}
+	if !reprocess {
+		var err error
+		processed, err = readWatchState(statePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading watch state '%s': %v\n", statePath, err)
+			atomic.AddInt32(&fileErrors, 1)
+			return
+		}
+	}
+
+	// pending contains changed files seen by the previous check.
+	pending := map[string]os.FileInfo{}
+	for ; runCtx.Err() == nil; sleepCtx(watchInterval) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading directory '%s': %v\n", dir, err)
+			continue
+		}
+		for i := 0; i < len(entries) && runCtx.Err() == nil; i++ {
+			name := entries[i].Name()
+			if !entries[i].Type().IsRegular() || !isWatchedTrack(name) {
+				continue
+			}
+			info, err := entries[i].Info()
+			if err != nil {
+				continue
+			}
+			if modTime, ok := processed[name]; ok && modTime.Equal(info.ModTime()) {
+				continue
+			}
+			prev, ok := pending[name]
+			pending[name] = info
+			if !ok || prev.Size() != info.Size() || !prev.ModTime().Equal(info.ModTime()) {
+				// Still growing, checked again next time.
+				continue
+			}
+
+			delete(pending, name)
+			process(out, filepath.Join(dir, name))
+			if runCtx.Err() != nil {
+				// Interrupted file is processed again after a restart.
+				return
+			}
+			processed[name] = info.ModTime()
+			err = appendWatchState(statePath, name, info.ModTime())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing watch state '%s': %v\n", statePath, err)
+			}
+		}
+	}
+}
+
+// isWatchedTrack checks if the file name is a GPX or SBN track, ignoring
+// hidden files and tracks saved by gps-stats (-sf and -o gpssurfing).
+func isWatchedTrack(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, ".") ||
+		strings.HasSuffix(lower, ".filtered.gpx") || strings.HasSuffix(lower, ".gpssurfing.gpx") {
+		return false
+	}
+	return strings.HasSuffix(lower, ".gpx") || strings.HasSuffix(lower, ".sbn")
+}
+
+// readWatchState reads names and modification times of processed files
+// from the watch state file, with a line 'modTime<TAB>name' per file. The
+// last line of a file wins. A missing state file is empty.
+func readWatchState(path string) (map[string]time.Time, error) {
+	res := map[string]time.Time{}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			return res, fmt.Errorf("invalid line %d", lineNo)
+		}
+		modTime, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return res, fmt.Errorf("invalid time in line %d: %v", lineNo, err)
+		}
+		res[fields[1]] = modTime
+	}
+	return res, scanner.Err()
+}
+
+// appendWatchState records the processed file in the watch state file.
+func appendWatchState(path, name string, modTime time.Time) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\t%s\n", modTime.UTC().Format(time.RFC3339Nano), name)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sleepCtx pauses for the duration or until runCtx is canceled.
+func sleepCtx(d time.Duration) {
+	select {
+	case <-runCtx.Done():
+	case <-time.After(d):
+	}
+}
+
+// newProgress returns a Progress printing the percentage of each phase
+// completed for the file on stderr.
+func newProgress(fileName string) stats.Progress {
+	return func(phase string, points int, fraction float64) {
+		name := "Calculating statistics for"
+		switch phase {
+		case "read":
+			name = "Reading"
+		case "5x10":
+			name = "Calculating 5x10 for"
+		}
+		fmt.Fprintf(os.Stderr, "\r%s '%s': %3d%%", name, fileName, int(fraction*100))
+		if fraction >= 1 {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+// readPointsFile reads track points from the file, printing read errors.
+// Returns false if points can't be used.
+func readPointsFile(out io.Writer, filePath string, statType stats.StatFlag) (stats.Points, bool) {
+	fileName := filepath.Base(filePath)
+	f, err := os.Open(filePath)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		printFileError(out, fileName, statType, fmt.Sprintf("Error opening '%s': %v", filePath, err))
+		return stats.Points{}, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err == nil && info.Size() == 0 {
+		printFileError(out, fileName, statType, fmt.Sprintf("File '%s' is empty.", filePath))
+		return stats.Points{}, false
+	}
+
+	r := bufio.NewReader(f)
+
+	var size int64
+	var progress stats.Progress
+	if showProgress && err == nil && info.Size() >= progressMinSize {
+		size = info.Size()
+		progress = newProgress(fileName)
+	}
+	points, err := stats.ReadPointsCtx(runCtx, r, fileName, size, progress,
+		stats.WithSbnLeapSeconds(*leapFlag))
+
+	if errors.Is(err, errs.ErrTruncated) && len(points.Ps) > 0 {
+		printFileNote(out, fmt.Sprintf("File '%s' appears truncated, statistics computed on partial data.\n",
+			fileName))
+	} else if err != nil && err != io.EOF {
+		printFileError(out, fileName, statType,
+			fmt.Sprintf("Error reading track points from '%s': %v", fileName, err))
+		return points, false
+	}
+	if points.TimesFilled > 0 {
+		printFileNote(out, fmt.Sprintf("Interpolated %d missing timestamps in '%s'.\n",
+			points.TimesFilled, fileName))
+	}
+	return points, true
+}
+
+// printFileNote prints a note about the file with statistics in txt output,
+// to stderr otherwise.
+func printFileNote(out io.Writer, msg string) {
+	if *outputFlag == "txt" {
+		fmt.Fprint(out, msg)
+	} else {
+		fmt.Fprint(os.Stderr, msg)
+	}
+}
+
+// filterTimeWindow returns points inside the time window set by -from and
+// -to, all points if the time window is not set.
+func filterTimeWindow(points stats.Points) (stats.Points, error) {
+	if (*fromFlag == "" && *toFlag == "") || len(points.Ps) == 0 {
+		return points, nil
+	}
+	day := points.Ps[0].Time()
+	from, err := parseTimeWindowValue(*fromFlag, day)
+	if err != nil {
+		return points, err
+	}
+	to, err := parseTimeWindowValue(*toFlag, day)
+	if err != nil {
+		return points, err
+	}
+	return stats.FilterTimeWindow(points, from, to)
+}
+
+// printStatsForPoints cleans up points read from the file and prints
+// statistics.
+func printStatsForPoints(out io.Writer, filePath, fileName string, points stats.Points,
+	statType stats.StatFlag, speedUnits stats.UnitsFlag,
+	filters []stats.Filter, gates []stats.Gate, spots []stats.Spot) {
 	pointsNo := len(points.Ps)
-	cleanupDeltaSpeed := *cleanupDeltaSpeedFlag
-	if cleanupDeltaSpeed == 0 {
-		cleanupDeltaSpeed = stats.MsToUnits(stats.KtsToMs(5.0), speedUnits)
+	if *validateFlag {
+		anomalies := points.Validate()
+		fmt.Fprintf(out, "Found %d anomalies in %d track points in '%s'.\n", len(anomalies), pointsNo, fileName)
+		for i := 0; i < len(anomalies); i++ {
+			fmt.Fprintln(out, anomalies[i])
+		}
+		return
+	}
+
+	if *perSessionFlag {
+		// Mark sessions before cleanup removes points around the gaps.
+		points = points.MarkGapSegments(*sessionGapFlag)
+	}
+
+	timeWindow := *fromFlag != "" || *toFlag != ""
+	points, err := filterTimeWindow(points)
+	if err != nil {
+		printFileError(out, fileName, statType,
+			fmt.Sprintf("Error filtering track points from '%s': %v", fileName, err))
+		return
+	}
+	pointsWindowNo := len(points.Ps)
+
+	psMarked, reports := stats.CleanUpMarked(points, filters)
+	ps := stats.ValidPoints(psMarked)
+	if *smoothFlag {
+		ps = stats.Smooth(ps)
 	}
-	ps := stats.CleanUp(points, cleanupDeltaSpeed, speedUnits)
 	points.Ps = ps
 	pointsCleanedNo := len(ps)
 
-	if *saveFilteredGpxFlag {
+	if *saveFilteredGpxFlag && runCtx.Err() == nil {
 		newFilePath := filePath + ".filtered.gpx"
 		f, err := os.Create(newFilePath)
 		if err != nil {
-			fmt.Printf("Error creating new file '%s' for GPX export: %v\n", newFilePath, err)
-			if statType == stats.StatAll {
-				fmt.Println("")
-			}
+			printFileError(out, fileName, statType,
+				fmt.Sprintf("Error creating new file '%s' for GPX export: %v", newFilePath, err))
 			return
 		}
 
-		err = stats.SavePointsAsGpx(points, f)
+		saved := points
+		if *saveInvalidFlag {
+			saved.Ps = psMarked
+		}
+		if *saveMeasuredFlag {
+			saved = saved.Measured()
+		}
+		err = stats.SavePointsAsGpx(saved, f)
 		if err != nil {
-			fmt.Printf("Error saving file '%s' for GPX export: %v\n", newFilePath, err)
+			printFileError(out, fileName, statType,
+				fmt.Sprintf("Error saving file '%s' for GPX export: %v", newFilePath, err))
+			return
+		}
+
+		if *outputFlag == "ndjson" {
+			fmt.Fprintf(os.Stderr, "Filtered GPX file '%s' saved.\n", newFilePath)
+		} else {
+			fmt.Fprintf(out, "Filtered GPX file '%s' saved.\n", newFilePath)
 			if statType == stats.StatAll {
-				fmt.Println("")
+				fmt.Fprintln(out)
 			}
-			return
 		}
+	}
 
-		fmt.Printf("Filtered GPX file '%s' saved.\n", newFilePath)
-		if statType == stats.StatAll {
-			fmt.Println("")
+	if pointsCleanedNo < 2 {
+		printFileError(out, fileName, statType,
+			fmt.Sprintf("Not enough track points in '%s' to calculate statistics, %d of %d left after cleanup.",
+				fileName, pointsCleanedNo, pointsNo))
+		return
+	}
+
+	s, err := calculateStats(ps, fileName, statType, speedUnits)
+	if err != nil {
+		printFileError(out, fileName, statType,
+			fmt.Sprintf("Error calculating statistics from '%s': %v", fileName, err))
+		return
+	}
+	centroidLat, centroidLon := points.Centroid()
+	if spot, ok := stats.FindSpot(spots, centroidLat, centroidLon); ok {
+		s = s.WithSpot(spot.Name)
+	}
+
+	if *outputFlag == "gpssurfing" {
+		printGpsSurfing(out, filePath, fileName, points, s)
+		return
+	}
+
+	// With -split-sessions, statistics of multiple sessions separated by long
+	// gaps are calculated only per session, so no duration-based track spans
+	// a gap.
+	splitSessions := false
+	if *splitSessionsFlag && !*perSessionFlag {
+		_, maxInt := stats.SamplingIntervals(ps)
+		splitSessions = maxInt > sessionGapFlag.Seconds()
+	}
+
+	if *outputFlag == "ndjson" {
+		res := fileStatsJSON{File: fileName, Points: pointsNo, PointsCleaned: pointsCleanedNo, Stats: &s}
+		if splitSessions {
+			res.Stats = nil
+		}
+		if len(gates) > 0 {
+			laps := stats.CalculateLaps(ps, gates, *gatesSpeedFlag, speedUnits)
+			res.Laps = &laps
 		}
+		printJSONLine(out, res)
+		if *perSessionFlag || splitSessions {
+			printSessionStats(out, ps, fileName, statType, speedUnits)
+		}
+		return
 	}
 
-	s := stats.CalculateStats(ps, statType, speedUnits)
+	if statType == stats.StatAll {
+		if timeWindow {
+			fmt.Fprintf(out, "Found %d track points in '%s', %d inside time window, after cleanup %d points left.\n",
+				pointsNo, fileName, pointsWindowNo, pointsCleanedNo)
+		} else {
+			fmt.Fprintf(out, "Found %d track points in '%s', after cleanup %d points left.\n",
+				pointsNo, fileName, pointsCleanedNo)
+		}
+		printCleanUpReport(out, reports, pointsWindowNo, *detailsFlag)
+	}
+
+	if splitSessions {
+		printSplitSessions(out, ps, fileName, statType, speedUnits)
+	} else {
+		printStats(out, s, fileName, statType)
+		printDump(out, s)
+		if *perSessionFlag {
+			printSessionStats(out, ps, fileName, statType, speedUnits)
+		}
+	}
+
+	if len(gates) > 0 {
+		laps := stats.CalculateLaps(ps, gates, *gatesSpeedFlag, speedUnits)
+		if *gatesJSONFlag {
+			lapsJSON, err := laps.JSONLaps()
+			if err != nil {
+				fmt.Fprintf(out, "Error formatting laps from '%s': %v\n", fileName, err)
+				atomic.AddInt32(&fileErrors, 1)
+				return
+			}
+			fmt.Fprintln(out, lapsJSON)
+		} else {
+			fmt.Fprint(out, laps.TxtLaps())
+		}
+		fmt.Fprintln(out)
+	}
+}
 
+// printStats prints statistics for the whole file.
+func printStats(out io.Writer, s stats.Stats, fileName string, statType stats.StatFlag) {
 	switch statType {
 	case stats.StatAll:
-		fmt.Printf("Found %d track points in '%s', after cleanup %d points left.\n",
-			pointsNo, fileName, pointsCleanedNo)
-		fmt.Print(s.TxtStats())
+		fmt.Fprint(out, s.TxtStats())
+	case stats.StatRose:
+		fmt.Fprintf(out, "Heading rose for '%s':\n", fileName)
+		fmt.Fprint(out, s.TxtSingleStat(statType))
+	case stats.StatSummary:
+		fmt.Fprintf(out, "%s\t%s", s.TxtSummary(), fileName)
+	case stats.StatBest:
+		fmt.Fprintf(out, "Best results in '%s':\n", fileName)
+		fmt.Fprint(out, s.TxtBest())
 	default:
-		fmt.Printf("%s (%s)", s.TxtSingleStat(statType), fileName)
+		printSelectedStats(out, s, statType, fileName)
 	}
-	fmt.Println("")
+	fmt.Fprintln(out)
+}
+
+// printDump prints all points of the track of the statistic selected with
+// -dump.
+func printDump(out io.Writer, s stats.Stats) {
+	track, ok := dumpTracks[*dumpFlag]
+	if !ok {
+		return
+	}
+	t := track(s)
+	fmt.Fprintf(out, "Points of the %s track: %s\n", *dumpFlag, t.TxtLinePrecision(*precisionFlag))
+	if t.Valid() {
+		fmt.Fprint(out, t.TxtPoints(*precisionFlag))
+	}
+	fmt.Fprintln(out)
+}
+
+// printSplitSessions prints statistics for each session of a file with
+// gaps longer than session gap and the total distance & duration of all
+// sessions.
+func printSplitSessions(out io.Writer, ps []stats.Point, fileName string,
+	statType stats.StatFlag, speedUnits stats.UnitsFlag) {
+	if statType == stats.StatAll {
+		fmt.Fprintf(out, "Gaps longer than %v found, statistics are calculated per session.\n\n", *sessionGapFlag)
+	}
+	printSessionStats(out, ps, fileName, statType, speedUnits)
+	if statType != stats.StatAll {
+		return
+	}
+
+	sessions := stats.SplitSessions(ps, *sessionGapFlag)
+	distance := 0.0
+	var duration time.Duration
+	for i := 0; i < len(sessions); i++ {
+		s := stats.CalculateStats(sessions[i], stats.StatNone, speedUnits)
+		distance += s.Distance()
+		duration += s.Duration()
+	}
+	fmt.Fprintf(out, "Total of %d sessions in '%s':\n", len(sessions), fileName)
+	width := *precisionFlag + 3
+	if *precisionFlag == 0 {
+		width = 2
+	}
+	fmt.Fprintf(out, "Total Distance:     %0*.*f km\n", width, *precisionFlag, distance/1000)
+	fmt.Fprintf(out, "Total Duration:     %06.3f h\n", duration.Hours())
+	fmt.Fprintln(out)
 }
 
-func showVersion() {
+// printFileError prints an error for the file in the selected output format.
+func printFileError(out io.Writer, fileName string, statType stats.StatFlag, msg string) {
+	atomic.AddInt32(&fileErrors, 1)
+	if *outputFlag == "ndjson" {
+		printJSONLine(out, fileStatsJSON{File: fileName, Error: msg})
+		return
+	}
+	fmt.Fprintln(out, msg)
+	if statType == stats.StatAll {
+		fmt.Fprintln(out)
+	}
+}
+
+// printJSONLine prints a value as a single line of JSON. Stdout is not
+// buffered so each line is available to consumers as soon as it's printed.
+func printJSONLine(out io.Writer, v interface{}) {
+	err := json.NewEncoder(out).Encode(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error formatting JSON: %v\n", err)
+	}
+}
+
+// printSelectedStats prints each selected statistic in a separate line,
+// labeled by the statistic name if more than one is selected.
+func printSelectedStats(out io.Writer, s stats.Stats, statType stats.StatFlag, fileName string) {
+	statFlags := statType.Flags()
+	if len(statFlags) == 1 {
+		fmt.Fprintf(out, "%s (%s)", s.TxtSingleStat(statType), fileName)
+		printTopAlphas(out, s, statType)
+		printTop10s(out, s, statType)
+		return
+	}
+	for i := 0; i < len(statFlags); i++ {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "%-7s %s (%s)", statFlags[i].String()+":", s.TxtSingleStat(statFlags[i]), fileName)
+		printTopAlphas(out, s, statFlags[i])
+		printTop10s(out, s, statFlags[i])
+	}
+}
+
+// printTopAlphas prints alphas slower than the best one in separate lines
+// if the alpha statistic is selected.
+func printTopAlphas(out io.Writer, s stats.Stats, statType stats.StatFlag) {
+	if statType != stats.StatAlpha {
+		return
+	}
+	for i := 1; i < len(s.Alphas()); i++ {
+		fmt.Fprintf(out, "\n  Top %d alpha: %s", i+1, s.TxtTopAlpha(i))
+	}
+}
+
+// printTop10s prints each of the 5x10 tracks in separate lines if the 5x10
+// average statistic is selected with -verbose.
+func printTop10s(out io.Writer, s stats.Stats, statType stats.StatFlag) {
+	if statType != stats.Stat10sAvg || !*verboseFlag {
+		return
+	}
+	for i := 0; i < len(s.Speed5x10s()); i++ {
+		fmt.Fprintf(out, "\n  Top %d 5x10 speed: %s", i+1, s.TxtTop10s(i))
+	}
+}
+
+// calculateStats calculates statistics with the options set by flags.
+func calculateStats(ps []stats.Point, fileName string,
+	statType stats.StatFlag, speedUnits stats.UnitsFlag) (stats.Stats, error) {
+	opts := stats.DefaultCalcOptions()
+	opts.AlphaTopCount = *topNFlag
+	var progress stats.Progress
+	if showProgress && len(ps) >= progressMinPoints {
+		progress = newProgress(fileName)
+	}
+	s, err := stats.CalculateStatsCtx(runCtx, ps, statType, speedUnits, opts, progress)
+	if err != nil {
+		return s, err
+	}
+	s = s.ShowDeviceSpeed(*compareSpeedFlag).WithPrecision(*precisionFlag).
+		WithSmoothing(*smoothFlag)
+	if *interpolateFlag {
+		s = s.Interpolate2s(ps).Interpolate5x10s(ps)
+	}
+	if *hrMaxFlag > 0 {
+		s = s.WithHrZones(ps, *hrMaxFlag)
+	}
+	return s, nil
+}
+
+// printSessionStats prints statistics for each session found in points.
+func printSessionStats(out io.Writer, ps []stats.Point, fileName string,
+	statType stats.StatFlag, speedUnits stats.UnitsFlag) {
+	sessions := stats.SplitSessions(ps, *sessionGapFlag)
+	for i := 0; i < len(sessions); i++ {
+		s, err := calculateStats(sessions[i], fileName, statType, speedUnits)
+		if err != nil {
+			printFileError(out, fileName, statType,
+				fmt.Sprintf("Error calculating statistics from '%s': %v", fileName, err))
+			return
+		}
+		if *outputFlag == "ndjson" {
+			printJSONLine(out, fileStatsJSON{File: fileName, Session: i + 1,
+				PointsCleaned: len(sessions[i]), Stats: &s})
+			continue
+		}
+		switch statType {
+		case stats.StatAll:
+			fmt.Fprintf(out, "Session %d of %d in '%s', %d points (%v - %v).\n",
+				i+1, len(sessions), fileName, len(sessions[i]),
+				sessions[i][0].Time(), sessions[i][len(sessions[i])-1].Time())
+			fmt.Fprint(out, s.TxtStats())
+		case stats.StatRose:
+			fmt.Fprintf(out, "Heading rose for session %d of %d in '%s':\n", i+1, len(sessions), fileName)
+			fmt.Fprint(out, s.TxtSingleStat(statType))
+		case stats.StatSummary:
+			fmt.Fprintf(out, "%s\t%s#%d", s.TxtSummary(), fileName, i+1)
+		case stats.StatBest:
+			fmt.Fprintf(out, "Best results in session %d of %d in '%s':\n", i+1, len(sessions), fileName)
+			fmt.Fprint(out, s.TxtBest())
+		default:
+			printSelectedStats(out, s, statType, fmt.Sprintf("%s, session %d", fileName, i+1))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// printCleanUpReport prints a single line with the number of points removed
+// by each cleanup filter and, if detailed, all removed ranges of points.
+func printCleanUpReport(out io.Writer, reports []stats.Report, pointsNo int, detailed bool) {
+	parts := []string{}
+	for i := 0; i < len(reports); i++ {
+		r := reports[i]
+		if r.Added > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d added", r.Filter, r.Added))
+		}
+		if r.Jumps > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d jumps", r.Filter, r.Jumps))
+		}
+		if r.Removed == 0 {
+			continue
+		}
+		if r.Filter == "out of order" {
+			parts = append(parts, fmt.Sprintf("%s %d sorted", r.Filter, r.Removed))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s %d removed", r.Filter, r.Removed))
+		}
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(out, "Cleanup: %s.\n", strings.Join(parts, ", "))
+	}
+
+	for i := 0; i < len(reports); i++ {
+		r := reports[i]
+		if r.Filter == "area" && float64(r.Removed) > areaRemovedWarning*float64(pointsNo) {
+			fmt.Fprintf(out, "Removed %d of %d points outside the area.\n", r.Removed, pointsNo)
+		}
+		if !detailed || len(r.Ranges) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "  %s:\n", r.Filter)
+		for j := 0; j < len(r.Ranges); j++ {
+			rr := r.Ranges[j]
+			fmt.Fprintf(out, "    points %d - %d (%s - %s)\n", rr.StartIdx, rr.EndIdx,
+				rr.Start.Format("15:04:05"), rr.End.Format("15:04:05"))
+		}
+	}
+}
+
+// printGpsSurfing saves cleaned points as a GPX file for the
+// gps-speedsurfing.com upload and prints statistics in the site's ranking
+// categories.
+func printGpsSurfing(out io.Writer, filePath, fileName string, points stats.Points, s stats.Stats) {
+	newFilePath := filePath + ".gpssurfing.gpx"
+	f, err := os.Create(newFilePath)
+	if err != nil {
+		fmt.Fprintf(out, "Error creating new file '%s' for GPX export: %v\n", newFilePath, err)
+		atomic.AddInt32(&fileErrors, 1)
+		return
+	}
+	defer f.Close()
+
+	err = stats.SavePointsAsGpx(points, f)
+	if err != nil {
+		fmt.Fprintf(out, "Error saving file '%s' for GPX export: %v\n", newFilePath, err)
+		atomic.AddInt32(&fileErrors, 1)
+		return
+	}
+
+	fmt.Fprintf(out, "gps-speedsurfing.com session from '%s', upload '%s':\n", fileName, newFilePath)
+	fmt.Fprint(out, s.TxtGpsSurfing())
+}
+
+// readSpots reads spots from a CSV file.
+func readSpots(filePath string) ([]stats.Spot, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return stats.ReadSpots(f)
+}
+
+// parseGates parses gates from a JSON file or from a string containing
+// 1 or 2 gates separated by ';', each as 'lat1,lon1,lat2,lon2'.
+func parseGates(value string) ([]stats.Gate, error) {
+	if _, err := os.Stat(value); err == nil {
+		f, err := os.Open(value)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return stats.ReadGates(f)
+	}
+
+	gates := []stats.Gate{}
+	gateValues := strings.Split(value, ";")
+	if len(gateValues) > 2 {
+		return nil, fmt.Errorf("expected 1 or 2 gates, found %d", len(gateValues))
+	}
+	for i := 0; i < len(gateValues); i++ {
+		coords := strings.Split(gateValues[i], ",")
+		if len(coords) != 4 {
+			return nil, fmt.Errorf("gate '%s' should be 'lat1,lon1,lat2,lon2'", gateValues[i])
+		}
+		vals := make([]float64, 4)
+		for j := 0; j < 4; j++ {
+			v, err := strconv.ParseFloat(strings.TrimSpace(coords[j]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gate coordinate '%s'", coords[j])
+			}
+			vals[j] = v
+		}
+		gates = append(gates, stats.Gate{Lat1: vals[0], Lon1: vals[1], Lat2: vals[2], Lon2: vals[3]})
+	}
+
+	return gates, nil
+}
+
+// replaceCleanUpFilter replaces the cleanup filter name in the
+// comma-separated list of filter names, returning false if there is no
+// such filter.
+func replaceCleanUpFilter(names, name, newName string) (string, bool) {
+	ns := strings.Split(names, ",")
+	replaced := false
+	for i := 0; i < len(ns); i++ {
+		if strings.TrimSpace(ns[i]) == name {
+			ns[i] = newName
+			replaced = true
+		}
+	}
+	return strings.Join(ns, ","), replaced
+}
+
+// hasCleanUpFilter checks if comma-separated cleanup filter names contain
+// the filter name.
+func hasCleanUpFilter(names, name string) bool {
+	ns := strings.Split(names, ",")
+	for i := 0; i < len(ns); i++ {
+		if strings.TrimSpace(ns[i]) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// circlesFlag is a repeatable flag value with circle areas 'lat,lon,radius'.
+type circlesFlag []stats.Area
+
+// String returns circles as given on the command line.
+func (c *circlesFlag) String() string {
+	circles := []string{}
+	for i := 0; i < len(*c); i++ {
+		a := (*c)[i]
+		circles = append(circles, fmt.Sprintf("%g,%g,%g", a.Lat, a.Lon, a.Radius))
+	}
+	return strings.Join(circles, " ")
+}
+
+// Set adds a circle given on the command line.
+func (c *circlesFlag) Set(value string) error {
+	circle, err := stats.ParseCircle(value)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, circle)
+	return nil
+}
+
+// parseGapTrim parses the number of points removed before and after missing
+// points given as 'before,after'.
+func parseGapTrim(value string) (stats.GapTrim, error) {
+	counts := strings.Split(value, ",")
+	if len(counts) != 2 {
+		return stats.GapTrim{}, fmt.Errorf("expected 'before,after'")
+	}
+	vals := make([]int, 2)
+	for i := 0; i < 2; i++ {
+		v, err := strconv.Atoi(strings.TrimSpace(counts[i]))
+		if err != nil || v < 0 {
+			return stats.GapTrim{}, fmt.Errorf("invalid number of points '%s'", counts[i])
+		}
+		vals[i] = v
+	}
+	return stats.GapTrim{Before: vals[0], After: vals[1]}, nil
+}
+
+// parseTimeWindowValue parses -from/-to flag value given as RFC3339 timestamp
+// or HH:MM time interpreted in the day of the given track timestamp.
+// Empty value is returned as zero time.
+func parseTimeWindowValue(value string, day time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if strings.Contains(value, "T") {
+		return time.Parse(time.RFC3339, value)
+	}
+	hm, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time '%s', expected RFC3339 or HH:MM", value)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		hm.Hour(), hm.Minute(), 0, 0, day.Location()), nil
+}
+
+// showVersion prints the version, as JSON if jsonOutput is true.
+func showVersion(jsonOutput bool) {
+	if jsonOutput {
+		err := json.NewEncoder(os.Stdout).Encode(versionJSON{
+			Version: version.Version, Platform: version.Platform, BuildTime: version.BuildTime})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error formatting version: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	fmt.Printf("gps-stat version %s %s %s\n", version.Version, version.Platform, version.BuildTime)
 
 	os.Exit(0)
@@ -177,17 +1636,149 @@ func showUsage(exitStatus int) {
 	fmt.Println("Flags:")
 	fmt.Println("  -h Show usage (optional)")
 	fmt.Println("  -v Show version (optional)")
-	fmt.Println("  -t Set the statistics type to print (optional, default all)")
-	fmt.Println("     (all, 2s, 10sAvg, 10s1, 10s2, 10s3, 10s4, 10s5, 15m, 1h, 100m, 1nm, alpha)")
+	fmt.Println("  -version-json Show version as JSON with version, platform and buildTime (optional)")
+	fmt.Println("  -t Set the statistics types to print, comma-separated (optional, default all)")
+	fmt.Println("     (all, 2s, 10sAvg, 10s1, 10s2, 10s3, 10s4, 10s5, 15m, 1h, 100m, 1nm, alpha, rose, dur, dist)")
+	fmt.Println("     dur prints total and moving duration (time moving faster than 2 kts)")
+	fmt.Println("     dist prints total path distance and straight-line distance from start to finish")
+	fmt.Println("     rose prints time spent per 10° heading with the detected wind axis")
 	fmt.Println("  -su Set the speed units to print (optional, default kts)")
 	fmt.Println("      (kts, kmh, ms)")
 	fmt.Println("  -sf Save filtered points as a new GPX file without points detected as errors")
 	fmt.Println("      with suffix '.filtered.gpx' (optional)")
+	fmt.Println("  -si Save also points removed by cleanup with -sf, positions are not smoothed (optional)")
+	fmt.Println("  -sm Save only measured points with -sf, without points interpolated by cleanup (optional)")
+	fmt.Println("  -o Set the output format (optional, default txt)")
+	fmt.Println("     (txt, ndjson - one JSON object per file printed as soon as the file is processed,")
+	fmt.Println("     gpssurfing - save cleaned track as '.gpssurfing.gpx' file for the gps-speedsurfing.com")
+	fmt.Println("     upload and print results in the site's categories)")
+	fmt.Println("  -out Write statistics to the file instead of stdout (optional)")
+	fmt.Println("  -outdir Write statistics for each input file to a file named after it with")
+	fmt.Println("          suffix '.stats.txt' or '.stats.ndjson' in the directory (optional)")
+	fmt.Println("          Directories are created as needed.")
+	fmt.Println("  -p Set the number of files processed in parallel (optional, default number of CPUs)")
+	fmt.Println("     Statistics are printed in the order of files, progress of large files is shown")
+	fmt.Println("     only when a single file is given or with -p 1.")
+	fmt.Println("  -best Print only the headline statistics (optional, overrides -t)")
+	fmt.Println("        (date, 2s, 10sAvg, 1nm, alpha)")
+	fmt.Println("  -summary Print a single tab-delimited line per file (optional, overrides -t)")
+	fmt.Println("           (date, 2s, 10sAvg, 100m, 1nm, alpha, units, file)")
+	fmt.Println("  -validate Print anomalies found in track points without calculating statistics (optional)")
+	fmt.Println("            (out-of-range coordinates, non-monotonic or equal timestamps,")
+	fmt.Println("            speed spikes, missing device speed)")
+	fmt.Println("  -compare Print statistics of 2 files side by side with the difference of the second")
+	fmt.Println("           from the first one and '*' next to the better value (optional, only txt)")
+	fmt.Println("  -watch Watch the directory (instead of files given) every 2 seconds and print")
+	fmt.Println("         statistics for each new or modified .gpx or .sbn file once it stops growing,")
+	fmt.Println("         until interrupted, with -out appending them to the file (optional)")
+	fmt.Println("         Processed files are recorded in the .gps-stats-watch file in the directory")
+	fmt.Println("  -reprocess Process again files already processed by -watch (optional)")
+	fmt.Println("  -follow Re-read the growing file every given interval (e.g. 5s) and print a summary")
+	fmt.Println("          line (time of the last point, date, 2s, 10sAvg, 100m, 1nm, alpha, units, file)")
+	fmt.Println("          when new points are added, until interrupted (optional, only 1 file)")
+	fmt.Println("  -merge Merge points of all files into a single session, e.g. after a watch restart")
+	fmt.Println("         (optional, points are sorted and points with duplicate timestamps removed,")
+	fmt.Println("         can't be used with -outdir)")
+	fmt.Println("  -per-session Print statistics also for each session (optional)")
+	fmt.Println("               Sessions are GPX track segments or parts of the track between long gaps.")
+	fmt.Println("  -session-gap Minimum gap between points starting a new session (optional, default 30m)")
+	fmt.Println("  -split-sessions Print statistics only per session for files with gaps longer than")
+	fmt.Println("                  -session-gap, without statistics for the whole file (optional)")
+	fmt.Println("  -precision Set the number of decimal places for printed speeds and distances")
+	fmt.Println("             (optional, default 3)")
+	fmt.Println("  -interpolate Calculate 2 second peak and 5x10 tracks from tracks lasting exactly")
+	fmt.Println("               2 and 10 seconds, interpolating positions between points (optional)")
+	fmt.Println("  -topn Set the number of the fastest non-overlapping alphas printed with -t alpha")
+	fmt.Println("        (optional, default 5)")
+	fmt.Println("  -verbose Print each of the 5 runs contributing to the 5x10 average with -t 10sAvg")
+	fmt.Println("           (optional)")
+	fmt.Println("  -dump Print all points of the 2s, alpha, 100m or nm track after statistics: index in")
+	fmt.Println("        the file, time, position and heading & speed from the previous point (optional)")
+	fmt.Println("  -leap Subtract given number of leap seconds from SBN timestamps, for receivers")
+	fmt.Println("        reporting GPS time instead of UTC (optional, default 0, 18 since 2017)")
+	fmt.Println("        A device needs it if its track starts 18 s later than a phone or watch track")
+	fmt.Println("        recorded at the same time, or than the time noted when starting the recording.")
+	fmt.Println("  -distmodel Set the model used to calculate distances (optional, default simple)")
+	fmt.Println("             simple    - flat earth approximation, fast and accurate for GPS points")
+	fmt.Println("             haversine - great-circle distance on a sphere")
+	fmt.Println("             The model used is printed next to the total distance.")
+	fmt.Println("  -hrmax Show time spent in 5 heart rate zones (50-60%, ..., 90-100% of given max heart")
+	fmt.Println("         rate) if points contain heart rate (optional)")
+	fmt.Println("  -compare-speed Show average device-reported speed (if available) next to each")
+	fmt.Println("                 statistic calculated from positions (optional)")
+	fmt.Println("  -spots Show spot name next to the session location (optional)")
+	fmt.Println("         CSV file with 'name,lat,lon,radius' lines, radius is in meters")
+	fmt.Println("  -from Ignore points before given time (optional)")
+	fmt.Println("        (RFC3339 like 2022-10-14T14:30:00Z or HH:MM in the track's day)")
+	fmt.Println("  -to Ignore points after given time (optional)")
+	fmt.Println("      (RFC3339 like 2022-10-14T16:00:00Z or HH:MM in the track's day)")
+	fmt.Println("  -gates Report gate crossings and lap times through 1 or 2 gates (optional)")
+	fmt.Println("         (lat1,lon1,lat2,lon2[;lat1,lon1,lat2,lon2] or a JSON file with")
+	fmt.Println("         [{\"lat1\": ..., \"lon1\": ..., \"lat2\": ..., \"lon2\": ...}])")
+	fmt.Println("         Lap is measured between 2 crossings of the first gate, if second gate is")
+	fmt.Println("         given it must be crossed in between.")
+	fmt.Println("  -gates-speed Ignore gate crossings slower than given number of speed units (optional)")
+	fmt.Println("  -gates-json Print gate crossings and laps as JSON (optional)")
 	fmt.Println("")
 	fmt.Println("  -cs Clean up points where speed changes are more than given number of speed units (default 5 kts)")
 	fmt.Println("       Calculation uses 4 points. It calculates 3 speeds based on those points.")
 	fmt.Println("       After that, 2 speed changes are calculated and difference between those changes is")
 	fmt.Println("       used to filter points.")
+	fmt.Println("  -ca Clean up points reached with acceleration greater than given m/s² instead of -cs,")
+	fmt.Println("       using actual timestamps so it works the same for any sampling rate (optional,")
+	fmt.Println("       e.g. 6, replaces spikes with accel in -cleanup, can't be used with -cs)")
+	fmt.Println("  -cleanup Set the comma-separated cleanup filters applied in order (default fill,dups,gaps,doppler,spikes)")
+	fmt.Println("       fill    - interpolate single missing points if speeds around them are consistent")
+	fmt.Println("       dups    - remove both points with the same timestamp, keep the first one if they")
+	fmt.Println("                 are at most 3 m apart")
+	fmt.Println("       gaps    - remove points around missing points (-gap-trim, default 1 before, 3 after)")
+	fmt.Println("       doppler - remove points where positional speed differs from device speed")
+	fmt.Println("                 more than -doppler-offset + -doppler-factor * device speed")
+	fmt.Println("                 (skipped without device speeds)")
+	fmt.Println("       spikes  - remove points where speed changes are more than -cs speed units")
+	fmt.Println("       accel   - remove points reached with acceleration greater than -ca m/s² (default 6)")
+	fmt.Println("       none    - no clean up")
+	fmt.Println("  -gap-trim Set the number of points removed before and after missing points by the")
+	fmt.Println("            gaps clean up (optional, default 1,3, 0,0 disables it)")
+	fmt.Println("  -smooth Smooth positions after clean up using a Kalman filter, useful for noisy")
+	fmt.Println("          phone tracks (optional)")
+	fmt.Println("  -teleport Ignore position jumps (like after tunnels) covering more than given multiple")
+	fmt.Println("            of the distance possible with the median speed around them, before other")
+	fmt.Println("            clean up filters, jumps are not counted in the distance and statistics")
+	fmt.Println("            (optional, e.g. 3, default 0 - disabled)")
+	fmt.Println("  -max-speed Remove points reached faster than given number of speed units before other")
+	fmt.Println("             clean up filters (optional, default 60 kts)")
+	fmt.Println("  -doppler-factor Set the allowed speed difference for doppler clean up relative to")
+	fmt.Println("                  device speed (optional, default 0.3)")
+	fmt.Println("  -doppler-offset Set the allowed speed difference for doppler clean up in speed units")
+	fmt.Println("                  (optional, default 3 kts)")
+	fmt.Println("  -d Print all ranges of points removed by each clean up filter (optional)")
+	fmt.Println("  -bbox Remove points outside the area before other clean up filters (optional)")
+	fmt.Println("        (minLat,minLon,maxLat,maxLon or lat,lon,radius - circle with radius in meters)")
+	fmt.Println("  -exclude Remove points inside the circle lat,lon,radius (radius in meters), like the")
+	fmt.Println("           slipway, after other clean up filters (optional, repeatable). The point after")
+	fmt.Println("           an excluded stretch starts a new part of the track, so the stretch doesn't")
+	fmt.Println("           count in the distance and heading rose and no speed track spans it")
+	fmt.Println("  -warmup Remove points at the start of the track recorded before the GPS fix settled,")
+	fmt.Println("          until points move no faster than 40 m/s for given duration, before other")
+	fmt.Println("          clean up filters (optional, e.g. 5s)")
+	fmt.Println("  -trim Trim stationary periods at the start and end of the track longer than given")
+	fmt.Println("        duration, like walking & rigging (optional, e.g. 5m)")
+	fmt.Println("  -trim-speed Set the speed below which points are stationary for -trim")
+	fmt.Println("              (optional, default 2 kts)")
+	fmt.Println("  -max-hdop Remove points with GPX hdop greater than given value before other")
+	fmt.Println("            clean up filters, points without hdop are kept (optional, e.g. 3)")
+	fmt.Println("  -mode Set the clean up defaults for the discipline (optional, default fin)")
+	fmt.Println("        fin  - -cs 5 kts, -max-speed 60 kts, -trim-speed 2 kts, -doppler-offset 3 kts")
+	fmt.Println("        foil - -cs 8 kts, -max-speed 60 kts, -trim-speed 4 kts, -doppler-offset 4 kts")
+	fmt.Println("        kite - -cs 7 kts, -max-speed 70 kts, -trim-speed 3 kts, -doppler-offset 4 kts")
+	fmt.Println("        Flags set explicitly override the mode defaults.")
+	fmt.Println("")
+	fmt.Println("Exit status:")
+	fmt.Println("  0 - statistics printed for all files")
+	fmt.Println("  1 - some files couldn't be read or their statistics couldn't be printed or saved,")
+	fmt.Println("      the rest of files is still processed")
+	fmt.Println("  2 - invalid flags")
 	fmt.Println("")
 	fmt.Println("Examples:")
 	fmt.Printf(" %s my_gps_data.SBN\n", os.Args[0])
@@ -196,9 +1787,21 @@ func showUsage(exitStatus int) {
 	fmt.Printf(" %s -cs 7 my_gps_data.gpx\n", os.Args[0])
 	fmt.Println("   - runs analysis of the SBN data with custom clean up settings")
 	fmt.Println("")
+	fmt.Printf(" %s -mode foil my_gps_data.gpx\n", os.Args[0])
+	fmt.Println("   - runs analysis of the GPX data with clean up settings for foiling")
+	fmt.Println("")
+	fmt.Printf(" %s -cleanup spikes -cs 3 my_gps_data.gpx\n", os.Args[0])
+	fmt.Println("   - runs analysis of the GPX data using only more aggressive speed changes clean up")
+	fmt.Println("")
 	fmt.Printf(" %s -t=1nm *.SBN *.gpx\n", os.Args[0])
 	fmt.Println("   - runs analysis of multiple SBN & GPX data only for 1 NM statistics")
 	fmt.Println("")
+	fmt.Printf(" %s -t=2s,alpha,1nm *.SBN\n", os.Args[0])
+	fmt.Println("   - runs analysis of multiple SBN data only for 2 second, alpha and 1 NM statistics")
+	fmt.Println("")
+	fmt.Printf(" %s -from 14:30 -to 16:00 my_gps_data.SBN\n", os.Args[0])
+	fmt.Println("   - runs analysis of the SBN data recorded between 14:30 and 16:00")
+	fmt.Println("")
 	fmt.Printf(" %s -sf my_gps_data.GPX\n", os.Args[0])
 	fmt.Println("   - runs analysis of the GPX data and save a copy of track with filtered points detected as errors")
 
@@ -1,14 +1,37 @@
 // Package errs simplifies creation of errors and contains errors produced.
 package errs
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // Error type is used to create constant errors.
 type Error string
 
 func (e Error) Error() string { return string(e) }
 
-// Errorf creates error from formatted string with params.
-func Errorf(format string, v ...interface{}) Error {
-	return Error(fmt.Sprintf(format, v...))
+// Errors returned while reading tracks, wrapped with details. Use errors.Is
+// to check for them.
+const (
+	// ErrUnknownFormat is returned for files of unsupported format.
+	ErrUnknownFormat = Error("Unknown track type")
+	// ErrChecksum is returned for messages with invalid checksum.
+	ErrChecksum = Error("Invalid checksum")
+	// ErrNoPoints is returned for tracks without any point.
+	ErrNoPoints = Error("No track points found")
+	// ErrTruncated is returned for tracks ending in the middle of a message
+	// or an element, points read before the end are still returned.
+	ErrTruncated = Error("Track is truncated")
+)
+
+// Errorf creates error from formatted string with params. If the format
+// contains %w, the error wraps the corresponding param (like fmt.Errorf), so
+// errors.Is and errors.As can check it.
+func Errorf(format string, v ...interface{}) error {
+	err := fmt.Errorf(format, v...)
+	if errors.Unwrap(err) == nil {
+		return Error(err.Error())
+	}
+	return err
 }
@@ -0,0 +1,54 @@
+package errs
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestErrorfWithoutWrap(t *testing.T) {
+	err := Errorf("Invalid value: %d.", 42)
+
+	var e Error
+	if !errors.As(err, &e) {
+		t.Fatalf("Errorf() = %T, want Error", err)
+	}
+	if err.Error() != "Invalid value: 42." {
+		t.Errorf("Errorf() = %q, want %q", err.Error(), "Invalid value: 42.")
+	}
+	if errors.Unwrap(err) != nil {
+		t.Errorf("Unwrap(Errorf()) = %v, want nil", errors.Unwrap(err))
+	}
+}
+
+func TestErrorfWrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		wrapped error
+	}{
+		{"sentinel", ErrTruncated},
+		{"other error", io.ErrUnexpectedEOF},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			err := Errorf("%w (details).", tt.wrapped)
+
+			if !errors.Is(err, tt.wrapped) {
+				t.Errorf("errors.Is(%v, %v) = false, want true", err, tt.wrapped)
+			}
+			if err.Error() != tt.wrapped.Error()+" (details)." {
+				t.Errorf("Errorf() = %q, want %q", err.Error(), tt.wrapped.Error()+" (details).")
+			}
+		})
+	}
+
+	err := Errorf("Reading failed: %w", Errorf("%w.", ErrChecksum))
+	var e Error
+	if !errors.As(err, &e) || e != ErrChecksum {
+		t.Errorf("errors.As(%v) = %v, want %v", err, e, ErrChecksum)
+	}
+	if errors.Is(err, ErrNoPoints) {
+		t.Errorf("errors.Is(%v, %v) = true, want false", err, ErrNoPoints)
+	}
+}
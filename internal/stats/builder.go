@@ -0,0 +1,362 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// StatsBuilder calculates statistics incrementally from points added one by
+// one, for example from a live GPS device, with memory bounded by the
+// longest track (1 hour) instead of the whole session.
+//
+// Rolling tracks (2s, 15m, 1h, 100m, 1NM, alpha) are updated on every Add,
+// statistics which need the whole session are calculated on Snapshot:
+//   - 5x10 tracks are selected from 10 second tracks like in
+//     CalculateStats. A 10 second track faster than all tracks overlapping
+//     it, which no later track can overlap, is always selected, so tracks
+//     before it are resolved on Add and only points of later tracks are
+//     kept (memory grows only while the speed keeps increasing),
+//   - the heading rose is created from the histogram summed on every Add,
+//   - the median sampling interval is calculated from intervals rounded to
+//     milliseconds.
+type StatsBuilder struct {
+	statType   StatFlag
+	speedUnits UnitsFlag
+	opts       CalcOptions
+	res        Stats
+
+	pointsNo  int
+	last      Point
+	latSum    float64
+	lonSum    float64
+	intervals map[time.Duration]int
+	bins      []float64
+
+	track2s           Track
+	track10s          Track
+	track15m          Track
+	track1h           Track
+	track100m         Track
+	track1NM          Track
+	trackAlpha500m    Track
+	subtrackAlpha500m Track
+
+	// points10s are points from the first point of open10s, open10s are
+	// 10 second tracks not resolved yet (ordered by the last point), the
+	// first closed10s of them can't be overlapped by later tracks.
+	points10s   []Point
+	open10s     []candidate10s
+	closed10s   int
+	last10s     *candidate10s
+	selected10s []Track
+}
+
+// NewStatsBuilder creates a StatsBuilder calculating statistics like
+// CalculateStatsWith.
+func NewStatsBuilder(statType StatFlag, speedUnits UnitsFlag, opts CalcOptions) *StatsBuilder {
+	b := &StatsBuilder{
+		statType:   expandStatType(statType),
+		speedUnits: speedUnits,
+		opts:       opts.withDefaults(),
+		res:        Stats{speedUnits: speedUnits, precision: defaultPrecision, distModel: distanceModel},
+		intervals:  map[time.Duration]int{},
+	}
+	if b.opts.HeadingBinSize > 0 {
+		b.bins = make([]float64, int(math.Ceil(360/b.opts.HeadingBinSize)))
+	}
+	b.resetTracks()
+	return b
+}
+
+// resetTracks starts all rolling tracks again.
+func (b *StatsBuilder) resetTracks() {
+	b.track2s = Track{speedUnits: b.speedUnits}
+	b.track10s = Track{speedUnits: b.speedUnits}
+	b.track15m = Track{speedUnits: b.speedUnits}
+	b.track1h = Track{speedUnits: b.speedUnits}
+	b.track100m = Track{speedUnits: b.speedUnits}
+	b.track1NM = Track{speedUnits: b.speedUnits}
+	b.trackAlpha500m = Track{speedUnits: b.speedUnits}
+	b.subtrackAlpha500m = Track{speedUnits: b.speedUnits}
+}
+
+// Add adds the next point of the session. Points must be added in time
+// order, points marked invalid by cleanup are skipped.
+func (b *StatsBuilder) Add(p Point) {
+	if !p.isValid() {
+		return
+	}
+	p.idx = b.pointsNo
+	statType := b.statType
+	opts := b.opts
+
+	if b.pointsNo == 0 {
+		b.res.startTime = p.ts
+		b.res.startLat, b.res.startLon = p.lat, p.lon
+	} else {
+		prev := b.last
+		dt := p.ts.Sub(prev.ts)
+		b.intervals[dt.Round(time.Millisecond)]++
+		b.res.totalDuration = p.ts.Sub(b.res.startTime).Hours()
+		if b.bins != nil && dt > 0 && !p.jump && distance(prev, p) >= opts.HeadingMinDistance {
+			binIdx := int(pointsHeading(prev, p)/opts.HeadingBinSize) % len(b.bins)
+			b.bins[binIdx] += dt.Seconds()
+		}
+	}
+	b.res.endLat, b.res.endLon = p.lat, p.lon
+	b.latSum += p.lat
+	b.lonSum += p.lon
+
+	if p.jump {
+		// Tracks can't span a position jump.
+		b.resetTracks()
+	} else if b.pointsNo > 0 {
+		b.res.totalDistance = b.res.totalDistance + distance(b.last, p)
+		if speed(b.last, p, UnitsMs) > opts.MovingMinSpeed {
+			b.res.movingDuration += p.ts.Sub(b.last.ts).Hours()
+		}
+	}
+	b.pointsNo++
+	b.last = p
+
+	if statType&Stat2s != 0 {
+		b.track2s = b.track2s.addPointMinDuration(p, 2)
+	}
+	if statType&Stat5x10s != 0 {
+		b.track10s = b.track10s.addPointMinDuration(p, 10)
+		b.points10s = append(b.points10s, p)
+		if b.track10s.valid && b.track10s.speed > 0 {
+			b.open10s = append(b.open10s, candidate10s{
+				start: b.track10s.ps[0].idx, end: p.idx,
+				duration: b.track10s.duration, distance: b.track10s.distance, speed: b.track10s.speed,
+			})
+		}
+		b.resolve10s()
+	}
+	if statType&Stat15m != 0 {
+		b.track15m = b.track15m.addPointMinDuration(p, 900)
+	}
+	if statType&Stat1h != 0 {
+		b.track1h = b.track1h.addPointMinDuration(p, 3600)
+	}
+	if statType&Stat100m != 0 {
+		b.track100m = b.track100m.addPointMinDistance(p, 100)
+	}
+	if statType&Stat1nm != 0 {
+		b.track1NM = b.track1NM.addPointMinDistance(p, 1852)
+	}
+	if statType&StatAlpha != 0 {
+		b.trackAlpha500m, b.subtrackAlpha500m =
+			b.trackAlpha500m.addPointAlphaMaxDistance(p,
+				opts.AlphaMaxDistance, opts.AlphaMinDistance, opts.AlphaGateSize)
+	}
+	if b.track2s.valid && b.res.speed2s.speed < b.track2s.speed {
+		b.res.speed2s = b.track2s.copyPoints()
+	}
+	if b.track15m.valid && b.res.speed15m.speed < b.track15m.speed {
+		b.res.speed15m = b.track15m.copyPoints()
+	}
+	if b.track1h.valid && b.res.speed1h.speed < b.track1h.speed {
+		b.res.speed1h = b.track1h.copyPoints()
+	}
+	if b.track100m.valid && b.res.speed100m.speed < b.track100m.speed {
+		b.res.speed100m = b.track100m.copyPoints()
+	}
+	if b.track1NM.valid && b.res.speed1NM.speed < b.track1NM.speed {
+		b.res.speed1NM = b.track1NM.copyPoints()
+	}
+	if b.subtrackAlpha500m.valid && b.res.alpha500m.speed < b.subtrackAlpha500m.speed {
+		b.res.alpha500m = b.subtrackAlpha500m.copyPoints()
+	}
+	if b.subtrackAlpha500m.valid {
+		b.res.alphas = addTopTrack(b.res.alphas, b.subtrackAlpha500m.copyPoints(), opts.AlphaTopCount)
+	}
+}
+
+// copyPoints returns the Track with a copy of its points, so a saved Track
+// doesn't keep points of a rolling track from being released.
+func (t Track) copyPoints() Track {
+	t.ps = append([]Point{}, t.ps...)
+	return t
+}
+
+// resolve10s selects 10 second tracks up to the last track which is sure
+// to be selected: no later track can overlap it and it is selected before
+// all tracks overlapping it. Tracks before it overlap only tracks before it
+// or it, so they are selected like in CalculateStats. Only the fastest
+// selected tracks are kept and points no longer needed are released.
+func (b *StatsBuilder) resolve10s() {
+	// Later tracks start with the current 10 second track or after it.
+	nextStart := b.last.idx
+	if len(b.track10s.ps) > 0 {
+		nextStart = b.track10s.ps[0].idx
+	}
+	resolved := -1
+	i := b.closed10s
+	for ; i < len(b.open10s) && b.open10s[i].end < nextStart; i++ {
+		if b.sureSelected10s(i) {
+			resolved = i
+		}
+	}
+	b.closed10s = i
+
+	if resolved >= 0 {
+		selected := select10s(b.open10s[:resolved+1], b.last10s)
+		for j := 0; j < len(selected); j++ {
+			b.selected10s = addTop10s(b.selected10s, b.candidateTrack(selected[j]), b.opts.Top10sCount)
+		}
+		last := b.open10s[resolved]
+		b.last10s = &last
+		b.open10s = append([]candidate10s{}, b.open10s[resolved+1:]...)
+		b.closed10s -= resolved + 1
+	}
+
+	firstNeeded := nextStart
+	if len(b.open10s) > 0 {
+		firstNeeded = b.open10s[0].start
+	}
+	dropped := 0
+	for dropped < len(b.points10s) && b.points10s[dropped].idx < firstNeeded {
+		dropped++
+	}
+	b.points10s = b.points10s[dropped:]
+}
+
+// sureSelected10s checks if the open 10 second track with index i is
+// selected before all open tracks overlapping it and doesn't overlap the
+// last resolved selected track.
+func (b *StatsBuilder) sureSelected10s(i int) bool {
+	c := b.open10s[i]
+	if b.last10s != nil && c.overlaps(*b.last10s) {
+		return false
+	}
+	for j := i - 1; j >= 0 && b.open10s[j].end >= c.start; j-- {
+		if !c.precedes(b.open10s[j]) {
+			return false
+		}
+	}
+	for j := i + 1; j < len(b.open10s) && b.open10s[j].start <= c.end; j++ {
+		if !c.precedes(b.open10s[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidateTrack returns the 10 second track of the open candidate with a
+// copy of its points.
+func (b *StatsBuilder) candidateTrack(c candidate10s) Track {
+	first := b.points10s[0].idx
+	return Track{
+		ps:         append([]Point{}, b.points10s[c.start-first:c.end-first+1]...),
+		duration:   c.duration,
+		distance:   c.distance,
+		speed:      c.speed,
+		speedUnits: b.speedUnits,
+		valid:      true,
+	}
+}
+
+// select10s selects non-overlapping candidates like select5x10s without a
+// limit, skipping candidates overlapping the blocker if it is not nil.
+func select10s(candidates []candidate10s, blocker *candidate10s) []candidate10s {
+	sorted := append([]candidate10s{}, candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].precedes(sorted[j])
+	})
+	res := []candidate10s{}
+	for i := 0; i < len(sorted); i++ {
+		used := blocker != nil && sorted[i].overlaps(*blocker)
+		for j := 0; j < len(res) && !used; j++ {
+			used = sorted[i].overlaps(res[j])
+		}
+		if !used {
+			res = append(res, sorted[i])
+		}
+	}
+	return res
+}
+
+// addTop10s adds the selected 10 second track to tracks ordered from the
+// fastest one (the earlier one of equally fast tracks first), keeping up to
+// n tracks.
+func addTop10s(tracks []Track, t Track, n int) []Track {
+	pos := len(tracks)
+	for i := 0; i < len(tracks); i++ {
+		if tracks[i].speed < t.speed || (tracks[i].speed == t.speed && tracks[i].ps[0].idx > t.ps[0].idx) {
+			pos = i
+			break
+		}
+	}
+	res := append([]Track{}, tracks[:pos]...)
+	res = append(res, t)
+	res = append(res, tracks[pos:]...)
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// Snapshot returns statistics of points added so far.
+func (b *StatsBuilder) Snapshot() Stats {
+	res := b.res
+	if b.pointsNo == 0 {
+		res.speed5x10s = nil
+		for i := 0; i < b.opts.Top10sCount; i++ {
+			res.speed5x10s = append(res.speed5x10s, Track{speedUnits: b.speedUnits})
+		}
+		return res
+	}
+	res.centroidLat = b.latSum / float64(b.pointsNo)
+	res.centroidLon = b.lonSum / float64(b.pointsNo)
+	res.medianInt, res.maxInt = b.samplingIntervals()
+
+	if b.statType&StatRose != 0 && b.bins != nil {
+		res.headingRose = headingRoseFromBins(append([]float64{}, b.bins...), b.opts.HeadingBinSize)
+	}
+
+	// Selected open 10 second tracks are added to resolved ones.
+	tracks := b.selected10s
+	selected := select10s(b.open10s, b.last10s)
+	for i := 0; i < len(selected); i++ {
+		tracks = addTop10s(tracks, b.candidateTrack(selected[i]), b.opts.Top10sCount)
+	}
+	res.speed5x10s = append([]Track{}, tracks...)
+	for len(res.speed5x10s) < b.opts.Top10sCount {
+		res.speed5x10s = append(res.speed5x10s, Track{speedUnits: b.speedUnits})
+	}
+
+	return res
+}
+
+// samplingIntervals returns the median and the max interval between added
+// points in seconds.
+func (b *StatsBuilder) samplingIntervals() (float64, float64) {
+	intervals := []time.Duration{}
+	intervalsNo := 0
+	for dt, n := range b.intervals {
+		intervals = append(intervals, dt)
+		intervalsNo += n
+	}
+	if intervalsNo == 0 {
+		return 0, 0
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+
+	// nth returns the interval at the position n of all sorted intervals.
+	nth := func(n int) float64 {
+		for i := 0; i < len(intervals); i++ {
+			n -= b.intervals[intervals[i]]
+			if n < 0 {
+				return intervals[i].Seconds()
+			}
+		}
+		return intervals[len(intervals)-1].Seconds()
+	}
+	median := nth(intervalsNo / 2)
+	if intervalsNo%2 == 0 {
+		median = (nth(intervalsNo/2-1) + median) / 2
+	}
+	return median, intervals[len(intervals)-1].Seconds()
+}
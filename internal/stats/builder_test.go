@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// runsTrack generates a session of runs (speeds in m/s) back and forth with
+// slow gybes between them, with random speed changes within runs.
+func runsTrack(interval time.Duration, seed int64, speeds ...float64) []Point {
+	r := rand.New(rand.NewSource(seed))
+	legs := []leg{}
+	for i := 0; i < len(speeds); i++ {
+		heading := 45.0
+		if i%2 == 1 {
+			heading = 225
+		}
+		for j := 0; j < 12; j++ {
+			legs = append(legs, leg{heading, speeds[i] * (0.9 + 0.2*r.Float64()), 5 * time.Second})
+		}
+		legs = append(legs, leg{heading + 90, 3, 10 * time.Second})
+	}
+	return generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: interval, legs: legs})
+}
+
+// buildStats calculates statistics of the points using the StatsBuilder.
+func buildStats(ps []Point, statType StatFlag, speedUnits UnitsFlag) Stats {
+	b := NewStatsBuilder(statType, speedUnits, DefaultCalcOptions())
+	for i := 0; i < len(ps); i++ {
+		b.Add(ps[i])
+	}
+	return b.Snapshot()
+}
+
+// checkSame5x10s checks that 5x10 tracks are the same.
+func checkSame5x10s(t *testing.T, got, want Stats) {
+	t.Helper()
+	gotTracks, wantTracks := got.Speed5x10s(), want.Speed5x10s()
+	if len(gotTracks) != len(wantTracks) {
+		t.Fatalf("got %d 10s tracks, want %d", len(gotTracks), len(wantTracks))
+	}
+	for i := 0; i < len(wantTracks); i++ {
+		g, w := gotTracks[i], wantTracks[i]
+		if g.Valid() != w.Valid() || g.Speed() != w.Speed() || !g.Start().Equal(w.Start()) ||
+			!g.End().Equal(w.End()) || len(g.Points()) != len(w.Points()) {
+			t.Errorf("10s track %d: got %s, want %s", i+1, g.TxtLine(), w.TxtLine())
+		}
+	}
+	if got.Calc5x10sAvg() != want.Calc5x10sAvg() {
+		t.Errorf("got 5x10 average %.3f, want %.3f", got.Calc5x10sAvg(), want.Calc5x10sAvg())
+	}
+}
+
+func TestStatsBuilder5x10sEquivalence(t *testing.T) {
+	jumped := runsTrack(time.Second, 3, 12, 13, 11, 14, 12, 13)
+	for i := 200; i < len(jumped); i++ {
+		jumped[i] = movePoint(jumped[i], 3000)
+	}
+	jumped[200].jump = true
+
+	tests := []struct {
+		name string
+		ps   []Point
+	}{
+		{"empty", nil},
+		{"shorter than 10s", straightTrack(10, 5*time.Second, time.Second)},
+		{"constant speed", straightTrack(10, 10*time.Minute, time.Second)},
+		{"constant speed 10 Hz", straightTrack(10, 5*time.Minute, 100*time.Millisecond)},
+		{"accelerating", generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second,
+			legs: []leg{{0, 5, time.Minute}, {0, 7, time.Minute}, {0, 9, time.Minute}, {0, 11, time.Minute}}})},
+		{"3 runs", runsTrack(time.Second, 1, 10, 11, 10.5)},
+		{"5 runs 10 Hz", runsTrack(100*time.Millisecond, 2, 9, 10, 9.6, 10.1, 9.8)},
+		{"8 runs 10 Hz", runsTrack(100*time.Millisecond, 4, 9, 10, 9.6, 10.1, 9.8, 10.4, 9.1, 9.9)},
+		{"noisy 10 Hz", addNoise(runsTrack(100*time.Millisecond, 5, 9, 10, 9.6, 10.1, 9.8, 9.3), 1, 6)},
+		{"jump", jumped},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			want := CalculateStats(tt.ps, StatAll, UnitsKts)
+			checkSame5x10s(t, buildStats(tt.ps, StatAll, UnitsKts), want)
+		})
+	}
+}
+
+func TestStatsBuilderSnapshots(t *testing.T) {
+	ps := runsTrack(100*time.Millisecond, 7, 9, 10, 9.6, 10.1, 9.8, 10.2)
+	b := NewStatsBuilder(StatAll, UnitsKts, DefaultCalcOptions())
+	for i := 0; i < len(ps); i++ {
+		b.Add(ps[i])
+		if i%997 == 0 || i == len(ps)-1 {
+			checkSame5x10s(t, b.Snapshot(), CalculateStats(ps[:i+1], StatAll, UnitsKts))
+		}
+	}
+	// Points of resolved tracks are released.
+	if len(b.points10s) > len(ps)/4 {
+		t.Errorf("%d of %d points kept", len(b.points10s), len(ps))
+	}
+}
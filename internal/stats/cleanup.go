@@ -0,0 +1,926 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+// Report describes what a cleanup Filter did.
+type Report struct {
+	Filter string
+	// Removed is the number of removed points, for the out of order filter
+	// it is the number of points moved by sorting.
+	Removed int
+	// Added is the number of points added by interpolation.
+	Added int
+	// Jumps is the number of position jumps found.
+	Jumps int
+	// Ranges contains ranges of consecutive removed points.
+	Ranges []RemovedRange
+}
+
+// RemovedRange is a range of consecutive points removed by a Filter.
+// Indexes are indexes of the first and the last removed point in the track
+// file.
+type RemovedRange struct {
+	StartIdx int
+	EndIdx   int
+	Start    time.Time
+	End      time.Time
+}
+
+// Filter is a single cleanup stage, removing points which seem not valid.
+type Filter func(ps []Point) ([]Point, Report)
+
+// CleanUpConfig contains parameters used by cleanup filters.
+type CleanUpConfig struct {
+	// DeltaSpeedMax is the max difference between speed changes used by the
+	// spikes filter (in SpeedUnits).
+	DeltaSpeedMax float64
+	SpeedUnits    UnitsFlag
+	// MaxHdop is the max horizontal dilution of precision, if greater than 0
+	// points with greater hdop are removed before other filters.
+	MaxHdop float64
+	// MaxSpeed is the max speed between 2 points (in SpeedUnits), if greater
+	// than 0 points reached faster are removed before other filters.
+	MaxSpeed float64
+	// Area, if not nil, is the region outside of which points are removed.
+	Area *Area
+	// TrimStationary is the min duration of stationary periods at the start
+	// and end of the track which are removed if greater than 0.
+	TrimStationary time.Duration
+	// TrimSpeed is the speed (in SpeedUnits) below which points are
+	// stationary.
+	TrimSpeed float64
+	// DopplerFactor and DopplerOffset (in SpeedUnits) set the max difference
+	// between the positional and the device-reported speed used by the
+	// doppler filter: DopplerOffset + DopplerFactor * device speed. If 0,
+	// DefaultDopplerFactor and DefaultDopplerOffsetKts are used.
+	DopplerFactor float64
+	DopplerOffset float64
+	// TeleportFactor, if greater than 0, is the multiplier of the median
+	// speed around a step above which the step is a position jump.
+	TeleportFactor float64
+	// MaxAccel is the max acceleration (m/s²) used by the accel filter,
+	// DefaultMaxAccel if 0.
+	MaxAccel float64
+	// GapTrim is the number of points removed around missing points by the
+	// gaps filter, DefaultGapTrim if nil.
+	GapTrim *GapTrim
+	// Warmup, if greater than 0, is the duration positions must be stable
+	// for at the start of the track, earlier points are removed before other
+	// filters.
+	Warmup time.Duration
+	// Exclude contains circle areas (e.g. the slipway) inside of which
+	// points are removed after other filters.
+	Exclude []Area
+}
+
+// GapTrim is the number of points removed before the first and after the
+// last missing point by the gaps filter, 0 for both disables the filter.
+type GapTrim struct {
+	Before int
+	After  int
+}
+
+// DefaultGapTrim removes 1 point before and 3 points after missing points.
+var DefaultGapTrim = GapTrim{Before: 1, After: 3}
+
+const (
+	// DefaultDopplerFactor is the allowed difference between the positional
+	// and the device-reported speed relative to the device speed.
+	DefaultDopplerFactor = 0.3
+	// DefaultDopplerOffsetKts is the allowed difference between the
+	// positional and the device-reported speed (kts).
+	DefaultDopplerOffsetKts = 3.0
+	// DefaultMaxAccel is the max acceleration (m/s²) used by the accel
+	// filter.
+	DefaultMaxAccel = 6.0
+)
+
+// CleanUpOption changes CleanUpConfig used by CleanUp and CleanUpReport.
+type CleanUpOption func(cfg *CleanUpConfig)
+
+// WithGapTrim sets the number of points removed before and after missing
+// points.
+func WithGapTrim(before, after int) CleanUpOption {
+	return func(cfg *CleanUpConfig) {
+		cfg.GapTrim = &GapTrim{Before: before, After: after}
+	}
+}
+
+const (
+	stationaryWindow = 10 // Min duration (s) used to detect movement
+	stationaryMargin = 10 // Stationary period (s) kept before & after movement
+	dopplerMaxReject = 5  // Max duration (s) of removed points before a new reference point
+	accelMaxReject   = 5  // Max duration (s) of removed points before a new reference point
+
+	dupsMaxDistance = 3 // Max distance (m) between points with the same timestamp keeping the first
+
+	teleportWindow      = 10 // Number of steps before & after a step used for the median speed
+	teleportMaxInterval = 5  // Max duration (s) of a step checked for a jump, longer are gaps
+	teleportMinDistance = 50 // Min distance (m) of a jump, ignoring noise while stationary
+
+	warmupMaxSpeed = 40 // Max speed (m/s) between consecutive points while positions are stable
+)
+
+// DefaultCleanUp is the default cleanup pipeline.
+const DefaultCleanUp = "fill,dups,gaps,doppler,spikes"
+
+// CleanUp removes points that seems not valid using the default cleanup
+// pipeline.
+func CleanUp(points Points, deltaSpeedMax float64, speedUnits UnitsFlag,
+	opts ...CleanUpOption) []Point {
+	ps, _ := CleanUpReport(points, deltaSpeedMax, speedUnits, opts...)
+	return ps
+}
+
+// CleanUpReport removes points that seems not valid using the default
+// cleanup pipeline and reports what each filter removed.
+func CleanUpReport(points Points, deltaSpeedMax float64, speedUnits UnitsFlag,
+	opts ...CleanUpOption) ([]Point, []Report) {
+	cfg := CleanUpConfig{DeltaSpeedMax: deltaSpeedMax, SpeedUnits: speedUnits}
+	for i := 0; i < len(opts); i++ {
+		opts[i](&cfg)
+	}
+	filters, _ := NewCleanUpFilters(DefaultCleanUp, cfg)
+	return CleanUpWith(points, filters)
+}
+
+// CleanUpWith removes points that seems not valid using given filters,
+// applied in order, and reports what each filter removed.
+func CleanUpWith(points Points, filters []Filter) ([]Point, []Report) {
+	ps, reports := CleanUpMarked(points, filters)
+	return ValidPoints(ps), reports
+}
+
+// CleanUpMarked checks points using given filters, applied in order, and
+// reports what each filter removed. It returns a copy of all points with
+// points removed by filters marked invalid instead of dropping them, so
+// points keep their indexes in the track file. Each filter gets only points
+// still valid. Points added by a filter (interpolated) are valid and
+// dropped if a later filter removes them.
+func CleanUpMarked(points Points, filters []Filter) ([]Point, []Report) {
+	// Index points so removed points can be found after each filter.
+	ps := make([]Point, len(points.Ps))
+	copy(ps, points.Ps)
+	for i := 0; i < len(ps); i++ {
+		ps[i].idx = i
+	}
+
+	reports := []Report{}
+	for i := 0; i < len(filters); i++ {
+		psFiltered, report := filters[i](ValidPoints(ps))
+		ps = markRemoved(ps, psFiltered, i+1)
+		report.Ranges = removedRanges(ps, i+1)
+		reports = append(reports, report)
+	}
+	return ps, reports
+}
+
+// markRemoved returns points from psFiltered merged with points from ps
+// missing in psFiltered, which are marked invalid (removed by the filter
+// number filterNo) if they were valid. The order of psFiltered is kept,
+// removed points are placed by their timestamps. A position jump of a
+// removed point moves to the next valid point, so later filters and
+// statistics don't span the jump.
+func markRemoved(ps, psFiltered []Point, filterNo int) []Point {
+	kept := make([]bool, len(ps))
+	for i := 0; i < len(psFiltered); i++ {
+		if !psFiltered[i].interpolated {
+			kept[psFiltered[i].idx] = true
+		}
+	}
+
+	removed := []Point{}
+	for i := 0; i < len(ps); i++ {
+		p := ps[i]
+		if p.interpolated || kept[p.idx] {
+			continue
+		}
+		if p.isValid() {
+			p.validCheck = true
+			p.valid = false
+			p.removedBy = filterNo
+		}
+		removed = append(removed, p)
+	}
+	sort.SliceStable(removed, func(i, j int) bool {
+		return removed[i].ts.Before(removed[j].ts)
+	})
+
+	res := make([]Point, 0, len(psFiltered)+len(removed))
+	idxRemoved := 0
+	for i := 0; i < len(psFiltered); i++ {
+		for idxRemoved < len(removed) && removedBefore(removed[idxRemoved], psFiltered[i]) {
+			res = append(res, removed[idxRemoved])
+			idxRemoved++
+		}
+		res = append(res, psFiltered[i])
+	}
+	res = append(res, removed[idxRemoved:]...)
+
+	jump := false
+	for i := 0; i < len(res); i++ {
+		switch {
+		case res[i].removedBy == filterNo && res[i].jump:
+			jump = true
+		case jump && res[i].isValid():
+			res[i].jump = true
+			jump = false
+		}
+	}
+	return res
+}
+
+// removedBefore returns true if the removed Point pRemoved should be placed
+// before the Point p.
+func removedBefore(pRemoved, p Point) bool {
+	if pRemoved.ts.Equal(p.ts) && !p.interpolated {
+		return pRemoved.idx < p.idx
+	}
+	return pRemoved.ts.Before(p.ts)
+}
+
+// removedRanges finds ranges of consecutive points removed by the filter
+// number filterNo, ignoring points removed by previous filters.
+func removedRanges(ps []Point, filterNo int) []RemovedRange {
+	res := []RemovedRange{}
+	inRange := false
+	for i := 0; i < len(ps); i++ {
+		// Interpolated points are not reported.
+		if ps[i].interpolated || (!ps[i].isValid() && ps[i].removedBy < filterNo) {
+			continue
+		}
+		if ps[i].isValid() {
+			inRange = false
+			continue
+		}
+		if inRange {
+			r := &res[len(res)-1]
+			r.EndIdx = ps[i].globalIdx
+			r.End = ps[i].ts
+			continue
+		}
+		res = append(res, RemovedRange{ps[i].globalIdx, ps[i].globalIdx, ps[i].ts, ps[i].ts})
+		inRange = true
+	}
+	return res
+}
+
+// NewCleanUpFilters creates cleanup filters from a comma-separated list of
+// filter names:
+//   - none: no cleanup
+//   - fill: interpolate single missing points between consistent neighbors
+//   - dups: remove both points if two points have the same timestamp (keep
+//     the first one if they are close)
+//   - gaps: remove points around missing points (cfg.GapTrim, by default 1
+//     before, 3 after)
+//   - doppler: remove points where the positional speed differs from the
+//     device-reported speed more than DopplerOffset + DopplerFactor * speed
+//   - spikes: remove points where speed changes more than DeltaSpeedMax
+//   - accel: remove points reached with acceleration greater than MaxAccel
+//
+// Points are always sorted by timestamps first (even with "none"), because
+// out-of-order points produce negative durations. If cfg.Warmup,
+// cfg.TeleportFactor, cfg.MaxHdop, cfg.Area, cfg.MaxSpeed or
+// cfg.TrimStationary are set, the warmup, teleport, hdop, area, max speed and
+// stationary filters are added before all other filters. If cfg.Exclude is
+// set, the exclude filter is added after all other filters, so the gaps
+// filter doesn't take excluded stretches for missing points.
+//
+// The original cleanup checks same timestamps and missing points in a single
+// pass, so "dups,gaps" next to each other are run as a single filter to keep
+// the results unchanged.
+func NewCleanUpFilters(names string, cfg CleanUpConfig) ([]Filter, error) {
+	res := []Filter{FilterOutOfOrder()}
+	if cfg.Warmup > 0 {
+		res = append(res, FilterWarmup(cfg.Warmup))
+	}
+	if cfg.TeleportFactor > 0 {
+		res = append(res, FilterTeleport(cfg.TeleportFactor))
+	}
+	if cfg.MaxHdop > 0 {
+		res = append(res, FilterHdop(cfg.MaxHdop))
+	}
+	if cfg.Area != nil {
+		res = append(res, FilterArea(*cfg.Area))
+	}
+	if cfg.MaxSpeed > 0 {
+		res = append(res, FilterMaxSpeed(cfg.MaxSpeed, cfg.SpeedUnits))
+	}
+	if cfg.TrimStationary > 0 {
+		res = append(res, FilterStationary(cfg.TrimStationary, cfg.TrimSpeed, cfg.SpeedUnits))
+	}
+	if strings.TrimSpace(names) == "none" {
+		return appendFilterExclude(res, cfg), nil
+	}
+	dopplerFactor := cfg.DopplerFactor
+	if dopplerFactor == 0 {
+		dopplerFactor = DefaultDopplerFactor
+	}
+	dopplerOffset := cfg.DopplerOffset
+	if dopplerOffset == 0 {
+		dopplerOffset = ConvertSpeed(DefaultDopplerOffsetKts, UnitsKts, cfg.SpeedUnits)
+	}
+	maxAccel := cfg.MaxAccel
+	if maxAccel == 0 {
+		maxAccel = DefaultMaxAccel
+	}
+	gapTrim := DefaultGapTrim
+	if cfg.GapTrim != nil {
+		gapTrim = *cfg.GapTrim
+	}
+	if gapTrim.Before < 0 || gapTrim.After < 0 {
+		return res, errs.Errorf("Negative number of points removed around gaps.")
+	}
+
+	ns := strings.Split(names, ",")
+	for i := 0; i < len(ns); i++ {
+		name := strings.TrimSpace(ns[i])
+		nextName := ""
+		if i < len(ns)-1 {
+			nextName = strings.TrimSpace(ns[i+1])
+		}
+		switch {
+		case name == "fill":
+			res = append(res, FilterFill(cfg.DeltaSpeedMax, cfg.SpeedUnits))
+		case name == "dups" && nextName == "gaps", name == "gaps" && nextName == "dups":
+			res = append(res, FilterDupsGaps(gapTrim))
+			i++
+		case name == "dups":
+			res = append(res, FilterDups())
+		case name == "gaps":
+			res = append(res, FilterGaps(gapTrim))
+		case name == "doppler":
+			res = append(res, FilterDoppler(dopplerFactor, dopplerOffset, cfg.SpeedUnits))
+		case name == "spikes":
+			res = append(res, FilterSpikes(cfg.DeltaSpeedMax, cfg.SpeedUnits))
+		case name == "accel":
+			res = append(res, FilterAccel(maxAccel))
+		default:
+			return res, errs.Errorf("Unknown cleanup filter '%s'.", name)
+		}
+	}
+	return appendFilterExclude(res, cfg), nil
+}
+
+// appendFilterExclude appends the exclude filter to filters if cfg.Exclude
+// is set.
+func appendFilterExclude(filters []Filter, cfg CleanUpConfig) []Filter {
+	if len(cfg.Exclude) == 0 {
+		return filters
+	}
+	return append(filters, FilterExclude(cfg.Exclude))
+}
+
+// FilterFill creates a Filter adding a single missing point (1 second
+// sampling) interpolated between its neighbors, if speeds before, over and
+// after the missing point differ less than deltaSpeedMax. Otherwise the gaps
+// filter would remove 4 points around the missing point.
+func FilterFill(deltaSpeedMax float64, speedUnits UnitsFlag) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := []Point{}
+		for i := 0; i < len(ps); i++ {
+			res = append(res, ps[i])
+			if i < 1 || i >= len(ps)-2 ||
+				ps[i].ts.Sub(ps[i-1].ts) != time.Second ||
+				ps[i+1].ts.Sub(ps[i].ts) != 2*time.Second ||
+				ps[i+2].ts.Sub(ps[i+1].ts) != time.Second {
+				continue
+			}
+			speedBefore := speed(ps[i-1], ps[i], speedUnits)
+			speedMissing := speed(ps[i], ps[i+1], speedUnits)
+			speedAfter := speed(ps[i+1], ps[i+2], speedUnits)
+			if math.Abs(speedMissing-speedBefore) < deltaSpeedMax &&
+				math.Abs(speedAfter-speedMissing) < deltaSpeedMax {
+				p := interpolatePoint(ps[i], ps[i+1], ps[i].ts.Add(time.Second))
+				res = append(res, p)
+			}
+		}
+		return res, Report{Filter: "fill", Added: len(res) - len(ps)}
+	}
+}
+
+// FilterDups creates a Filter removing both points if two consecutive
+// points have the same timestamp, or only the second one if they are at
+// most dupsMaxDistance meters apart.
+func FilterDups() Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := cleanUpTimestamps(ps, true, false, GapTrim{})
+		return res, Report{Filter: "dups", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterGaps creates a Filter removing gapTrim points around missing points.
+func FilterGaps(gapTrim GapTrim) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := cleanUpTimestamps(ps, false, true, gapTrim)
+		return res, Report{Filter: "gaps", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterDupsGaps creates a Filter removing points with the same timestamps
+// and gapTrim points around missing points in a single pass.
+func FilterDupsGaps(gapTrim GapTrim) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := cleanUpTimestamps(ps, true, true, gapTrim)
+		return res, Report{Filter: "dups,gaps", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterSpikes creates a Filter removing outlier points where the speed
+// changes more than deltaSpeedMax (in speedUnits).
+func FilterSpikes(deltaSpeedMax float64, speedUnits UnitsFlag) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := cleanUpSpikes(ps, deltaSpeedMax, speedUnits)
+		return res, Report{Filter: "spikes", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterAccel creates a Filter removing points reached from the previous
+// valid point with an acceleration greater than maxAccel (m/s²). Unlike
+// the spikes filter it uses actual timestamps, so it works the same for
+// any sampling rate. Fast stops are permitted (crashes). If points are
+// removed for longer than accelMaxReject seconds, the next point becomes
+// the new valid point.
+func FilterAccel(maxAccel float64) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := []Point{}
+		for i := 0; i < len(ps); i++ {
+			l := len(res)
+			if l > 1 && !ps[i].jump && !res[l-1].jump {
+				pPrev := res[l-1]
+				dt := ps[i].ts.Sub(pPrev.ts).Seconds()
+				dtPrev := pPrev.ts.Sub(res[l-2].ts).Seconds()
+				if dt > 0 && dtPrev > 0 && dt <= accelMaxReject {
+					// Speeds are averages over intervals, so the
+					// acceleration is between the interval midpoints.
+					speedPrev := speed(res[l-2], pPrev, UnitsMs)
+					speedCur := speed(pPrev, ps[i], UnitsMs)
+					if (speedCur-speedPrev)/((dt+dtPrev)/2) > maxAccel {
+						continue
+					}
+				}
+			}
+			res = append(res, ps[i])
+		}
+		return res, Report{Filter: "accel", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterDoppler creates a Filter removing points reached from the previous
+// valid point with a positional speed differing from the average
+// device-reported speed of both points more than offset + factor * device
+// speed (offset in speedUnits). Unlike the spikes filter it catches
+// consecutive bad points with consistent speed changes. If points are
+// removed for longer than dopplerMaxReject seconds, the next point becomes
+// the new valid point. Points without the device speed are kept and the
+// filter does nothing if no point has the device speed.
+func FilterDoppler(factor, offset float64, speedUnits UnitsFlag) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		withSpeed := false
+		for i := 0; i < len(ps); i++ {
+			if ps[i].speed != nil {
+				withSpeed = true
+				break
+			}
+		}
+		if !withSpeed {
+			return ps, Report{Filter: "doppler"}
+		}
+
+		res := []Point{}
+		for i := 0; i < len(ps); i++ {
+			if len(res) > 0 && !ps[i].jump {
+				pPrev := res[len(res)-1]
+				dt := ps[i].ts.Sub(pPrev.ts).Seconds()
+				if pPrev.speed != nil && ps[i].speed != nil && dt > 0 && dt <= dopplerMaxReject {
+					deviceSpeed := MsToUnits((*pPrev.speed+*ps[i].speed)/2, speedUnits)
+					if math.Abs(speed(pPrev, ps[i], speedUnits)-deviceSpeed) > offset+factor*deviceSpeed {
+						continue
+					}
+				}
+			}
+			res = append(res, ps[i])
+		}
+		return res, Report{Filter: "doppler", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterOutOfOrder creates a Filter sorting points by timestamps if some
+// points have an earlier timestamp than the previous point. Report contains
+// the number of such points, no point is removed.
+func FilterOutOfOrder() Filter {
+	return func(ps []Point) ([]Point, Report) {
+		outOfOrder := 0
+		for i := 1; i < len(ps); i++ {
+			if ps[i].ts.Before(ps[i-1].ts) {
+				outOfOrder++
+			}
+		}
+		if outOfOrder == 0 {
+			return ps, Report{Filter: "out of order"}
+		}
+
+		res := make([]Point, len(ps))
+		copy(res, ps)
+		sort.SliceStable(res, func(i, j int) bool {
+			return res[i].ts.Before(res[j].ts)
+		})
+		return res, Report{Filter: "out of order", Removed: outOfOrder}
+	}
+}
+
+// FilterTeleport creates a Filter marking position jumps: steps shorter
+// than teleportMaxInterval seconds covering more than factor times the
+// distance possible with the median speed of teleportWindow steps around
+// (and at least teleportMinDistance meters), like jumps after tunnels. No
+// point is removed, the point after the jump starts a new part of the track
+// not connected to the previous point, so the jump is not counted in the
+// distance and statistics and other filters don't span it. Longer steps are
+// left to the gaps filter.
+func FilterTeleport(factor float64) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := make([]Point, len(ps))
+		copy(res, ps)
+		jumps := 0
+		for i := 1; i < len(ps); i++ {
+			dt := ps[i].ts.Sub(ps[i-1].ts).Seconds()
+			if dt <= 0 || dt > teleportMaxInterval {
+				continue
+			}
+			speeds := []float64{}
+			for j := i - teleportWindow; j <= i+teleportWindow; j++ {
+				if j < 1 || j >= len(ps) || j == i || !ps[j].ts.After(ps[j-1].ts) {
+					continue
+				}
+				speeds = append(speeds, speed(ps[j-1], ps[j], UnitsMs))
+			}
+			if len(speeds) == 0 {
+				continue
+			}
+			sort.Float64s(speeds)
+			maxDistance := math.Max(factor*speeds[len(speeds)/2]*dt, teleportMinDistance)
+			if distance(ps[i-1], ps[i]) > maxDistance {
+				res[i].jump = true
+				jumps++
+			}
+		}
+		return res, Report{Filter: "teleport", Jumps: jumps}
+	}
+}
+
+// FilterHdop creates a Filter removing points with poor accuracy, having
+// horizontal dilution of precision greater than maxHdop. Points without
+// hdop are kept.
+func FilterHdop(maxHdop float64) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := []Point{}
+		for i := 0; i < len(ps); i++ {
+			if ps[i].hdop == nil || *ps[i].hdop <= maxHdop {
+				res = append(res, ps[i])
+			}
+		}
+		return res, Report{Filter: "poor accuracy", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterArea creates a Filter removing points outside the area.
+func FilterArea(area Area) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := []Point{}
+		for i := 0; i < len(ps); i++ {
+			if area.Contains(ps[i].lat, ps[i].lon) {
+				res = append(res, ps[i])
+			}
+		}
+		return res, Report{Filter: "area", Removed: len(ps) - len(res)}
+	}
+}
+
+// FilterExclude creates a Filter removing points inside any of the circles
+// (e.g. the slipway, where speed is irrelevant and noisy). The first point
+// after removed points is marked as a position jump, so the excluded
+// stretch doesn't count in the distance and the heading rose and no track
+// (peak speeds, alpha) spans it, like after a teleport.
+func FilterExclude(circles []Area) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := []Point{}
+		report := Report{Filter: "exclude"}
+		excluded := false
+		for i := 0; i < len(ps); i++ {
+			inside := false
+			for j := 0; j < len(circles); j++ {
+				if circles[j].Contains(ps[i].lat, ps[i].lon) {
+					inside = true
+					break
+				}
+			}
+			if inside {
+				excluded = true
+				report.Removed++
+				continue
+			}
+			p := ps[i]
+			if excluded && len(res) > 0 && !p.jump {
+				p.jump = true
+				report.Jumps++
+			}
+			excluded = false
+			res = append(res, p)
+		}
+		return res, report
+	}
+}
+
+// FilterWarmup creates a Filter removing points at the start of the track
+// recorded before the GPS fix settled (cold start), until consecutive points
+// are reached not faster than warmupMaxSpeed for at least window, at any
+// sampling rate. Points are kept if positions never stabilize that long.
+func FilterWarmup(window time.Duration) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		report := Report{Filter: "warmup"}
+		start := 0
+		for i := 1; i < len(ps); i++ {
+			dt := ps[i].ts.Sub(ps[i-1].ts).Seconds()
+			if distance(ps[i-1], ps[i]) > warmupMaxSpeed*dt {
+				start = i
+				continue
+			}
+			if ps[i].ts.Sub(ps[start].ts) >= window {
+				report.Removed = start
+				return ps[start:], report
+			}
+		}
+		return ps, report
+	}
+}
+
+// FilterMaxSpeed creates a Filter removing points reached from the previous
+// valid point faster than maxSpeed (in speedUnits). Points with the same
+// or earlier timestamp are kept for other filters. The track (and each part
+// after a jump) starts at the first point consistent with the next points,
+// so bad first fixes don't remove the whole track.
+func FilterMaxSpeed(maxSpeed float64, speedUnits UnitsFlag) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		res := []Point{}
+		anchor := true
+		jump := false
+		for i := 0; i < len(ps); i++ {
+			p := ps[i]
+			if p.jump {
+				anchor = true
+				jump = true
+			}
+			if anchor {
+				if !consistentWithNext(ps, i, maxSpeed, speedUnits) {
+					continue
+				}
+				p.jump = jump
+				anchor = false
+				jump = false
+				res = append(res, p)
+				continue
+			}
+			pPrev := res[len(res)-1]
+			if p.ts.After(pPrev.ts) && speed(pPrev, p, speedUnits) > maxSpeed {
+				continue
+			}
+			res = append(res, p)
+		}
+		return res, Report{Filter: "max speed", Removed: len(ps) - len(res)}
+	}
+}
+
+// consistentWithNext checks if most of the next 3 points (up to a jump) are
+// reached from the point with index i not faster than maxSpeed, so the point
+// can start the track. The last point is consistent.
+func consistentWithNext(ps []Point, i int, maxSpeed float64, speedUnits UnitsFlag) bool {
+	n, consistent := 0, 0
+	for j := i + 1; j < len(ps) && j <= i+3 && !ps[j].jump; j++ {
+		n++
+		if !ps[j].ts.After(ps[i].ts) || speed(ps[i], ps[j], speedUnits) <= maxSpeed {
+			consistent++
+		}
+	}
+	return consistent >= (n+1)/2
+}
+
+// FilterStationary creates a Filter trimming periods longer than
+// minDuration at the start and the end of the track where speed stays
+// below minSpeed (in speedUnits), like walking to the spot and rigging.
+// A margin of stationaryMargin seconds is kept before the first and after
+// the last movement, interior breaks are not changed.
+func FilterStationary(minDuration time.Duration, minSpeed float64, speedUnits UnitsFlag) Filter {
+	return func(ps []Point) ([]Point, Report) {
+		report := Report{Filter: "stationary"}
+
+		// Movement is detected using speed over at least stationaryWindow
+		// seconds to ignore GPS position jitter.
+		first := -1
+		j := 0
+		for i := 0; i < len(ps) && first < 0; i++ {
+			for j < len(ps)-1 && ps[j].ts.Sub(ps[i].ts).Seconds() < stationaryWindow {
+				j++
+			}
+			if ps[j].ts.After(ps[i].ts) && speed(ps[i], ps[j], speedUnits) >= minSpeed {
+				first = i
+			}
+		}
+		if first < 0 {
+			return ps, report
+		}
+		last := -1
+		j = len(ps) - 1
+		for i := len(ps) - 1; i >= 0 && last < 0; i-- {
+			for j > 0 && ps[i].ts.Sub(ps[j].ts).Seconds() < stationaryWindow {
+				j--
+			}
+			if ps[i].ts.After(ps[j].ts) && speed(ps[j], ps[i], speedUnits) >= minSpeed {
+				last = i
+			}
+		}
+
+		margin := time.Duration(stationaryMargin) * time.Second
+		start := 0
+		if ps[first].ts.Sub(ps[0].ts) > minDuration {
+			for ps[start].ts.Before(ps[first].ts.Add(-margin)) {
+				start++
+			}
+		}
+		end := len(ps) - 1
+		if ps[end].ts.Sub(ps[last].ts) > minDuration {
+			for ps[end].ts.After(ps[last].ts.Add(margin)) {
+				end--
+			}
+		}
+
+		res := ps[start : end+1]
+		report.Removed = len(ps) - len(res)
+		return res, report
+	}
+}
+
+// cleanUpTimestamps removes points with same timestamps (if dups is true)
+// and gapTrim points around missing points (if gaps is true).
+func cleanUpTimestamps(psCurr []Point, dups, gaps bool, gapTrim GapTrim) []Point {
+	if len(psCurr) < 2 {
+		return psCurr
+	}
+	if gapTrim.Before == 0 && gapTrim.After == 0 {
+		gaps = false
+	}
+	if dups {
+		// Keep the first of close points with the same timestamp, removing
+		// both would create a missing point.
+		psCurr = removeCloseDups(psCurr)
+	}
+	// Simple cleanup strategies working great for Amazfit T-Rex Pro:
+	// - if points have same timestamp, keep the first one if they are close,
+	//   otherwise remove both points
+	// - removing points "around" missing points (1 before, 3 after)
+	//
+	// When we find missing point(s) (by default, gapTrim):
+	// - remove 1 point before the first missing point
+	// - remove 3 points after the last missing point
+	// - remove points between missing points
+	//
+	// For example, we should have seconds:
+	// - 43, 44, 45, 46, 47. 48, 49, 50, 51, 52, 53, 54
+	// There are only:
+	// - 43, 44, 45, 46,     48,     50, 51, 52, 53, 54
+	// We need to produce:
+	// - 43, 44, 45,         48,                 53, 54
+	psCleaned := []Point{}
+	psCleaned = append(psCleaned, psCurr[0])
+	psLen := len(psCurr)
+	for idxPs := 1; idxPs < psLen; idxPs++ {
+		pCurr := psCurr[idxPs]
+
+		if idxPs < psLen-1 {
+			pNext := psCurr[idxPs+1]
+			// fmt.Printf("curr / next ts: %v / %v, next - curr: %v\n", pCurr.ts, pNext.ts, pNext.ts.Sub(pCurr.ts).Seconds())
+			if dups && pCurr.ts == pNext.ts {
+				// Skip both points if times are equal.
+				idxPs++
+				// fmt.Printf("====> skipping curr & next: %v & %v\n", pCurr, pNext)
+			} else {
+				// Remove points "around" missing points.
+				// Missing point is point more than 1 second after previous point.
+				dt := pNext.ts.Sub(pCurr.ts).Seconds()
+				if gaps && dt > 1 {
+					idxNext := idxPs + 1
+					idxLast := idxNext
+					// fmt.Printf("====> dt > 1, idxPs, idxNext, idxLast, pNext: %v, %v, %v, %v\n", idxPs, idxNext, idxLast, pNext)
+					for idxNext < psLen-1 && dt > 1 {
+						p1 := psCurr[idxNext]
+						p2 := psCurr[idxNext+1]
+						dt = p2.ts.Sub(p1.ts).Seconds()
+						idxLast = idxNext
+						idxNext++
+						// fmt.Printf("====> dt: %v, idxPs, idxNext, idxLast: %v, %v, %v\n", dt, idxPs, idxNext, idxLast)
+					}
+					// Remove points before the first missing (pCurr is the last
+					// of them) and skip points to the last one after the last
+					// missing (idxLast is the first after the last missing).
+					if gapTrim.Before == 0 {
+						psCleaned = append(psCleaned, pCurr)
+					}
+					for i := 1; i < gapTrim.Before && len(psCleaned) > 0; i++ {
+						psCleaned = psCleaned[:len(psCleaned)-1]
+					}
+					idxPs = idxLast + gapTrim.After - 1
+					// fmt.Printf("====> skipping from %v to %v\n", pCurr, psCurr[idxLast])
+				} else {
+					// fmt.Printf("adding %v\n", pCurr)
+					psCleaned = append(psCleaned, pCurr)
+				}
+			}
+		} else {
+			psCleaned = append(psCleaned, pCurr)
+		}
+	}
+
+	return psCleaned
+}
+
+// removeCloseDups removes points with the same timestamp as the previous
+// point if they are at most dupsMaxDistance meters apart.
+func removeCloseDups(ps []Point) []Point {
+	res := []Point{}
+	for i := 0; i < len(ps); i++ {
+		if i > 0 && ps[i].ts.Equal(ps[i-1].ts) && distance(ps[i-1], ps[i]) <= dupsMaxDistance {
+			continue
+		}
+		res = append(res, ps[i])
+	}
+	return res
+}
+
+// cleanUpSpikes removes outlier points where the speed changes more than
+// deltaSpeedMax.
+func cleanUpSpikes(psCurr []Point, deltaSpeedMax float64, speedUnits UnitsFlag) []Point {
+	if len(psCurr) < 2 {
+		return psCurr
+	}
+	// Cleanup speeds - remove outlier points:
+	// - fast stops are permitted - crashes or near stops
+	// - fast speedups are not permitted - errors
+	// - filter out series of points where the speed increases, decreases
+	//   and again increases in a short time period
+	// Speeds between neighboring points are calculated once, the speed from
+	// the last kept point is the same one if the previous point was kept.
+	speedsNext := make([]float64, len(psCurr)-1)
+	for i := 0; i < len(speedsNext); i++ {
+		speedsNext[i] = speed(psCurr[i], psCurr[i+1], speedUnits)
+	}
+	res := make([]Point, 0, len(psCurr))
+	res = append(res, psCurr[0], psCurr[1])
+	speedPrev := speedsNext[0]
+	idxRes := 1
+	prevKept := true
+	for idxPs := 2; idxPs < len(psCurr)-1; idxPs++ {
+		if psCurr[idxPs].jump {
+			// Start again after a position jump.
+			speedPrev = speedsNext[idxPs]
+			res = append(res, psCurr[idxPs])
+			idxRes++
+			prevKept = true
+			continue
+		}
+		// Compare speed changes between 3 points
+		// (previous, current & next point).
+		// 3 speeds: 2 speeds between 3 points + previous speed.
+		speedCur := speedsNext[idxPs-1]
+		if !prevKept {
+			speedCur = speed(res[idxRes], psCurr[idxPs], speedUnits)
+		}
+		speedNext1 := speedsNext[idxPs]
+		// 2 speed changes
+		speed0Delta := speedCur - speedPrev
+		speed1Delta := speedNext1 - speedCur
+		// 1 differences between speed changes
+		diffDelta1 := speed0Delta - speed1Delta
+
+		// Ignore points where the speed difference between last two points
+		//   increases more than given params.
+		// if (diffDelta1 < deltaKtsMax && diffDelta2 < deltaKtsMax) || speed0DeltaKts < 0 {
+		if (diffDelta1 < deltaSpeedMax) || speed0Delta < 0 {
+			// fmt.Printf("OK  idxPs: %v, idxRes: %v, speedCur/n1/n2: %v/%v/%v, sd0: %v, sd1: %v, dd1: %v (%v)\n", idxPs, idxRes, speedCur, speedNext1, speedNext2, speed0DeltaKts, speed1DeltaKts, diffDelta1, psCurr[idxPs].ts)
+			speedPrev = speedCur
+			res = append(res, psCurr[idxPs])
+			idxRes++
+			prevKept = true
+		} else {
+			// fmt.Printf("==== NOK idxPs: %v, idxRes: %v, speedCur/n1/n2: %v/%v/%v, sd0: %v, sd1: %v, dd1: %v (%v)\n", idxPs, idxRes, speedCur, speedNext1, speedNext2, speed0DeltaKts, speed1DeltaKts, diffDelta1, psCurr[idxPs].ts)
+			prevKept = false
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,813 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// movePoint returns a copy of the point moved north by given meters.
+func movePoint(p Point, north float64) Point {
+	p.lat += north / (earthCircPoles / 360.0)
+	return p
+}
+
+// globalIdxs returns the indexes of points in the track file.
+func globalIdxs(ps []Point) []int {
+	res := make([]int, len(ps))
+	for i := 0; i < len(ps); i++ {
+		res[i] = ps[i].globalIdx
+	}
+	return res
+}
+
+func TestFilterMaxSpeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		bad     []int // Indexes of points moved 1 km away.
+		removed []int
+	}{
+		{"clean", nil, nil},
+		{"bad first fix", []int{0}, []int{0}},
+		{"2 bad first fixes", []int{0, 1}, []int{0, 1}},
+		{"spike after the first fix", []int{1}, []int{1}},
+		{"spike", []int{30}, []int{30}},
+		{"bad last fix", []int{59}, []int{59}},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			ps := straightTrack(10, time.Minute-time.Second, time.Second)
+			for j := 0; j < len(tt.bad); j++ {
+				ps[tt.bad[j]] = movePoint(ps[tt.bad[j]], 1000)
+			}
+			res, report := FilterMaxSpeed(30, UnitsMs)(ps)
+			if report.Removed != len(tt.removed) || len(res) != len(ps)-len(tt.removed) {
+				t.Fatalf("removed %d points (%d left), want %v", report.Removed, len(res), tt.removed)
+			}
+			kept := globalIdxs(res)
+			for j := 0; j < len(tt.removed); j++ {
+				for k := 0; k < len(kept); k++ {
+					if kept[k] == tt.removed[j] {
+						t.Errorf("point %d kept", tt.removed[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFilterMaxSpeedJump(t *testing.T) {
+	ps := straightTrack(10, 20*time.Second, time.Second)
+	// The track continues 5 km away after a teleport, the first point there
+	// is a bad fix.
+	for i := 10; i < len(ps); i++ {
+		ps[i] = movePoint(ps[i], 5000)
+	}
+	ps[10].jump = true
+	ps[10] = movePoint(ps[10], 1000)
+
+	res, report := FilterMaxSpeed(30, UnitsMs)(ps)
+	if report.Removed != 1 {
+		t.Fatalf("removed %d points, want 1", report.Removed)
+	}
+	if res[10].globalIdx != 11 || !res[10].jump {
+		t.Errorf("point after the jump: index %d jump %v, want 11 with a jump",
+			res[10].globalIdx, res[10].jump)
+	}
+}
+
+// gpxFixture saves points as a GPX file and reads them back, like points
+// read from a track file.
+func gpxFixture(t *testing.T, ps []Point) Points {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := SavePointsAsGpx(Points{Name: "fixture", Ps: ps}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	points, err := ReadPointsGpx(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return points
+}
+
+func TestOutOfOrderPoint(t *testing.T) {
+	ps := straightTrack(10, 5*time.Minute, time.Second)
+	// The logger emitted the point 100 seconds too early.
+	ps[150].ts = ps[150].ts.Add(-100 * time.Second)
+	points := gpxFixture(t, ps)
+
+	cleaned, reports := CleanUpReport(points, 5, UnitsMs)
+	if reports[0].Filter != "out of order" || reports[0].Removed != 1 {
+		t.Errorf("got report %+v, want 1 point out of order", reports[0])
+	}
+	for i := 1; i < len(cleaned); i++ {
+		if cleaned[i].ts.Before(cleaned[i-1].ts) {
+			t.Fatalf("point %d at %v before the previous point at %v", i, cleaned[i].ts, cleaned[i-1].ts)
+		}
+	}
+
+	s := CalculateStats(cleaned, StatAll, UnitsMs)
+	if s.Duration() <= 0 || s.Distance() <= 0 {
+		t.Errorf("got duration %v, distance %.3f m, want positive", s.Duration(), s.Distance())
+	}
+	tracks := []Track{s.Speed2s(), s.Speed15m(), s.Speed100m(), s.Speed1NM(), s.Alpha500()}
+	tracks = append(tracks, s.Speed5x10s()...)
+	for i := 0; i < len(tracks); i++ {
+		if tracks[i].Speed() < 0 || tracks[i].Duration() < 0 || tracks[i].Distance() < 0 {
+			t.Errorf("negative track %s", tracks[i].TxtLine())
+		}
+	}
+}
+
+func TestCleanUpDefaults(t *testing.T) {
+	ps := generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second,
+		legs: []leg{{0, 10, 99 * time.Second}}, deviceSpeed: true})
+	points := Points{Ps: ps}
+
+	// Library callers don't set doppler and accel parameters.
+	_, reports := CleanUpReport(points, 5, UnitsKts)
+	for i := 0; i < len(reports); i++ {
+		if reports[i].Filter == "doppler" && reports[i].Removed != 0 {
+			t.Errorf("default doppler filter removed %d of %d clean points", reports[i].Removed, len(ps))
+		}
+	}
+	filters, err := NewCleanUpFilters("doppler,accel", CleanUpConfig{SpeedUnits: UnitsKmh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleaned, reports := CleanUpWith(points, filters); len(cleaned) != len(ps) {
+		t.Errorf("doppler & accel kept %d of %d clean points: %+v", len(cleaned), len(ps), reports)
+	}
+
+	// Defaults still remove a point 20 m off the track.
+	ps[50] = movePoint(ps[50], 20)
+	filters, _ = NewCleanUpFilters("doppler", CleanUpConfig{SpeedUnits: UnitsKts})
+	cleaned, _ := CleanUpWith(Points{Ps: ps}, filters)
+	if len(cleaned) != len(ps)-1 || cleaned[50].globalIdx != 51 {
+		t.Errorf("got %d points, want point 50 removed", len(cleaned))
+	}
+}
+
+// dropPoints returns points without points with given indexes.
+func dropPoints(ps []Point, idxs ...int) []Point {
+	res := []Point{}
+	for i := 0; i < len(ps); i++ {
+		dropped := false
+		for j := 0; j < len(idxs); j++ {
+			dropped = dropped || idxs[j] == i
+		}
+		if !dropped {
+			res = append(res, ps[i])
+		}
+	}
+	return res
+}
+
+func TestFilterGapsTrim(t *testing.T) {
+	// Seconds 10, 20 and 22 are missing, the point 21 is between gaps.
+	ps := dropPoints(straightTrack(10, 40*time.Second, time.Second), 10, 20, 22)
+
+	tests := []struct {
+		before, after int
+		removed       []int
+	}{
+		{0, 0, []int{}},
+		{1, 3, []int{9, 11, 12, 13, 19, 21, 23, 24, 25}},
+		{0, 3, []int{11, 12, 13, 21, 23, 24, 25}},
+		{1, 0, []int{9, 19, 21}},
+		{0, 1, []int{11, 21, 23}},
+		{2, 2, []int{8, 9, 11, 12, 18, 19, 21, 23, 24}},
+		{3, 0, []int{7, 8, 9, 17, 18, 19, 21}},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		want := globalIdxs(dropPoints(ps, indexesOf(ps, tt.removed)...))
+
+		res, report := FilterGaps(GapTrim{Before: tt.before, After: tt.after})(ps)
+		if got := globalIdxs(res); !equalInts(got, want) || report.Removed != len(tt.removed) {
+			t.Errorf("trim %d,%d: removed %d, kept %v, want %v", tt.before, tt.after, report.Removed, got, want)
+		}
+
+		filters, _ := NewCleanUpFilters("gaps", CleanUpConfig{GapTrim: &GapTrim{tt.before, tt.after}})
+		cleaned, _ := CleanUpWith(Points{Ps: ps}, filters)
+		if got := globalIdxs(cleaned); !equalInts(got, want) {
+			t.Errorf("trim %d,%d: CleanUpWith kept %v, want %v", tt.before, tt.after, got, want)
+		}
+
+		// The default pipeline fills the single missing point 10 first, so
+		// only points around 20 and 22 are removed.
+		_, reports := CleanUpReport(Points{Ps: ps}, 5, UnitsMs, WithGapTrim(tt.before, tt.after))
+		wantRemoved := 0
+		for j := 0; j < len(tt.removed); j++ {
+			if tt.removed[j] > 15 {
+				wantRemoved++
+			}
+		}
+		if reports[2].Filter != "dups,gaps" || reports[2].Removed != wantRemoved {
+			t.Errorf("trim %d,%d: CleanUpReport %+v, want %d removed", tt.before, tt.after, reports[2], wantRemoved)
+		}
+	}
+
+	if _, err := NewCleanUpFilters("gaps", CleanUpConfig{GapTrim: &GapTrim{-1, 3}}); err == nil {
+		t.Error("negative gap trim accepted")
+	}
+}
+
+// indexesOf returns indexes of points with given indexes in the track file.
+func indexesOf(ps []Point, globalIdxs []int) []int {
+	res := []int{}
+	for i := 0; i < len(ps); i++ {
+		for j := 0; j < len(globalIdxs); j++ {
+			if ps[i].globalIdx == globalIdxs[j] {
+				res = append(res, i)
+			}
+		}
+	}
+	return res
+}
+
+// equalInts checks if slices contain the same ints in the same order.
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCleanUpMarked(t *testing.T) {
+	clean := straightTrack(10, 2*time.Minute, time.Second)
+	spike := straightTrack(10, 2*time.Minute, time.Second)
+	spike[30] = movePoint(spike[30], 30)
+	spike[80] = movePoint(spike[80], -25)
+	dup := straightTrack(10, 2*time.Minute, time.Second)
+	far := movePoint(dup[40], 100)
+	dup = append(dup[:41:41], append([]Point{far}, dup[41:]...)...)
+	for i := 0; i < len(dup); i++ {
+		dup[i].globalIdx = i
+	}
+
+	tests := []struct {
+		name       string
+		ps         []Point
+		names      string
+		minRemoved int
+	}{
+		{"clean", clean, DefaultCleanUp, 0},
+		{"spikes", spike, DefaultCleanUp, 2},
+		{"gaps", dropPoints(clean, 20, 50, 52), DefaultCleanUp, 4},
+		{"dups", dup, "dups,gaps,spikes", 2},
+		{"none", spike, "none", 0},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			points := Points{Ps: tt.ps}
+			filters, err := NewCleanUpFilters(tt.names, CleanUpConfig{DeltaSpeedMax: 5, SpeedUnits: UnitsMs})
+			if err != nil {
+				t.Fatal(err)
+			}
+			marked, reports := CleanUpMarked(points, filters)
+
+			// All points are kept with their indexes, removed points are
+			// marked with the filter removing them.
+			seen := make([]bool, len(tt.ps))
+			removedBy := make([]int, len(filters)+1)
+			for j := 0; j < len(marked); j++ {
+				p := marked[j]
+				if p.interpolated {
+					continue
+				}
+				if p.idx < 0 || p.idx >= len(tt.ps) || seen[p.idx] || p.globalIdx != tt.ps[p.idx].globalIdx {
+					t.Fatalf("point %d has wrong index %d", j, p.idx)
+				}
+				seen[p.idx] = true
+				if p.isValid() != (p.removedBy == 0) || p.removedBy > len(filters) {
+					t.Errorf("point %d: valid %v, removed by %d", p.idx, p.isValid(), p.removedBy)
+				}
+				removedBy[p.removedBy]++
+			}
+			for j := 0; j < len(seen); j++ {
+				if !seen[j] {
+					t.Errorf("point %d missing", j)
+				}
+			}
+			for j := 0; j < len(reports); j++ {
+				if j > 0 && removedBy[j+1] != reports[j].Removed {
+					t.Errorf("filter %s: %d points marked, %d reported removed",
+						reports[j].Filter, removedBy[j+1], reports[j].Removed)
+				}
+			}
+			for j := 1; j < len(marked); j++ {
+				if marked[j].ts.Before(marked[j-1].ts) {
+					t.Errorf("point %d out of order", j)
+				}
+			}
+
+			// Valid points are the same as points left by CleanUpWith.
+			cleaned, _ := CleanUpWith(points, filters)
+			valid := ValidPoints(marked)
+			if len(tt.ps)-len(valid) < tt.minRemoved {
+				t.Errorf("%d points removed, want at least %d", len(tt.ps)-len(valid), tt.minRemoved)
+			}
+			if len(valid) != len(cleaned) {
+				t.Fatalf("%d valid points, CleanUpWith left %d", len(valid), len(cleaned))
+			}
+			for j := 0; j < len(valid); j++ {
+				if valid[j].globalIdx != cleaned[j].globalIdx || !valid[j].ts.Equal(cleaned[j].ts) ||
+					valid[j].lat != cleaned[j].lat {
+					t.Errorf("valid point %d differs from the cleaned point", j)
+				}
+			}
+			if tt.names == DefaultCleanUp {
+				cleanedDefault := CleanUp(points, 5, UnitsMs)
+				if !equalInts(globalIdxs(cleanedDefault), globalIdxs(valid)) {
+					t.Errorf("CleanUp kept %v, want %v", globalIdxs(cleanedDefault), globalIdxs(valid))
+				}
+			}
+		})
+	}
+}
+
+func TestFilterAccelSamplingRates(t *testing.T) {
+	spikeStart := testStart.Add(30 * time.Second)
+	spikeEnd := testStart.Add(31 * time.Second)
+	inSpike := func(p Point) bool {
+		return !p.ts.Before(spikeStart) && p.ts.Before(spikeEnd)
+	}
+
+	intervals := []time.Duration{time.Second, 100 * time.Millisecond}
+	results := []Stats{}
+	for i := 0; i < len(intervals); i++ {
+		ps := straightTrack(10, time.Minute, intervals[i])
+		// The position is 15 m ahead for a second.
+		spikes := 0
+		for j := 0; j < len(ps); j++ {
+			if inSpike(ps[j]) {
+				ps[j] = movePoint(ps[j], 15)
+				spikes++
+			}
+		}
+
+		res, report := FilterAccel(DefaultMaxAccel)(ps)
+		if report.Removed != spikes {
+			t.Errorf("interval %v: removed %d points, want %d", intervals[i], report.Removed, spikes)
+		}
+		for j := 0; j < len(res); j++ {
+			if inSpike(res[j]) {
+				t.Errorf("interval %v: point at %v kept", intervals[i], res[j].ts)
+			}
+		}
+		results = append(results, CalculateStats(res, StatAll, UnitsMs))
+	}
+
+	// Statistics after cleanup don't depend on the sampling rate.
+	if a, b := results[0].Speed2s().Speed(), results[1].Speed2s().Speed(); !almostEqual(a, b, 0.01) {
+		t.Errorf("2s speed %.3f m/s at 1 Hz, %.3f m/s at 10 Hz", a, b)
+	}
+	if a, b := results[0].Distance(), results[1].Distance(); !almostEqual(a, b, 0.5) {
+		t.Errorf("distance %.3f m at 1 Hz, %.3f m at 10 Hz", a, b)
+	}
+}
+
+func TestTeleportJumpRemoved(t *testing.T) {
+	ps := straightTrack(10, 199*time.Second, time.Second)
+	// After a 3 s gap the track continues 2 km away, the gaps filter
+	// removes the point after the jump.
+	ps = dropPoints(ps, 100, 101)
+	for i := 100; i < len(ps); i++ {
+		ps[i] = movePoint(ps[i], 2000)
+	}
+	cfg := CleanUpConfig{DeltaSpeedMax: 5, SpeedUnits: UnitsMs, TeleportFactor: 3}
+	filters, err := NewCleanUpFilters(DefaultCleanUp, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marked, reports := CleanUpMarked(Points{Ps: ps}, filters)
+	if reports[1].Filter != "teleport" || reports[1].Jumps != 1 {
+		t.Fatalf("got report %+v, want 1 jump", reports[1])
+	}
+	cleaned := ValidPoints(marked)
+	if len(cleaned) < len(ps)-10 {
+		t.Errorf("%d of %d points left: %+v", len(cleaned), len(ps), reports)
+	}
+	jumps := 0
+	for i := 0; i < len(cleaned); i++ {
+		if cleaned[i].jump {
+			jumps++
+			if cleaned[i].globalIdx <= 100 || cleaned[i-1].globalIdx >= 100 {
+				t.Errorf("jump moved to point %d after point %d", cleaned[i].globalIdx, cleaned[i-1].globalIdx)
+			}
+		}
+	}
+	if jumps != 1 {
+		t.Errorf("got %d jumps in cleaned points, want 1", jumps)
+	}
+
+	s := CalculateStats(cleaned, StatAll, UnitsMs)
+	if s.Speed2s().Speed() > 10.01 || s.Speed1NM().Valid() {
+		t.Errorf("statistics span the jump: 2s %s, 1nm %s", s.Speed2s().TxtLine(), s.Speed1NM().TxtLine())
+	}
+	// Points 0-98 and 105-198 are left (the spikes filter removes the last
+	// point), each step is 10 m.
+	if !almostEqual(s.Distance(), 10*(98+93), 1) {
+		t.Errorf("distance %.3f m includes the jump", s.Distance())
+	}
+}
+
+// withDuplicates returns points with a copy of every n-th point moved by
+// given meters, with the same timestamp, like devices emitting occasional
+// duplicate seconds.
+func withDuplicates(ps []Point, n int, north float64) []Point {
+	res := []Point{}
+	for i := 0; i < len(ps); i++ {
+		res = append(res, ps[i])
+		if i > 0 && i%n == 0 {
+			res = append(res, movePoint(ps[i], north))
+		}
+	}
+	return res
+}
+
+func TestRemoveCloseDups(t *testing.T) {
+	ps := straightTrack(10, 10*time.Second, time.Second)
+	tests := []struct {
+		name  string
+		north float64
+		want  int
+	}{
+		{"same position", 0, len(ps)},
+		{"close", dupsMaxDistance - 1, len(ps)},
+		{"far", 50, len(ps) + 2},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		res := removeCloseDups(withDuplicates(ps, 4, tt.north))
+		if len(res) != tt.want {
+			t.Errorf("%s: got %d points, want %d", tt.name, len(res), tt.want)
+		}
+		// The first of duplicate points is kept.
+		for j := 1; j < len(res); j++ {
+			if res[j].ts.Equal(res[j-1].ts) && tt.north < dupsMaxDistance {
+				t.Errorf("%s: duplicate at %v kept", tt.name, res[j].ts)
+			}
+		}
+		if res[4].lat != ps[4].lat {
+			t.Errorf("%s: the first duplicate point not kept", tt.name)
+		}
+	}
+}
+
+func TestCleanUpDuplicateSeconds(t *testing.T) {
+	ps := straightTrack(10, 10*time.Minute, time.Second)
+	clean := CleanUp(gpxFixture(t, ps), 5, UnitsMs)
+	closeDups := CleanUp(gpxFixture(t, withDuplicates(ps, 40, 1)), 5, UnitsMs)
+	farDups := CleanUp(gpxFixture(t, withDuplicates(ps, 40, 50)), 5, UnitsMs)
+
+	// Close duplicates cost nothing, far duplicates are removed with points
+	// around the hole they leave.
+	if len(closeDups) != len(clean) {
+		t.Errorf("%d points left with close duplicates, want %d", len(closeDups), len(clean))
+	}
+	if len(farDups) >= len(closeDups) {
+		t.Errorf("%d points left with far duplicates, want less than %d", len(farDups), len(closeDups))
+	}
+
+	sClean := CalculateStats(clean, StatAll, UnitsMs)
+	sClose := CalculateStats(closeDups, StatAll, UnitsMs)
+	sFar := CalculateStats(farDups, StatAll, UnitsMs)
+	if sClose.Calc5x10sAvg() != sClean.Calc5x10sAvg() || sClose.Distance() != sClean.Distance() {
+		t.Errorf("close duplicates: 5x10 %.3f, distance %.3f, want %.3f, %.3f",
+			sClose.Calc5x10sAvg(), sClose.Distance(), sClean.Calc5x10sAvg(), sClean.Distance())
+	}
+	if sFar.Distance() >= sClose.Distance() {
+		t.Errorf("far duplicates: distance %.3f, want less than %.3f", sFar.Distance(), sClose.Distance())
+	}
+}
+
+// cleanUpSpikesUncached removes spikes like cleanUpSpikes, calculating all
+// speeds between points when they are compared.
+func cleanUpSpikesUncached(psCurr []Point, deltaSpeedMax float64, speedUnits UnitsFlag) []Point {
+	if len(psCurr) < 2 {
+		return psCurr
+	}
+	res := []Point{}
+	res = append(res, psCurr[0], psCurr[1])
+	speedPrev := speed(psCurr[0], psCurr[1], speedUnits)
+	idxRes := 1
+	for idxPs := 2; idxPs < len(psCurr)-1; idxPs++ {
+		if psCurr[idxPs].jump {
+			speedPrev = speed(psCurr[idxPs], psCurr[idxPs+1], speedUnits)
+			res = append(res, psCurr[idxPs])
+			idxRes++
+			continue
+		}
+		speedCur := speed(res[idxRes], psCurr[idxPs], speedUnits)
+		speedNext1 := speed(psCurr[idxPs], psCurr[idxPs+1], speedUnits)
+		speed0Delta := speedCur - speedPrev
+		speed1Delta := speedNext1 - speedCur
+		diffDelta1 := speed0Delta - speed1Delta
+		if (diffDelta1 < deltaSpeedMax) || speed0Delta < 0 {
+			speedPrev = speedCur
+			res = append(res, psCurr[idxPs])
+			idxRes++
+		}
+	}
+	return res
+}
+
+func TestCleanUpSpikesCache(t *testing.T) {
+	spikes := runsTrack(time.Second, 12, 10, 11, 10.5)
+	for i := 20; i < len(spikes); i += 37 {
+		spikes[i] = movePoint(spikes[i], 40)
+		// Consecutive spikes, the speed from the last kept point differs
+		// from the speed from the previous point.
+		spikes[i+1] = movePoint(spikes[i+1], 55)
+	}
+	jumped := runsTrack(time.Second, 13, 12, 13)
+	for i := 60; i < len(jumped); i++ {
+		jumped[i] = movePoint(jumped[i], 2000)
+	}
+	jumped[60].jump = true
+	jumped[61] = movePoint(jumped[61], 30)
+
+	tests := []struct {
+		name string
+		ps   []Point
+	}{
+		{"2 points", straightTrack(10, time.Second, time.Second)},
+		{"3 points", straightTrack(10, 2*time.Second, time.Second)},
+		{"clean", straightTrack(10, time.Minute, time.Second)},
+		{"spikes", spikes},
+		{"noisy", addNoise(runsTrack(time.Second, 14, 9, 10, 11), 3, 15)},
+		{"noisy 10 Hz", addNoise(runsTrack(100*time.Millisecond, 16, 9, 10), 2, 17)},
+		{"jump", jumped},
+	}
+	deltas := []float64{1, 2, 5}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			for j := 0; j < len(deltas); j++ {
+				got := cleanUpSpikes(tt.ps, deltas[j], UnitsKts)
+				want := cleanUpSpikesUncached(tt.ps, deltas[j], UnitsKts)
+				if !equalInts(globalIdxs(got), globalIdxs(want)) {
+					t.Errorf("delta %v: kept %v, want %v", deltas[j], globalIdxs(got), globalIdxs(want))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCleanUpSpikes compares cleanUpSpikes with the cleanup
+// calculating all speeds when points are compared.
+func BenchmarkCleanUpSpikes(b *testing.B) {
+	ps := addNoise(runsTrack(100*time.Millisecond, 18, 9, 10, 9.6, 10.1, 9.8, 10.4, 9.1, 9.9), 1, 19)
+
+	benchmarks := []struct {
+		name    string
+		cleanUp func([]Point, float64, UnitsFlag) []Point
+	}{
+		{"cached", cleanUpSpikes},
+		{"uncached", cleanUpSpikesUncached},
+	}
+	for i := 0; i < len(benchmarks); i++ {
+		bm := benchmarks[i]
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for j := 0; j < b.N; j++ {
+				bm.cleanUp(ps, 5, UnitsKts)
+			}
+		})
+	}
+}
+
+func TestFilterWarmup(t *testing.T) {
+	tests := []struct {
+		name     string
+		speed    float64
+		interval time.Duration
+		bad      int // Number of scattered fixes at the start.
+	}{
+		{"1 Hz", 10, time.Second, 3},
+		{"1 Hz fast", 25, time.Second, 3},
+		{"0.2 Hz", 10, 5 * time.Second, 2},
+		{"10 Hz", 10, 100 * time.Millisecond, 20},
+		{"settled", 10, time.Second, 0},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			ps := straightTrack(tt.speed, 2*time.Minute, tt.interval)
+			for j := 0; j < tt.bad; j++ {
+				// Cold start fixes hundreds of meters apart.
+				ps[j] = movePoint(ps[j], float64(300+200*(j%2)))
+			}
+
+			cleaned, report := FilterWarmup(30 * time.Second)(ps)
+			if report.Removed != tt.bad || len(cleaned) != len(ps)-tt.bad {
+				t.Errorf("removed %d of %d points, want %d", report.Removed, len(ps), tt.bad)
+			}
+		})
+	}
+
+	// Positions never stable long enough.
+	ps := straightTrack(10, 20*time.Second, time.Second)
+	ps[0] = movePoint(ps[0], 500)
+	if cleaned, report := FilterWarmup(30 * time.Second)(ps); report.Removed != 0 || len(cleaned) != len(ps) {
+		t.Errorf("removed %d points of a track never stable for the window", report.Removed)
+	}
+}
+
+func TestFilterExclude(t *testing.T) {
+	ps := straightTrack(10, time.Minute, time.Second)
+	// The slipway around the point 20, 200 m north of the start.
+	slipway := Area{Lat: ps[20].lat, Lon: ps[20].lon, Radius: 25}
+	if !slipway.Contains(ps[22].lat, ps[22].lon) || slipway.Contains(ps[23].lat, ps[23].lon) {
+		t.Fatal("points 18-22 should be inside of the slipway")
+	}
+
+	cleaned, report := FilterExclude([]Area{slipway})(ps)
+	if report.Removed != 5 || report.Jumps != 1 {
+		t.Errorf("removed %d points with %d jumps, want 5 with 1", report.Removed, report.Jumps)
+	}
+	idxs := globalIdxs(cleaned)
+	if len(idxs) != len(ps)-5 || idxs[17] != 17 || idxs[18] != 23 {
+		t.Fatalf("kept points %v, want without 18-22", idxs)
+	}
+	for i := 0; i < len(cleaned); i++ {
+		if cleaned[i].jump != (cleaned[i].globalIdx == 23) {
+			t.Errorf("point %d jump = %v, want true only after the slipway", cleaned[i].globalIdx, cleaned[i].jump)
+		}
+	}
+}
+
+// fillTrack returns a track with a 10 second burst at 12 m/s between
+// 8 m/s legs, sampled every second.
+func fillTrack() []Point {
+	return generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second,
+		legs: []leg{{0, 8, 40 * time.Second}, {0, 12, 10 * time.Second}, {0, 8, 40 * time.Second}}})
+}
+
+// cleanUpWith cleans up points using the named filters.
+func cleanUpWith(t *testing.T, ps []Point, names string) ([]Point, []Report) {
+	t.Helper()
+	filters, err := NewCleanUpFilters(names, CleanUpConfig{DeltaSpeedMax: 5, SpeedUnits: UnitsMs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return CleanUpWith(Points{Name: "fill", Ps: ps}, filters)
+}
+
+func TestFilterFill(t *testing.T) {
+	// The second 45 in the middle of the burst is missing.
+	ps := dropPoints(fillTrack(), 45)
+
+	filled, reports := cleanUpWith(t, ps, "fill,dups,gaps")
+	if reports[1].Filter != "fill" || reports[1].Added != 1 || reports[2].Removed != 0 {
+		t.Fatalf("reports %+v, want 1 point added and none removed", reports)
+	}
+	if len(filled) != len(ps)+1 || !filled[45].interpolated || !filled[45].ts.Equal(testStart.Add(45*time.Second)) {
+		t.Fatalf("point 45 not filled: %d points, point 45 %v interpolated %v", len(filled), filled[45].ts, filled[45].interpolated)
+	}
+	if measured := globalIdxs(Points{Ps: filled}.Measured().Ps); !equalInts(measured, globalIdxs(ps)) {
+		t.Errorf("kept points %v, want all points %v", measured, globalIdxs(ps))
+	}
+
+	trimmed, _ := cleanUpWith(t, ps, "dups,gaps")
+	if len(trimmed) != len(ps)-4 {
+		t.Errorf("dups,gaps kept %d of %d points, want 4 removed", len(trimmed), len(ps))
+	}
+	withFill := CalculateStats(filled, Stat5x10s, UnitsMs)
+	without := CalculateStats(trimmed, Stat5x10s, UnitsMs)
+	if !almostEqual(withFill.Speed5x10s()[0].Speed(), 12, 1e-6) || withFill.Calc5x10sAvg() <= without.Calc5x10sAvg() {
+		t.Errorf("5x10 with fill %.3f (best %.3f), without %.3f (best %.3f), want higher with fill and the best 12",
+			withFill.Calc5x10sAvg(), withFill.Speed5x10s()[0].Speed(), without.Calc5x10sAvg(), without.Speed5x10s()[0].Speed())
+	}
+}
+
+func TestFilterFillNotFilled(t *testing.T) {
+	inconsistent := dropPoints(fillTrack(), 45)
+	// Points after the missing one are 13 m further, the speed over the
+	// missing point is 6.5 m/s higher than before it.
+	for i := 45; i < len(inconsistent); i++ {
+		inconsistent[i] = movePoint(inconsistent[i], 13)
+	}
+
+	tests := []struct {
+		name string
+		ps   []Point
+	}{
+		{"2 missing points", dropPoints(fillTrack(), 45, 46)},
+		{"inconsistent speed", inconsistent},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			res, report := FilterFill(5, UnitsMs)(tt.ps)
+			if report.Added != 0 || len(res) != len(tt.ps) {
+				t.Errorf("added %d points, want 0", report.Added)
+			}
+			// The gaps filter removes points around the missing ones.
+			cleaned, reports := cleanUpWith(t, tt.ps, "fill,dups,gaps")
+			trimmed, _ := cleanUpWith(t, tt.ps, "dups,gaps")
+			if reports[2].Removed != 4 || !equalInts(globalIdxs(cleaned), globalIdxs(trimmed)) {
+				t.Errorf("fill,dups,gaps removed %d points, kept %d, want the same as dups,gaps (%d)",
+					reports[2].Removed, len(cleaned), len(trimmed))
+			}
+		})
+	}
+}
+
+func TestFilterHdop(t *testing.T) {
+	ps := straightTrack(10, 19*time.Second, time.Second)
+	idxs := []int{3, 7, 10, 12}
+	hdops := []float64{2.5, 10, 2, 0.8}
+	for i := 0; i < len(idxs); i++ {
+		ps[idxs[i]].hdop = &hdops[i]
+	}
+
+	tests := []struct {
+		name    string
+		maxHdop float64
+		removed []int
+	}{
+		{"strict", 1, []int{3, 7, 10}},
+		{"equal kept", 2, []int{3, 7}},
+		{"loose", 5, []int{7}},
+		{"all kept", 10, []int{}},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			res, report := FilterHdop(tt.maxHdop)(ps)
+			// Points without hdop are kept.
+			want := globalIdxs(dropPoints(ps, tt.removed...))
+			if !equalInts(globalIdxs(res), want) {
+				t.Errorf("kept %v, want %v", globalIdxs(res), want)
+			}
+			if report.Filter != "poor accuracy" || report.Removed != len(tt.removed) {
+				t.Errorf("report %+v, want %d removed", report, len(tt.removed))
+			}
+		})
+	}
+
+	// The filter is added by the config before named filters.
+	filters, err := NewCleanUpFilters("none", CleanUpConfig{MaxHdop: 2, SpeedUnits: UnitsMs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ := CleanUpWith(Points{Ps: ps}, filters)
+	if want := globalIdxs(dropPoints(ps, 3, 7)); !equalInts(globalIdxs(res), want) {
+		t.Errorf("CleanUpWith() kept %v, want %v", globalIdxs(res), want)
+	}
+}
+
+func TestFilterStationary(t *testing.T) {
+	stop := func(d time.Duration) leg { return leg{0, 0, d} }
+	sail := func(d time.Duration) leg { return leg{0, 10, d} }
+
+	tests := []struct {
+		name       string
+		legs       []leg
+		start, end time.Duration // Times of the first and the last kept point.
+	}{
+		// Movement is detected 8 s before the start and after the end of
+		// sailing (20 m in 10 s is faster than 2 kts), 10 s more are kept.
+		{"stationary start and end", []leg{stop(5 * time.Minute), sail(5 * time.Minute), stop(time.Minute),
+			sail(5 * time.Minute), stop(5 * time.Minute)}, 282 * time.Second, 978 * time.Second},
+		// Interior breaks are kept even when they are longer than minDuration.
+		{"long stop", []leg{stop(5 * time.Minute), sail(5 * time.Minute), stop(3 * time.Minute),
+			sail(5 * time.Minute), stop(5 * time.Minute)}, 282 * time.Second, 1098 * time.Second},
+		{"short stationary start and end", []leg{stop(time.Minute), sail(5 * time.Minute), stop(time.Minute)},
+			0, 7 * time.Minute},
+		{"stationary start only", []leg{stop(5 * time.Minute), sail(5 * time.Minute)},
+			282 * time.Second, 10 * time.Minute},
+		{"no movement", []leg{stop(10 * time.Minute)}, 0, 10 * time.Minute},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			ps := generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second, legs: tt.legs})
+
+			res, report := FilterStationary(2*time.Minute, 2, UnitsKts)(ps)
+			if len(res) == 0 {
+				t.Fatal("all points removed")
+			}
+			start, end := res[0].ts.Sub(testStart), res[len(res)-1].ts.Sub(testStart)
+			if start != tt.start || end != tt.end {
+				t.Errorf("kept %v - %v, want %v - %v", start, end, tt.start, tt.end)
+			}
+			// Points between are not changed, including the short stop.
+			if want := int((tt.end-tt.start)/time.Second) + 1; len(res) != want || report.Removed != len(ps)-want {
+				t.Errorf("kept %d points, removed %d, want %d, %d", len(res), report.Removed, want, len(ps)-want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// ctxCheckPoints is the number of points processed between checks of the
+// context and progress reports.
+const ctxCheckPoints = 10000
+
+// Progress is called during long operations with the phase name ("read",
+// "stats" or "5x10"), the number of points processed and the fraction of the
+// phase completed (from 0 to 1). Fraction 1 is reported at the end of each
+// phase.
+type Progress func(phase string, points int, fraction float64)
+
+// ctxReader is a Reader failing with the context error after the context is
+// done and reporting the fraction of size bytes read.
+type ctxReader struct {
+	ctx      context.Context
+	r        io.Reader
+	size     int64
+	read     int64
+	percent  int64
+	progress Progress
+}
+
+// Read reads from the wrapped Reader unless the context is done.
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	cr.read += int64(n)
+	if cr.progress != nil && cr.size > 0 && cr.read*100/cr.size > cr.percent && cr.read < cr.size {
+		cr.percent = cr.read * 100 / cr.size
+		cr.progress("read", 0, float64(cr.read)/float64(cr.size))
+	}
+	return n, err
+}
+
+// ReadPointsCtx reads points like ReadPointsNamed until the context is done.
+// Progress (if not nil) is reported as the fraction of size bytes read,
+// size is the length of the track if known, 0 otherwise.
+func ReadPointsCtx(ctx context.Context, r io.Reader, name string, size int64,
+	progress Progress, opts ...ReadOption) (Points, error) {
+	points, err := ReadPointsNamed(&ctxReader{ctx: ctx, r: r, size: size, progress: progress}, name, opts...)
+	if ctx.Err() != nil {
+		return points, fmt.Errorf("Reading track points canceled: %w", ctx.Err())
+	}
+	if progress != nil {
+		progress("read", len(points.Ps), 1)
+	}
+	return points, err
+}
+
+// checkCtx returns an error if the context is done and reports progress of
+// the phase every ctxCheckPoints points, with i points of pointsNo processed.
+func checkCtx(ctx context.Context, progress Progress, phase string, i, pointsNo int) error {
+	if i%ctxCheckPoints != 0 {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("Calculating statistics canceled: %w", ctx.Err())
+	}
+	if progress != nil && pointsNo > 0 {
+		progress(phase, i, float64(i)/float64(pointsNo))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// progressReports collects reported fractions by phases.
+type progressReports map[string][]float64
+
+func (pr progressReports) progress(phase string, points int, fraction float64) {
+	pr[phase] = append(pr[phase], fraction)
+}
+
+// checkIncreasing checks if fractions of the phase increase up to 1.
+func (pr progressReports) checkIncreasing(t *testing.T, phase string) {
+	t.Helper()
+	fractions := pr[phase]
+	if len(fractions) < 2 {
+		t.Fatalf("%s reported %v, want more fractions", phase, fractions)
+	}
+	for i := 0; i < len(fractions); i++ {
+		if fractions[i] < 0 || fractions[i] > 1 || (i > 0 && fractions[i] <= fractions[i-1]) {
+			t.Fatalf("%s reported %v, want increasing fractions", phase, fractions)
+		}
+	}
+	if fractions[len(fractions)-1] != 1 {
+		t.Errorf("%s reported %v last, want 1", phase, fractions[len(fractions)-1])
+	}
+}
+
+// gpxBytes returns points saved as a GPX file.
+func gpxBytes(t *testing.T, ps []Point) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := SavePointsAsGpx(Points{Name: "context", Ps: ps}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadPointsCtxProgress(t *testing.T) {
+	ps := straightTrack(10, 50*time.Minute, 100*time.Millisecond)
+	track := gpxBytes(t, ps)
+
+	reports := progressReports{}
+	points, err := ReadPointsCtx(context.Background(), bytes.NewReader(track), "track.gpx",
+		int64(len(track)), reports.progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points.Ps) != len(ps) {
+		t.Errorf("read %d points, want %d", len(points.Ps), len(ps))
+	}
+	reports.checkIncreasing(t, "read")
+}
+
+func TestReadPointsCtxCanceled(t *testing.T) {
+	ps := straightTrack(10, 50*time.Minute, 100*time.Millisecond)
+	track := gpxBytes(t, ps)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	points, err := ReadPointsCtx(ctx, bytes.NewReader(track), "track.gpx", int64(len(track)), nil)
+	if !errors.Is(err, context.Canceled) || len(points.Ps) != 0 {
+		t.Errorf("ReadPointsCtx() = %d points, error %v, want 0, %v", len(points.Ps), err, context.Canceled)
+	}
+
+	// Canceled while reading, reading stops at the next read from the
+	// track.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	reports := progressReports{}
+	points, err = ReadPointsCtx(ctx, bytes.NewReader(track), "track.gpx", int64(len(track)),
+		func(phase string, points int, fraction float64) {
+			reports.progress(phase, points, fraction)
+			if fraction >= 0.1 {
+				cancel()
+			}
+		})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadPointsCtx() error = %v, want %v", err, context.Canceled)
+	}
+	if len(points.Ps) >= len(ps)/2 {
+		t.Errorf("read %d of %d points, want reading stopped after 10%%", len(points.Ps), len(ps))
+	}
+	if fractions := reports["read"]; fractions[len(fractions)-1] >= 0.2 {
+		t.Errorf("reported %v, want no progress after canceling", fractions)
+	}
+}
+
+func TestCalculateStatsCtx(t *testing.T) {
+	ps := straightTrack(10, 50*time.Minute, 100*time.Millisecond)
+
+	reports := progressReports{}
+	s, err := CalculateStatsCtx(context.Background(), ps, Stat5x10s, UnitsMs, DefaultCalcOptions(), reports.progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !almostEqual(s.Calc5x10sAvg(), 10, 1e-6) {
+		t.Errorf("5x10 %.3f m/s, want 10 m/s", s.Calc5x10sAvg())
+	}
+	reports.checkIncreasing(t, "stats")
+	reports.checkIncreasing(t, "5x10")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CalculateStatsCtx(ctx, ps, Stat5x10s, UnitsMs, DefaultCalcOptions(), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("CalculateStatsCtx() error = %v, want %v", err, context.Canceled)
+	}
+}
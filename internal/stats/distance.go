@@ -0,0 +1,64 @@
+package stats
+
+import "github.com/vvidovic/gps-stats/internal/errs"
+
+// DistanceModel selects how distances between positions are calculated.
+type DistanceModel int64
+
+// DistanceModel selects how distances between positions are calculated.
+const (
+	// DistSimple uses the equirectangular approximation, the fastest one,
+	// accurate enough for distances between neighboring GPS points.
+	DistSimple DistanceModel = iota
+	// DistHaversine uses the great-circle distance on a sphere.
+	DistHaversine
+	// DistVincenty uses the distance on the ellipsoid (not implemented yet).
+	DistVincenty
+)
+
+func (m DistanceModel) String() string {
+	switch m {
+	case DistHaversine:
+		return "haversine"
+	case DistVincenty:
+		return "vincenty"
+	default:
+		return "simple"
+	}
+}
+
+// distanceModel is the DistanceModel used for all distances calculated by
+// the package.
+var distanceModel = DistSimple
+
+// ParseDistanceModel returns the DistanceModel with the given name (simple,
+// haversine or vincenty).
+func ParseDistanceModel(name string) (DistanceModel, error) {
+	models := []DistanceModel{DistSimple, DistHaversine, DistVincenty}
+	for i := 0; i < len(models); i++ {
+		if models[i].String() == name {
+			return models[i], nil
+		}
+	}
+	return DistSimple, errs.Errorf("Unknown distance model '%s'.", name)
+}
+
+// SetDistanceModel sets the DistanceModel used for all distances calculated
+// by the package (cleanup, statistics, spots and areas), DistSimple by
+// default. It should be set before points are read.
+func SetDistanceModel(m DistanceModel) error {
+	if m == DistVincenty {
+		return errs.Errorf("Distance model '%s' is not implemented yet.", m)
+	}
+	distanceModel = m
+	return nil
+}
+
+// distLatLon calculates a distance in meters between two positions using
+// the selected DistanceModel.
+func distLatLon(lat1, lon1, lat2, lon2 float64) float64 {
+	if distanceModel == DistHaversine {
+		return dist(lat1, lon1, lat2, lon2)
+	}
+	return distSimple(lat1, lon1, lat2, lon2)
+}
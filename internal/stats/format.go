@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+// detectBytes is the number of bytes at the start of the track passed to
+// Format.Detect.
+const detectBytes = 100
+
+// Format reads tracks of a single file format.
+type Format interface {
+	// Detect returns true if the track starting with prefix bytes (at most
+	// detectBytes, less for shorter tracks) named name (e.g. the file name,
+	// empty if unknown) is in this format.
+	Detect(prefix []byte, name string) bool
+	// Read reads all points of the track.
+	Read(r io.Reader) (Points, error)
+}
+
+// FuncFormat is a Format which can also pass points to a function as soon as
+// they are read, used by ReadPointsFunc. Points of a Format not implementing
+// it are passed after the whole track is read.
+type FuncFormat interface {
+	Format
+	// ReadFunc reads points of the track, calling fn for each of them and
+	// stopping on the first error returned by fn.
+	ReadFunc(r io.Reader, fn func(Point) error) error
+}
+
+// ReadConfig contains options used while reading tracks.
+type ReadConfig struct {
+	// SbnLeapSeconds is the number of seconds subtracted from timestamps of
+	// SBN Geodetic Navigation Data (0x29) messages.
+	SbnLeapSeconds int
+}
+
+// ReadOption changes ReadConfig used by ReadPoints and other functions
+// reading tracks.
+type ReadOption func(cfg *ReadConfig)
+
+// WithSbnLeapSeconds sets the number of seconds subtracted from timestamps
+// of SBN Geodetic Navigation Data (0x29) messages, 0 by default. Receivers
+// reporting GPS time there instead of UTC need gpsLeapSeconds (18 since
+// 2017). Timestamps of Measured Navigation Data (0x02) messages are always
+// converted from GPS time.
+func WithSbnLeapSeconds(n int) ReadOption {
+	return func(cfg *ReadConfig) {
+		cfg.SbnLeapSeconds = n
+	}
+}
+
+// newReadConfig creates ReadConfig with options applied.
+func newReadConfig(opts []ReadOption) ReadConfig {
+	cfg := ReadConfig{}
+	for i := 0; i < len(opts); i++ {
+		opts[i](&cfg)
+	}
+	return cfg
+}
+
+// configurableFormat is a built-in Format reading tracks using ReadConfig.
+type configurableFormat interface {
+	// withConfig returns the Format reading tracks using cfg.
+	withConfig(cfg ReadConfig) Format
+}
+
+// namedFormat is a registered Format with its name.
+type namedFormat struct {
+	name   string
+	format Format
+}
+
+// formats contains registered formats in the order they are detected.
+var formats = []namedFormat{
+	{"sbn", sbnFormat{}},
+	{"gpx", gpxFormat{}},
+}
+
+// RegisterFormat registers a track format with the name used in errors.
+// Formats are detected in the order of registration, after built-in SBN and
+// GPX formats. RegisterFormat is not safe to call while reading tracks, it
+// should be called on initialization (e.g. in init).
+func RegisterFormat(name string, f Format) {
+	formats = append(formats, namedFormat{name: name, format: f})
+}
+
+// detectFormat checks the first bytes of the Reader and returns the first
+// registered Format detecting the track (using cfg if it is configurable)
+// together with the buffered Reader wrapping r, which must be used to read
+// the track because the checked bytes are already read from r.
+func detectFormat(r io.Reader, name string, cfg ReadConfig) (Format, *bufio.Reader, error) {
+	br := bufio.NewReaderSize(r, detectBytes)
+	prefix, _ := br.Peek(detectBytes)
+
+	names := []string{}
+	for i := 0; i < len(formats); i++ {
+		f := formats[i].format
+		if f.Detect(prefix, name) {
+			if cf, ok := f.(configurableFormat); ok {
+				f = cf.withConfig(cfg)
+			}
+			return f, br, nil
+		}
+		names = append(names, formats[i].name)
+	}
+
+	return nil, br, errs.Errorf("%w (tried %s).", errs.ErrUnknownFormat, strings.Join(names, ", "))
+}
+
+// sbnFormat reads SBN tracks, subtracting leapSeconds from timestamps.
+type sbnFormat struct {
+	leapSeconds int
+}
+
+// Detect returns true for tracks starting with the SiRF binary message
+// start sequence followed by the length of the first message.
+func (sbnFormat) Detect(prefix []byte, name string) bool {
+	// 160 162 0 34 253 86 86 105 100 111 118
+	return bytes.HasPrefix(prefix, []byte{160, 162, 0, 34})
+}
+
+// Read reads all points of the SBN track.
+func (f sbnFormat) Read(r io.Reader) (Points, error) {
+	return ReadPointsSbn(r, WithSbnLeapSeconds(f.leapSeconds))
+}
+
+// ReadFunc reads points of the SBN track, calling fn for each of them.
+func (f sbnFormat) ReadFunc(r io.Reader, fn func(Point) error) error {
+	return ReadPointsSbnFunc(r, fn, WithSbnLeapSeconds(f.leapSeconds))
+}
+
+// withConfig returns the SBN format using leap seconds from cfg.
+func (sbnFormat) withConfig(cfg ReadConfig) Format {
+	return sbnFormat{leapSeconds: cfg.SbnLeapSeconds}
+}
+
+// gpxFormat reads GPX tracks.
+type gpxFormat struct{}
+
+// Detect returns true for tracks starting with the XML declaration.
+func (gpxFormat) Detect(prefix []byte, name string) bool {
+	// 60 63 120 109 108 32 118 101 114 115 105
+	return bytes.HasPrefix(prefix, []byte("<?xml "))
+}
+
+// Read reads all points of the GPX track.
+func (gpxFormat) Read(r io.Reader) (Points, error) {
+	return ReadPointsGpx(r)
+}
+
+// ReadFunc reads points of the GPX track, calling fn for each of them.
+func (gpxFormat) ReadFunc(r io.Reader, fn func(Point) error) error {
+	return ReadPointsGpxFunc(r, fn)
+}
@@ -0,0 +1,126 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+// testFormat detects tracks starting with the prefix and reads a single
+// point named by the format name.
+type testFormat struct {
+	name   string
+	prefix string
+}
+
+func (f testFormat) Detect(prefix []byte, name string) bool {
+	return bytes.HasPrefix(prefix, []byte(f.prefix))
+}
+
+func (f testFormat) Read(r io.Reader) (Points, error) {
+	return Points{Name: f.name, Ps: []Point{NewPoint(45.8, 15.9, testStart)}}, nil
+}
+
+// withFormats registers the formats for the test, restoring the registry
+// when the test ends.
+func withFormats(t *testing.T, fs ...testFormat) {
+	t.Helper()
+	registered := formats
+	formats = append([]namedFormat{}, formats...)
+	t.Cleanup(func() { formats = registered })
+	for i := 0; i < len(fs); i++ {
+		RegisterFormat(fs[i].name, fs[i])
+	}
+}
+
+func TestRegisterFormatDetectionOrder(t *testing.T) {
+	withFormats(t,
+		testFormat{name: "first", prefix: "TRK"},
+		testFormat{name: "second", prefix: "TRK"},
+		testFormat{name: "any", prefix: ""})
+
+	var buf bytes.Buffer
+	if err := SavePointsAsGpx(NewPoints("gpx", []Point{NewPoint(45.8, 15.9, testStart)}), &buf); err != nil {
+		t.Fatal(err)
+	}
+	gpxTrack := buf.String()
+
+	tests := []struct {
+		name  string
+		track string
+		want  string
+	}{
+		{"first registered wins", "TRK 1", "first"},
+		{"built-in formats first", gpxTrack, "gpx - cleaned up by gps-stat"},
+		{"registered last", "NMEA", "any"},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := ReadPoints(strings.NewReader(tt.track))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if points.Name != tt.want {
+				t.Errorf("ReadPoints() read %q, want %q", points.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownFormatError(t *testing.T) {
+	withFormats(t, testFormat{name: "trk", prefix: "TRK"})
+
+	_, err := ReadPoints(strings.NewReader("NMEA"))
+	if !errors.Is(err, errs.ErrUnknownFormat) {
+		t.Fatalf("ReadPoints() error = %v, want %v", err, errs.ErrUnknownFormat)
+	}
+	if !strings.Contains(err.Error(), "(tried sbn, gpx, trk)") {
+		t.Errorf("ReadPoints() error = %q, want formats tried listed", err.Error())
+	}
+}
+
+// truncatedFormat reads 3 points of a truncated track, not implementing
+// FuncFormat.
+type truncatedFormat struct{}
+
+func (truncatedFormat) Detect(prefix []byte, name string) bool {
+	return bytes.HasPrefix(prefix, []byte("TRUNC"))
+}
+
+func (truncatedFormat) Read(r io.Reader) (Points, error) {
+	return NewPoints("truncated", straightTrack(10, 2*time.Second, time.Second)), errs.Errorf("%w.", errs.ErrTruncated)
+}
+
+func TestReadPointsFuncFormatError(t *testing.T) {
+	withFormats(t)
+	RegisterFormat("trunc", truncatedFormat{})
+
+	read := []int{}
+	err := ReadPointsFunc(strings.NewReader("TRUNC"), func(p Point) error {
+		read = append(read, p.globalIdx)
+		return nil
+	})
+	if !errors.Is(err, errs.ErrTruncated) {
+		t.Errorf("ReadPointsFunc() error = %v, want %v", err, errs.ErrTruncated)
+	}
+	if !equalInts(read, []int{0, 1, 2}) {
+		t.Errorf("ReadPointsFunc() passed points %v, want [0 1 2]", read)
+	}
+
+	// Reading stops on the first error returned by fn.
+	stop := errors.New("stop")
+	read = []int{}
+	err = ReadPointsFunc(strings.NewReader("TRUNC"), func(p Point) error {
+		read = append(read, p.globalIdx)
+		return stop
+	})
+	if err != stop || len(read) != 1 {
+		t.Errorf("ReadPointsFunc() = %v after %d points, want %v after 1", err, len(read), stop)
+	}
+}
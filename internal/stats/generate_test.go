@@ -0,0 +1,180 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// leg is a part of a generated track sailed with a constant heading (0° is
+// north, 90° is east) and speed (m/s). Turn points are between legs.
+type leg struct {
+	heading  float64
+	speed    float64
+	duration time.Duration
+}
+
+// trackSpec describes a generated track: the start position and time, the
+// interval between points and legs sailed in order. If deviceSpeed is set,
+// points have the device-reported speed of their leg.
+type trackSpec struct {
+	lat         float64
+	lon         float64
+	start       time.Time
+	interval    time.Duration
+	legs        []leg
+	deviceSpeed bool
+}
+
+// testStart is the start time of generated tracks.
+var testStart = time.Date(2022, 10, 14, 14, 0, 0, 0, time.UTC)
+
+// generateTrack creates points of the track described by the spec, for
+// example to check statistics on precise scenarios (5x10 tracks, alpha
+// gates). Points are calculated without randomness, so the same spec always
+// gives the same points, which can be passed to CalculateStats. The first
+// point is at the start, one point follows every interval until the end of
+// the last leg.
+func generateTrack(spec trackSpec) []Point {
+	mPerLat := earthCircPoles / 360.0
+	mPerLon := earthCircEquator / 360.0 * math.Cos(spec.lat*math.Pi/180)
+
+	duration := time.Duration(0)
+	for i := 0; i < len(spec.legs); i++ {
+		duration += spec.legs[i].duration
+	}
+
+	res := []Point{}
+	if spec.interval <= 0 || len(spec.legs) == 0 {
+		return res
+	}
+	x, y := 0.0, 0.0
+	legIdx := 0
+	legEnd := spec.legs[0].duration
+	for t := time.Duration(0); t <= duration; t += spec.interval {
+		if t > 0 {
+			// Move from the previous point, possibly over turn points.
+			tPrev := t - spec.interval
+			for tPrev < t {
+				for legIdx < len(spec.legs)-1 && tPrev >= legEnd {
+					legIdx++
+					legEnd += spec.legs[legIdx].duration
+				}
+				tNext := t
+				if legEnd < tNext && legIdx < len(spec.legs)-1 {
+					tNext = legEnd
+				}
+				l := spec.legs[legIdx]
+				d := l.speed * (tNext - tPrev).Seconds()
+				x += d * math.Sin(l.heading*math.Pi/180)
+				y += d * math.Cos(l.heading*math.Pi/180)
+				tPrev = tNext
+			}
+		}
+		p := Point{
+			isPoint:   true,
+			lat:       spec.lat + y/mPerLat,
+			lon:       spec.lon + x/mPerLon,
+			ts:        spec.start.Add(t),
+			globalIdx: len(res),
+		}
+		if spec.deviceSpeed {
+			s := spec.legs[legIdx].speed
+			p.speed = &s
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// straightTrack generates a track sailed north with a constant speed (m/s)
+// for the duration, with a point every interval.
+func straightTrack(speed float64, duration, interval time.Duration) []Point {
+	return generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: interval,
+		legs: []leg{{heading: 0, speed: speed, duration: duration}}})
+}
+
+// longTrack generates a track of 100001 points sampled at 10 Hz, sailed
+// around a 1 km square with the speed changing every 100 s leg.
+func longTrack() []Point {
+	legs := []leg{}
+	for i := 0; i < 100; i++ {
+		legs = append(legs, leg{heading: float64(i%4) * 90, speed: 8 + float64(i%5), duration: 100 * time.Second})
+	}
+	return generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: 100 * time.Millisecond, legs: legs})
+}
+
+// jumpedTrack returns a 10 m/s straight track lasting 200 s sampled every
+// second, with points from index 100 moved 2 km north after a position jump.
+func jumpedTrack() []Point {
+	ps := straightTrack(10, 200*time.Second, time.Second)
+	for i := 100; i < len(ps); i++ {
+		ps[i] = movePoint(ps[i], 2000)
+	}
+	ps[100].jump = true
+	return ps
+}
+
+// almostEqual checks if floats differ less than the tolerance.
+func almostEqual(a, b, tolerance float64) bool {
+	return math.Abs(a-b) < tolerance
+}
+
+func TestGenerateTrack(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     trackSpec
+		points   int
+		distance float64
+	}{
+		{"no legs", trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second}, 0, 0},
+		{"no interval", trackSpec{lat: 45, lon: 14, start: testStart,
+			legs: []leg{{0, 10, time.Minute}}}, 0, 0},
+		{"single leg", trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second,
+			legs: []leg{{90, 10, time.Minute}}}, 61, 600},
+		{"turn between points", trackSpec{lat: 45, lon: 14, start: testStart, interval: 2 * time.Second,
+			legs: []leg{{0, 10, 61 * time.Second}, {90, 5, 59 * time.Second}}}, 61, 901.2},
+		{"10 Hz", trackSpec{lat: 45, lon: 14, start: testStart, interval: 100 * time.Millisecond,
+			legs: []leg{{180, 8, 10 * time.Second}}}, 101, 80},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			ps := generateTrack(tt.spec)
+			if len(ps) != tt.points {
+				t.Fatalf("got %d points, want %d", len(ps), tt.points)
+			}
+			d := 0.0
+			for j := 1; j < len(ps); j++ {
+				d += distance(ps[j-1], ps[j])
+				if ps[j].globalIdx != j || !ps[j].ts.After(ps[j-1].ts) {
+					t.Fatalf("point %d: globalIdx %d, time %v after %v", j, ps[j].globalIdx, ps[j].ts, ps[j-1].ts)
+				}
+			}
+			// Cutting the corner of a turn between points is shorter
+			// than the legs sailed: 905 m - 15 m + sqrt(10² + 5²) m.
+			if !almostEqual(d, tt.distance, 1) {
+				t.Errorf("got distance %.3f m, want %.3f m", d, tt.distance)
+			}
+		})
+	}
+}
+
+func TestGenerateTrackCalculateStats(t *testing.T) {
+	ps := generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second,
+		legs: []leg{{0, 8, 5 * time.Minute}, {180, 10, 5 * time.Minute}}})
+	s := CalculateStats(ps, StatAll, UnitsMs)
+
+	if !almostEqual(s.Distance(), 5400, 1) {
+		t.Errorf("got distance %.3f m, want 5400 m", s.Distance())
+	}
+	if s.Duration() != 10*time.Minute {
+		t.Errorf("got duration %v, want 10m", s.Duration())
+	}
+	if !almostEqual(s.Speed2s().Speed(), 10, 0.01) {
+		t.Errorf("got 2s speed %.3f m/s, want 10 m/s", s.Speed2s().Speed())
+	}
+	if avg := s.Calc5x10sAvg(); !almostEqual(avg, 10, 0.01) {
+		t.Errorf("got 5x10 average %.3f m/s, want 10 m/s", avg)
+	}
+}
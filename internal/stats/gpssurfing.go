@@ -0,0 +1,40 @@
+package stats
+
+import "fmt"
+
+// TxtGpsSurfing formats statistics for a gps-speedsurfing.com session.
+//
+// gps-speedsurfing.com imports a session from the uploaded track file (GPX
+// or SBN) and calculates rankings itself, the site has no text import for
+// results. The track portion is therefore the cleaned track saved with
+// SavePointsAsGpx and this header lists the results in the order and units
+// of the site's ranking categories, so they can be checked against the
+// site's numbers after the upload (or entered manually):
+//
+//	Date:          2006-01-02
+//	2 Sec:         <speed>
+//	5x10 Sec:      <speed>
+//	1 Hour:        <speed>
+//	Nautical Mile: <speed>
+//	Alpha 500:     <speed>
+//	Distance:      <km>
+//	Units:         <kts|kmh|ms>
+//
+// Speeds are in speedUnits with Stats precision, invalid results are 0.
+func (s Stats) TxtGpsSurfing() string {
+	return fmt.Sprintf(
+		`Date:          %s
+2 Sec:         %s
+5x10 Sec:      %s
+1 Hour:        %s
+Nautical Mile: %s
+Alpha 500:     %s
+Distance:      %s
+Units:         %s
+`,
+		s.StartTime().Format("2006-01-02"),
+		s.fmtSpeed(s.Speed2s()), s.fmt5x10sAvg(),
+		s.fmtSpeed(s.Speed1h()), s.fmtSpeed(s.Speed1NM()),
+		s.fmtSpeed(s.Alpha500()), s.fmtNum(s.Distance()/1000),
+		s.SpeedUnits())
+}
@@ -2,10 +2,12 @@ package stats
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"time"
 
+	"github.com/vvidovic/gps-stats/internal/errs"
 	"github.com/vvidovic/gps-stats/internal/version"
 )
 
@@ -58,6 +60,9 @@ type Trkpt struct {
 	Lon        float64     `xml:"lon,attr"`
 	Ele        float64     `xml:"ele,omitempty"`
 	Time       time.Time   `xml:"time"`
+	Course     *float64    `xml:"course,omitempty"`
+	Sat        *int        `xml:"sat,omitempty"`
+	Hdop       *float64    `xml:"hdop,omitempty"`
 	Extensions *Extensions `xml:"extensions,omitempty"`
 }
 
@@ -68,65 +73,179 @@ type Extensions struct {
 }
 
 // TrackPointExtension contains trimmed-down combination of
-// Garmin trackpoint extension v1 used by Garmin & Amazfit.
+// Garmin trackpoint extension v1 used by Garmin & Amazfit and the course
+// from the extension v2.
 type TrackPointExtension struct {
 	XMLName xml.Name `xml:"TrackPointExtension"`
 	Speed   float64  `xml:"speed,omitempty"`
 	Hr      int16    `xml:"hr,omitempty"`
+	Course  *float64 `xml:"course,omitempty"`
 }
 
-// ReadPointsGpx reads all available GPX Points from the Reader.
+// ReadPointsGpx reads all available GPX Points from the Reader. Track
+// points are decoded one at a time, so the whole file is never kept in
+// memory. If the file is not valid (e.g. truncated), points read before the
+// error are returned with the error.
 func ReadPointsGpx(r io.Reader) (Points, error) {
-	ps := []Point{}
-	res := Points{Ps: ps}
-
-	byteValue, err := io.ReadAll(r)
+	res := Points{Ps: []Point{}}
+	err := readGpx(r, &res, func(p Point) error {
+		res.Ps = append(res.Ps, p)
+		return nil
+	})
 	if err != nil {
 		return res, err
 	}
+	res.TimesFilled, err = fillMissingTimes(res.Ps)
+	return res, err
+}
 
-	var gpx Gpx
-	// we unmarshal our byteArray which contains our
-	err = xml.Unmarshal(byteValue, &gpx)
-	if err != nil {
-		return res, err
-	}
+// ReadPointsGpxFunc reads GPX Points from the Reader like ReadPointsGpx,
+// calling fn for each point as soon as it is decoded instead of returning
+// all of them. Reading stops on the first error returned by fn, which is
+// returned. Missing timestamps are not filled, as that needs the following
+// points, points without a timestamp have a zero time.
+func ReadPointsGpxFunc(r io.Reader, fn func(Point) error) error {
+	return readGpx(r, &Points{}, fn)
+}
 
-	if len(gpx.Trks) > 0 {
-		res.Name = gpx.Trks[0].Name
-		res.Creator = gpx.Creator
-	}
+// readGpx decodes GPX track points from the Reader, calling fn for each of
+// them, and sets the name and the creator of the first track to res.
+func readGpx(r io.Reader, res *Points, fn func(Point) error) error {
+	dec := xml.NewDecoder(r)
+	// Names of elements containing the current token.
+	parents := []string{}
+	creator := ""
+	trksNo := 0
+	segment := 0
+	pointsNo := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return gpxReadErr(err)
+		}
 
-	for trkIdx := 0; trkIdx < len(gpx.Trks); trkIdx++ {
-		for segIdx := 0; segIdx < len(gpx.Trks[trkIdx].Trksegs); segIdx++ {
-			points := gpx.Trks[trkIdx].Trksegs[segIdx].Trkpts
-			for ptIdx := 0; ptIdx < len(points); ptIdx++ {
-				p, err := readPointGpx(points[ptIdx])
+		switch el := token.(type) {
+		case xml.StartElement:
+			parent := ""
+			if len(parents) > 0 {
+				parent = parents[len(parents)-1]
+			}
+			switch {
+			case el.Name.Local == "gpx" && parent == "":
+				for i := 0; i < len(el.Attr); i++ {
+					if el.Attr[i].Name.Local == "creator" {
+						creator = el.Attr[i].Value
+					}
+				}
+			case el.Name.Local == "trk" && parent == "gpx":
+				if trksNo == 0 {
+					res.Creator = creator
+				}
+				trksNo++
+			case el.Name.Local == "name" && parent == "trk" && trksNo == 1:
+				err = dec.DecodeElement(&res.Name, &el)
+				if err != nil {
+					return gpxReadErr(err)
+				}
+				continue
+			case el.Name.Local == "trkpt" && parent == "trkseg":
+				var trkpt Trkpt
+				err = dec.DecodeElement(&trkpt, &el)
 				if err != nil {
-					res.Ps = ps
-					return res, err
+					return gpxReadErr(err)
+				}
+				p, err := readPointGpx(trkpt)
+				if err != nil {
+					return err
 				}
 
 				if p.isPoint {
-					p.globalIdx = len(ps)
-					ps = append(ps, p)
+					p.globalIdx = pointsNo
+					p.segment = segment
+					pointsNo++
+					if err = fn(p); err != nil {
+						return err
+					}
 				}
+				continue
 			}
+			parents = append(parents, el.Name.Local)
+		case xml.EndElement:
+			parents = parents[:len(parents)-1]
+			if el.Name.Local == "trkseg" {
+				segment++
+			}
+		}
+	}
+}
+
+// gpxReadErr returns ErrTruncated wrapping err if the GPX file ended before
+// all elements were closed.
+func gpxReadErr(err error) error {
+	var syntaxErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Msg == "unexpected EOF" {
+		return errs.Errorf("%w (%v).", errs.ErrTruncated, err)
+	}
+	return err
+}
+
+// fillMissingTimes sets timestamps of points without one (zero timestamp),
+// interpolating them linearly between the nearest points with a timestamp.
+// Timestamps before the first and after the last point with a timestamp
+// continue the interval between the nearest 2 points with a timestamp.
+// Returns the number of filled timestamps.
+func fillMissingTimes(ps []Point) (int, error) {
+	timed := []int{}
+	for i := 0; i < len(ps); i++ {
+		if !ps[i].ts.IsZero() {
+			timed = append(timed, i)
 		}
 	}
+	missing := len(ps) - len(timed)
+	if missing == 0 {
+		return 0, nil
+	}
+	if len(timed) < 2 {
+		return 0, errs.Errorf("Not enough timestamps to interpolate %d missing ones, found %d.",
+			missing, len(timed))
+	}
 
-	res.Ps = ps
-	return res, err
+	// interval returns the time between points at indexes i and j of timed
+	// points per point.
+	interval := func(i, j int) time.Duration {
+		return ps[timed[j]].ts.Sub(ps[timed[i]].ts) / time.Duration(timed[j]-timed[i])
+	}
+	for i := 0; i < timed[0]; i++ {
+		ps[i].ts = ps[timed[0]].ts.Add(-interval(0, 1) * time.Duration(timed[0]-i))
+	}
+	for t := 0; t < len(timed)-1; t++ {
+		for i := timed[t] + 1; i < timed[t+1]; i++ {
+			ps[i].ts = ps[timed[t]].ts.Add(interval(t, t+1) * time.Duration(i-timed[t]))
+		}
+	}
+	last := len(timed) - 1
+	for i := timed[last] + 1; i < len(ps); i++ {
+		ps[i].ts = ps[timed[last]].ts.Add(interval(last-1, last) * time.Duration(i-timed[last]))
+	}
+
+	return missing, nil
 }
 
 // readPointGpx transforms a track point from a GPX file
 // to internal Point structure.
 func readPointGpx(trkpt Trkpt) (Point, error) {
-	pt := Point{isPoint: true, lat: trkpt.Lat, lon: trkpt.Lon, ts: trkpt.Time, ele: trkpt.Ele}
+	pt := Point{isPoint: true, lat: trkpt.Lat, lon: trkpt.Lon, ts: trkpt.Time, ele: trkpt.Ele,
+		hdop: trkpt.Hdop, sat: trkpt.Sat, course: trkpt.Course}
 	if trkpt.Extensions != nil && trkpt.Extensions.TrackPointExtension != nil {
 		tpe := trkpt.Extensions.TrackPointExtension
 		pt.speed = &tpe.Speed
 		pt.hr = &tpe.Hr
+		if tpe.Course != nil {
+			pt.course = tpe.Course
+		}
 	}
 	return pt, nil
 }
@@ -154,9 +273,18 @@ func SavePointsAsGpx(p Points, w io.Writer) error {
 			Lat:  p.lat,
 			Lon:  p.lon,
 			Time: p.ts,
-			Ele:  p.ele}
+			Ele:  p.ele,
+			Sat:  p.sat,
+			Hdop: p.hdop}
 		if p.speed != nil || p.hr != nil {
-			trkpt.Extensions = &Extensions{TrackPointExtension: &TrackPointExtension{Speed: *p.speed, Hr: *p.hr}}
+			tpe := &TrackPointExtension{}
+			if p.speed != nil {
+				tpe.Speed = *p.speed
+			}
+			if p.hr != nil {
+				tpe.Hr = *p.hr
+			}
+			trkpt.Extensions = &Extensions{TrackPointExtension: tpe}
 		}
 		trkpts = append(trkpts, trkpt)
 	}
@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+func TestReadPointsGpxNoPoints(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SavePointsAsGpx(Points{Name: "empty", Ps: []Point{}}, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := ReadPoints(&buf)
+	if !errors.Is(err, errs.ErrNoPoints) {
+		t.Errorf("ReadPoints() error = %v, want %v", err, errs.ErrNoPoints)
+	}
+	if len(points.Ps) != 0 {
+		t.Errorf("len(Ps) = %d, want 0", len(points.Ps))
+	}
+}
+
+func TestReadPointsGpxTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	ps := straightTrack(10, 9*time.Second, time.Second)
+	if err := SavePointsAsGpx(Points{Name: "truncated", Ps: ps}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// The track ends in the middle of the last point.
+	track := buf.String()
+	track = track[:strings.LastIndex(track, "<trkpt")+10]
+
+	points, err := ReadPoints(strings.NewReader(track))
+	if !errors.Is(err, errs.ErrTruncated) {
+		t.Errorf("ReadPoints() error = %v, want %v", err, errs.ErrTruncated)
+	}
+	if len(points.Ps) != len(ps)-1 {
+		t.Errorf("len(Ps) = %d, want %d points read before the end", len(points.Ps), len(ps)-1)
+	}
+}
+
+func TestReadPointsUnknownFormat(t *testing.T) {
+	points, err := ReadPoints(strings.NewReader("lat,lon,time\n45.8,15.9,2022-10-14T14:00:00Z\n"))
+	if !errors.Is(err, errs.ErrUnknownFormat) {
+		t.Errorf("ReadPoints() error = %v, want %v", err, errs.ErrUnknownFormat)
+	}
+	if len(points.Ps) != 0 {
+		t.Errorf("len(Ps) = %d, want 0", len(points.Ps))
+	}
+}
+
+// gpxTrack returns a GPX track with a point going north for each of the
+// times, points with an empty time have no <time> element.
+func gpxTrack(times []string) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<gpx creator="test" version="1.1" xmlns="http://www.topografix.com/GPX/1/1"><trk><name>times</name><trkseg>
+`)
+	for i := 0; i < len(times); i++ {
+		sb.WriteString(fmt.Sprintf(`<trkpt lat="%.7f" lon="14.0000000">`, 45+float64(i)*0.0001))
+		if times[i] != "" {
+			sb.WriteString("<time>" + times[i] + "</time>")
+		}
+		sb.WriteString("</trkpt>\n")
+	}
+	sb.WriteString("</trkseg></trk></gpx>\n")
+	return sb.String()
+}
+
+func TestReadPointsGpxMissingTimes(t *testing.T) {
+	at := func(secs int) time.Time { return testStart.Add(time.Duration(secs) * time.Second) }
+
+	tests := []struct {
+		name   string
+		times  []string
+		want   []time.Time
+		filled int
+	}{
+		{"all times", []string{"2022-10-14T14:00:00Z", "2022-10-14T14:00:01Z", "2022-10-14T14:00:02Z"},
+			[]time.Time{at(0), at(1), at(2)}, 0},
+		{"inner points", []string{"2022-10-14T14:00:00Z", "", "2022-10-14T14:00:04Z", "", "", "2022-10-14T14:00:10Z"},
+			[]time.Time{at(0), at(2), at(4), at(6), at(8), at(10)}, 3},
+		// Before the first and after the last time intervals between the
+		// nearest points are used.
+		{"first and last points", []string{"", "2022-10-14T14:00:02Z", "", "2022-10-14T14:00:06Z", "", "",
+			"2022-10-14T14:00:15Z", ""},
+			[]time.Time{at(0), at(2), at(4), at(6), at(9), at(12), at(15), at(18)}, 5},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := ReadPoints(strings.NewReader(gpxTrack(tt.times)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if points.TimesFilled != tt.filled || len(points.Ps) != len(tt.want) {
+				t.Fatalf("read %d points, %d times filled, want %d, %d",
+					len(points.Ps), points.TimesFilled, len(tt.want), tt.filled)
+			}
+			for j := 0; j < len(tt.want); j++ {
+				if !points.Ps[j].ts.Equal(tt.want[j]) {
+					t.Errorf("point %d time %v, want %v", j, points.Ps[j].ts, tt.want[j])
+				}
+			}
+		})
+	}
+}
+
+func TestReadPointsGpxNoTimes(t *testing.T) {
+	tests := []struct {
+		name    string
+		times   []string
+		wantErr string
+	}{
+		{"no times", []string{"", "", ""}, "Not enough timestamps to interpolate 3 missing ones, found 0."},
+		{"single time", []string{"", "2022-10-14T14:00:00Z", ""},
+			"Not enough timestamps to interpolate 2 missing ones, found 1."},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPoints(strings.NewReader(gpxTrack(tt.times)))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ReadPoints() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
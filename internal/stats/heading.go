@@ -0,0 +1,167 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	headingBinSize     = 10  // Heading rose bin size in degrees
+	minHeadingDistance = 0.5 // Minimum distance in meters between points to trust the heading
+)
+
+// HeadingRose contains time spent (in seconds) in each heading bin, starting
+// with the bin [0°, 10°), and the two most prominent opposite lobes.
+type HeadingRose struct {
+	Bins         []float64
+	BinSize      float64
+	PrimaryBin   int
+	SecondaryBin int
+}
+
+// heading calculates a compass heading (0° is north, 90° is east) when
+// moving from p1 to p2, ignoring curvature of the earth surface (small
+// distances).
+func heading(p1, p2 Point) float64 {
+	dLatM := (p2.lat - p1.lat) / 360 * earthCircPoles
+	dLonM := (p2.lon - p1.lon) / 360 * earthCircEquator * math.Cos((p1.lat+p2.lat)/2*math.Pi/180)
+
+	h := math.Atan2(dLonM, dLatM) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// pointsHeading returns the course reported by the device at p2 (in
+// [0°, 360°)) if available, the heading from p1 to p2 otherwise or if the
+// course is not a finite number. Device course is calculated from doppler
+// velocity, so it is not affected by position noise.
+func pointsHeading(p1, p2 Point) float64 {
+	if p2.course != nil && !math.IsNaN(*p2.course) && !math.IsInf(*p2.course, 0) {
+		return math.Mod(math.Mod(*p2.course, 360)+360, 360)
+	}
+	return heading(p1, p2)
+}
+
+// HeadingHistogram sums durations between consecutive points into heading
+// bins of binSize degrees. Pairs of points too close to each other to have
+// a reliable heading or separated by a position jump are ignored.
+func HeadingHistogram(ps []Point, binSize float64) []float64 {
+	return headingHistogram(ps, binSize, minHeadingDistance)
+}
+
+// headingHistogram sums durations into heading bins, ignoring pairs of
+// points closer than minDistance meters.
+func headingHistogram(ps []Point, binSize, minDistance float64) []float64 {
+	binsNo := int(math.Ceil(360 / binSize))
+	bins := make([]float64, binsNo)
+	for i := 1; i < len(ps); i++ {
+		dt := ps[i].ts.Sub(ps[i-1].ts).Seconds()
+		if dt <= 0 || ps[i].jump || distance(ps[i-1], ps[i]) < minDistance {
+			continue
+		}
+		binIdx := int(pointsHeading(ps[i-1], ps[i])/binSize) % binsNo
+		bins[binIdx] += dt
+	}
+
+	return bins
+}
+
+// CalculateHeadingRose creates a heading rose from points and finds the
+// primary lobe (bin with the most time) and the secondary lobe (bin with the
+// most time within 45° of the primary lobe opposite heading).
+func CalculateHeadingRose(ps []Point) HeadingRose {
+	return calculateHeadingRose(ps, headingBinSize, minHeadingDistance)
+}
+
+// calculateHeadingRose creates a heading rose with bins of binSize degrees,
+// ignoring pairs of points closer than minDistance meters.
+func calculateHeadingRose(ps []Point, binSize, minDistance float64) HeadingRose {
+	return headingRoseFromBins(headingHistogram(ps, binSize, minDistance), binSize)
+}
+
+// headingRoseFromBins creates a heading rose from the heading histogram with
+// bins of binSize degrees.
+func headingRoseFromBins(bins []float64, binSize float64) HeadingRose {
+	res := HeadingRose{
+		Bins:         bins,
+		BinSize:      binSize,
+		PrimaryBin:   -1,
+		SecondaryBin: -1,
+	}
+	binsNo := len(res.Bins)
+	for i := 0; i < binsNo; i++ {
+		if res.Bins[i] > 0 && (res.PrimaryBin < 0 || res.Bins[i] > res.Bins[res.PrimaryBin]) {
+			res.PrimaryBin = i
+		}
+	}
+	if res.PrimaryBin < 0 {
+		return res
+	}
+
+	opposite := res.PrimaryBin + binsNo/2
+	searchBins := int(45 / res.BinSize)
+	for i := opposite - searchBins; i <= opposite+searchBins; i++ {
+		binIdx := i % binsNo
+		if res.Bins[binIdx] > 0 &&
+			(res.SecondaryBin < 0 || res.Bins[binIdx] > res.Bins[res.SecondaryBin]) {
+			res.SecondaryBin = binIdx
+		}
+	}
+
+	return res
+}
+
+// binCenter returns the heading in the middle of the given bin.
+func (r HeadingRose) binCenter(binIdx int) float64 {
+	return (float64(binIdx) + 0.5) * r.BinSize
+}
+
+// WindAxis returns the axis perpendicular to the riding axis (derived from
+// the primary and secondary lobes) as the two possible wind directions.
+// If no lobes are found ok is false.
+func (r HeadingRose) WindAxis() (float64, float64, bool) {
+	if r.PrimaryBin < 0 {
+		return 0, 0, false
+	}
+	ridingAxis := r.binCenter(r.PrimaryBin)
+	if r.SecondaryBin >= 0 {
+		// Average the primary heading with the reversed secondary heading.
+		secondaryReversed := math.Mod(r.binCenter(r.SecondaryBin)+180, 360)
+		diff := math.Mod(secondaryReversed-ridingAxis+540, 360) - 180
+		ridingAxis = math.Mod(ridingAxis+diff/2+360, 360)
+	}
+
+	return math.Mod(ridingAxis+90, 360), math.Mod(ridingAxis+270, 360), true
+}
+
+// TxtRose formats the heading rose as a human-readable text.
+func (r HeadingRose) TxtRose() string {
+	var sb strings.Builder
+	maxBin := 0.0
+	for i := 0; i < len(r.Bins); i++ {
+		maxBin = math.Max(maxBin, r.Bins[i])
+	}
+	for i := 0; i < len(r.Bins); i++ {
+		bar := 0
+		if maxBin > 0 {
+			bar = int(math.Round(r.Bins[i] / maxBin * 40))
+		}
+		sb.WriteString(fmt.Sprintf("%03.0f-%03.0f: %06.1f min %s\n",
+			float64(i)*r.BinSize, float64(i+1)*r.BinSize, r.Bins[i]/60,
+			strings.Repeat("#", bar)))
+	}
+	if r.PrimaryBin >= 0 {
+		sb.WriteString(fmt.Sprintf("Primary lobe:       %03.0f°\n", r.binCenter(r.PrimaryBin)))
+	}
+	if r.SecondaryBin >= 0 {
+		sb.WriteString(fmt.Sprintf("Secondary lobe:     %03.0f°\n", r.binCenter(r.SecondaryBin)))
+	}
+	if wd1, wd2, ok := r.WindAxis(); ok {
+		sb.WriteString(fmt.Sprintf("Wind axis:          %03.0f° / %03.0f°\n", wd1, wd2))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPointsHeading(t *testing.T) {
+	ps := straightTrack(10, time.Second, time.Second)
+	course := func(c float64) Point {
+		p := ps[1]
+		p.course = &c
+		return p
+	}
+
+	tests := []struct {
+		name string
+		p2   Point
+		want float64
+	}{
+		{"no course", ps[1], 0},
+		{"course", course(90), 90},
+		{"negative course", course(-15), 345},
+		{"course over 360", course(370), 10},
+		{"course 360", course(360), 0},
+		{"NaN course", course(math.NaN()), 0},
+		{"infinite course", course(math.Inf(1)), 0},
+		{"negative infinite course", course(math.Inf(-1)), 0},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pointsHeading(ps[0], tt.p2); !almostEqual(got, tt.want, 1e-9) {
+				t.Errorf("pointsHeading() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeadingRoseInvalidCourse(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SavePointsAsGpx(Points{Name: "course", Ps: straightTrack(10, time.Minute, time.Second)}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	track := buf.String()
+	courses := []string{"0", "-15", "NaN", "Inf", "-Inf"}
+	for i := 0; i < len(courses); i++ {
+		track = strings.Replace(track, "</time>\n", "</time><course>"+courses[i]+"</course>\n", 1)
+	}
+	points, err := ReadPoints(strings.NewReader(track))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := []Stats{
+		CalculateStats(points.Ps, StatRose, UnitsKts),
+		buildStats(points.Ps, StatRose, UnitsKts),
+	}
+	for i := 0; i < len(stats); i++ {
+		rose := stats[i].HeadingRose()
+		// -15° of the second point is 345°, other courses are not valid and
+		// the heading is north.
+		if rose.Bins[34] != 1 || rose.Bins[0] != 59 {
+			t.Errorf("heading rose %d bins 345°: %v, 0°: %v, want 1 and 59", i, rose.Bins[34], rose.Bins[0])
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hrZonesNo is the number of heart rate zones, from 50% to 100% of max
+// heart rate in steps of 10%.
+const hrZonesNo = 5
+
+// WithHrZones returns a copy of Stats with the time (in hours) spent in
+// each heart rate zone (50-60%, 60-70%, 70-80%, 80-90% and 90-100% of
+// hrMax). Interval between 2 points counts in the zone of its first point,
+// points without heart rate are skipped and heart rates above hrMax count
+// in the last zone.
+func (s Stats) WithHrZones(ps []Point, hrMax int) Stats {
+	s.hrMax = hrMax
+	s.hrZones = nil
+	if hrMax <= 0 {
+		return s
+	}
+	zones := make([]float64, hrZonesNo)
+	found := false
+	for i := 0; i < len(ps)-1; i++ {
+		if ps[i].hr == nil || *ps[i].hr <= 0 {
+			continue
+		}
+		found = true
+		zone := int(float64(*ps[i].hr)/float64(hrMax)*10) - 5
+		if zone < 0 {
+			continue
+		}
+		if zone >= hrZonesNo {
+			zone = hrZonesNo - 1
+		}
+		zones[zone] += ps[i+1].ts.Sub(ps[i].ts).Hours()
+	}
+	if found {
+		s.hrZones = zones
+	}
+	return s
+}
+
+// TxtHrZones formats time spent in heart rate zones as a human-readable
+// text. Returns an empty string if heart rate is not available.
+func (s Stats) TxtHrZones() string {
+	if len(s.hrZones) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Heart Rate Zones:   (max %d bpm)\n", s.hrMax))
+	for i := 0; i < len(s.hrZones); i++ {
+		label := fmt.Sprintf("Zone %d (%d-%d%%):", i+1, 50+i*10, 60+i*10)
+		sb.WriteString(fmt.Sprintf("  %-18s%06.3f h\n", label, s.hrZones[i]))
+	}
+	return sb.String()
+}
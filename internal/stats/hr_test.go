@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+// hrTrack returns points 1 second apart with heart rates, 0 is a point
+// without heart rate.
+func hrTrack(hrs ...int16) []Point {
+	ps := straightTrack(10, time.Duration(len(hrs)-1)*time.Second, time.Second)
+	for i := 0; i < len(hrs); i++ {
+		if hrs[i] > 0 {
+			hr := hrs[i]
+			ps[i].hr = &hr
+		}
+	}
+	return ps
+}
+
+func TestWithHrZonesBoundaries(t *testing.T) {
+	tests := []struct {
+		hr   int16
+		zone int // -1 if below zones.
+	}{
+		{99, -1},
+		{100, 0},
+		{119, 0},
+		{120, 1},
+		{139, 1},
+		{140, 2},
+		{160, 3},
+		{179, 3},
+		{180, 4},
+		{200, 4},
+		// Above hrMax.
+		{220, 4},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		// The last point doesn't start an interval.
+		s := Stats{}.WithHrZones(hrTrack(tt.hr, 100), 200)
+		zones := s.HrZones()
+		if len(zones) != hrZonesNo {
+			t.Fatalf("hr %d: found %d zones, want %d", tt.hr, len(zones), hrZonesNo)
+		}
+		for j := 0; j < len(zones); j++ {
+			want := 0.0
+			if j == tt.zone {
+				want = 1
+			}
+			if !almostEqual(zones[j].Seconds(), want, 1e-6) {
+				t.Errorf("hr %d: zone %d %v, want %vs", tt.hr, j+1, zones[j], want)
+			}
+		}
+	}
+}
+
+func TestWithHrZones(t *testing.T) {
+	// Intervals of 5 s at 130 bpm, 10 s at 150 bpm, 3 s without heart rate,
+	// 7 s at 185 bpm and 4 s at 95 bpm.
+	hrs := []int16{}
+	hrs = append(hrs, 130, 130, 130, 130, 130)
+	hrs = append(hrs, 150, 150, 150, 150, 150, 150, 150, 150, 150, 150)
+	hrs = append(hrs, 0, 0, 0)
+	hrs = append(hrs, 185, 185, 185, 185, 185, 185, 185)
+	hrs = append(hrs, 95, 95, 95, 95, 210)
+	ps := hrTrack(hrs...)
+
+	tests := []struct {
+		name  string
+		ps    []Point
+		hrMax int
+		want  []float64 // Seconds in zones, nil if zones are not available.
+	}{
+		{"zones", ps, 200, []float64{0, 5, 10, 0, 7}},
+		{"lower max", ps, 160, []float64{4, 0, 0, 5, 17}},
+		{"no max", ps, 0, nil},
+		{"no heart rate", straightTrack(10, time.Minute, time.Second), 200, nil},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			s := Stats{}.WithHrZones(tt.ps, tt.hrMax)
+			zones := s.HrZones()
+			if len(zones) != len(tt.want) {
+				t.Fatalf("found %d zones, want %d", len(zones), len(tt.want))
+			}
+			for j := 0; j < len(zones); j++ {
+				if !almostEqual(zones[j].Seconds(), tt.want[j], 1e-6) {
+					t.Errorf("zone %d %v, want %vs", j+1, zones[j], tt.want[j])
+				}
+			}
+			if (s.TxtHrZones() == "") != (tt.want == nil) {
+				t.Errorf("TxtHrZones() = %q", s.TxtHrZones())
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// interpolatePoint creates a Point at the time ts between Points p1 and p2,
+// assuming constant speed between them, marked as interpolated.
+func interpolatePoint(p1, p2 Point, ts time.Time) Point {
+	dt := p2.ts.Sub(p1.ts).Seconds()
+	f := 0.0
+	if dt > 0 {
+		f = ts.Sub(p1.ts).Seconds() / dt
+	}
+	return Point{
+		isPoint: true,
+		lat:     p1.lat + (p2.lat-p1.lat)*f,
+		lon:     p1.lon + (p2.lon-p1.lon)*f,
+		ele:     p1.ele + (p2.ele-p1.ele)*f,
+		ts:      ts,
+		segment: p1.segment,
+
+		interpolated: true,
+	}
+}
+
+// interpolatedTrack creates a Track lasting exactly duration seconds
+// starting at ps[start] (if forward) or ending at ps[start], with the other
+// end interpolated between points. Returns an invalid Track if the track is
+// too short, the interpolated end falls into a gap longer than duration or
+// the track spans a position jump.
+func interpolatedTrack(ps []Point, start int, duration float64, forward bool,
+	speedUnits UnitsFlag) Track {
+	res := Track{speedUnits: speedUnits}
+	d := time.Duration(duration * float64(time.Second))
+	if forward {
+		end := ps[start].ts.Add(d)
+		i := start + 1
+		for i < len(ps) && ps[i].ts.Before(end) {
+			i++
+		}
+		if i >= len(ps) || ps[i].ts.Sub(ps[i-1].ts).Seconds() > duration || hasJump(ps[start+1:i+1]) {
+			return res
+		}
+		res.ps = append(res.ps, ps[start:i]...)
+		res.ps = append(res.ps, interpolatePoint(ps[i-1], ps[i], end))
+	} else {
+		begin := ps[start].ts.Add(-d)
+		i := start - 1
+		for i >= 0 && ps[i].ts.After(begin) {
+			i--
+		}
+		if i < 0 || ps[i+1].ts.Sub(ps[i].ts).Seconds() > duration || hasJump(ps[i+1:start+1]) {
+			return res
+		}
+		res.ps = append(res.ps, interpolatePoint(ps[i], ps[i+1], begin))
+		res.ps = append(res.ps, ps[i+1:start+1]...)
+	}
+	res = res.reCalculate()
+	res.valid = true
+	return res
+}
+
+// hasJump returns true if any of the points is after a position jump.
+func hasJump(ps []Point) bool {
+	for i := 0; i < len(ps); i++ {
+		if ps[i].jump {
+			return true
+		}
+	}
+	return false
+}
+
+// interpolatedTracks returns all valid Tracks lasting exactly duration
+// seconds which start or end at a valid point. Average speed of a track
+// sliding over linear segments changes linearly between samples, so the
+// fastest tracks start or end at a sample.
+func interpolatedTracks(ps []Point, duration float64, speedUnits UnitsFlag) []Track {
+	ps = ValidPoints(ps)
+	for i := 0; i < len(ps); i++ {
+		// Distances cached by cleanup may be between other points.
+		ps[i].idx = i
+		ps[i].nextDistOk = false
+	}
+	res := []Track{}
+	for i := 0; i < len(ps); i++ {
+		forward := interpolatedTrack(ps, i, duration, true, speedUnits)
+		if forward.valid {
+			res = append(res, forward)
+		}
+		backward := interpolatedTrack(ps, i, duration, false, speedUnits)
+		if backward.valid {
+			res = append(res, backward)
+		}
+	}
+	return res
+}
+
+// Interpolate2s returns a copy of Stats with the 2 second peak calculated
+// from tracks lasting exactly 2 seconds, interpolating positions at the
+// track boundaries instead of using whole samples. It makes peaks
+// comparable between devices with different sampling rates.
+func (s Stats) Interpolate2s(ps []Point) Stats {
+	s.speed2s = Track{speedUnits: s.speedUnits}
+	tracks := interpolatedTracks(ps, 2, s.speedUnits)
+	for i := 0; i < len(tracks); i++ {
+		if s.speed2s.speed < tracks[i].speed {
+			s.speed2s = tracks[i]
+		}
+	}
+	return s
+}
+
+// Interpolate5x10s returns a copy of Stats with the 5x10 tracks selected
+// from tracks lasting exactly 10 seconds, like Interpolate2s. Interpolated
+// tracks don't end at samples, so selected tracks don't overlap in time
+// instead of not sharing points.
+func (s Stats) Interpolate5x10s(ps []Point) Stats {
+	tracks := interpolatedTracks(ps, 10, s.speedUnits)
+	// The earlier one of equally fast tracks is selected first.
+	sort.SliceStable(tracks, func(i, j int) bool {
+		return tracks[i].speed > tracks[j].speed
+	})
+	n := len(s.speed5x10s)
+	s.speed5x10s = []Track{}
+	for i := 0; i < len(tracks) && len(s.speed5x10s) < n; i++ {
+		if tracks[i].speed <= 0 {
+			break
+		}
+		used := false
+		for j := 0; j < len(s.speed5x10s); j++ {
+			if overlapInTime(tracks[i], s.speed5x10s[j]) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			s.speed5x10s = append(s.speed5x10s, tracks[i])
+		}
+	}
+	for len(s.speed5x10s) < n {
+		s.speed5x10s = append(s.speed5x10s, Track{speedUnits: s.speedUnits})
+	}
+	return s
+}
+
+// overlapInTime returns true if time spans of the tracks share any instant.
+func overlapInTime(t1, t2 Track) bool {
+	return !t1.ps[0].ts.After(t2.ps[len(t2.ps)-1].ts) && !t2.ps[0].ts.After(t1.ps[len(t1.ps)-1].ts)
+}
@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterpolate2sJump(t *testing.T) {
+	ps := jumpedTrack()
+
+	s := CalculateStats(ps, Stat2s, UnitsMs).Interpolate2s(ps)
+	if !almostEqual(s.Speed2s().Speed(), 10, 1e-6) {
+		t.Errorf("2s %.2f m/s, want 10 m/s", s.Speed2s().Speed())
+	}
+	// Tracks ending or starting right at the jump.
+	if tr := interpolatedTrack(ps, 99, 2, true, UnitsMs); tr.Valid() {
+		t.Errorf("track over the jump forward from the point 99 is valid: %s", tr.TxtLine())
+	}
+	if tr := interpolatedTrack(ps, 100, 2, false, UnitsMs); tr.Valid() {
+		t.Errorf("track over the jump backward from the point 100 is valid: %s", tr.TxtLine())
+	}
+	if tr := interpolatedTrack(ps, 100, 2, true, UnitsMs); !tr.Valid() {
+		t.Error("track starting at the jump is not valid")
+	}
+}
+
+func TestInterpolate2s(t *testing.T) {
+	// Sampled every 1.5 s, 20 m/s between two samples: whole samples give
+	// a 3 s track with 1.5 s at 20 m/s and 1.5 s at 10 m/s, the interpolated
+	// track has 1.5 s at 20 m/s and 0.5 s at 10 m/s.
+	ps := generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: 1500 * time.Millisecond,
+		legs: []leg{{0, 10, 15 * time.Second}, {0, 20, 1500 * time.Millisecond}, {0, 10, 15 * time.Second}}})
+
+	s := CalculateStats(ps, Stat2s, UnitsMs)
+	if !almostEqual(s.Speed2s().Speed(), 15, 1e-3) {
+		t.Errorf("2s from samples %.3f m/s, want 15 m/s", s.Speed2s().Speed())
+	}
+	s = s.Interpolate2s(ps)
+	tr := s.Speed2s()
+	if !almostEqual(tr.Speed(), 17.5, 1e-3) || !almostEqual(tr.duration, 2, 1e-9) {
+		t.Errorf("interpolated 2s %.3f m/s in %.3f s, want 17.5 m/s in 2 s", tr.Speed(), tr.duration)
+	}
+}
+
+func TestInterpolate5x10s(t *testing.T) {
+	// Sampled every 3 s, 20 m/s and 15 m/s for 9 s between slower parts:
+	// whole samples give 12 s tracks, interpolated ones have 9 s of the
+	// faster and 1 s of the slower speed.
+	ps := generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: 3 * time.Second,
+		legs: []leg{
+			{0, 10, 60 * time.Second}, {0, 20, 9 * time.Second},
+			{0, 10, 60 * time.Second}, {0, 15, 9 * time.Second},
+			{0, 10, 60 * time.Second},
+		}})
+
+	s := CalculateStats(ps, Stat5x10s, UnitsMs)
+	tracks := s.Speed5x10s()
+	if !almostEqual(tracks[0].Speed(), 17.5, 1e-3) || !almostEqual(tracks[1].Speed(), 13.75, 1e-3) {
+		t.Errorf("5x10 from samples %.3f, %.3f m/s, want 17.5, 13.75 m/s", tracks[0].Speed(), tracks[1].Speed())
+	}
+
+	s = s.Interpolate5x10s(ps)
+	tracks = s.Speed5x10s()
+	want := []float64{19, 14.5, 10, 10, 10}
+	if len(tracks) != len(want) {
+		t.Fatalf("found %d 5x10 tracks, want %d", len(tracks), len(want))
+	}
+	for i := 0; i < len(want); i++ {
+		if !tracks[i].Valid() || !almostEqual(tracks[i].Speed(), want[i], 1e-3) ||
+			!almostEqual(tracks[i].duration, 10, 1e-9) {
+			t.Errorf("track %d %.3f m/s in %.3f s, want %.3f m/s in 10 s",
+				i, tracks[i].Speed(), tracks[i].duration, want[i])
+		}
+		for j := 0; j < i; j++ {
+			if overlapInTime(tracks[i], tracks[j]) {
+				t.Errorf("track %d overlaps track %d", i, j)
+			}
+		}
+	}
+	if !almostEqual(s.Calc5x10sAvg(), 12.7, 1e-3) {
+		t.Errorf("5x10 average %.3f m/s, want 12.7 m/s", s.Calc5x10sAvg())
+	}
+}
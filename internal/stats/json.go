@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// trackJSON is a JSON representation of a Track.
+type trackJSON struct {
+	Valid    bool       `json:"valid"`
+	Speed    float64    `json:"speed"`
+	Duration float64    `json:"duration"`
+	Distance float64    `json:"distance"`
+	Start    *time.Time `json:"start,omitempty"`
+}
+
+// statsJSON is a JSON representation of Stats. Distances are in meters,
+// durations in seconds and speeds in speedUnits. Alpha500mGate is the
+// distance between the alpha entry and exit. Speed5x10sStdDev,
+// Speed5x10sMin and Speed5x10sMax show the consistency of valid 5x10 tracks.
+type statsJSON struct {
+	SpeedUnits       string     `json:"speedUnits"`
+	TotalDistance    float64    `json:"totalDistance"`
+	DistanceModel    string     `json:"distanceModel"`
+	TotalDuration    float64    `json:"totalDuration"`
+	MovingDuration   float64    `json:"movingDuration"`
+	Speed2s          Track      `json:"speed2s"`
+	Speed5x10sAvg    float64    `json:"speed5x10sAvg"`
+	Speed5x10s       []Track    `json:"speed5x10s"`
+	Speed5x10sStdDev float64    `json:"speed5x10sStdDev"`
+	Speed5x10sMin    float64    `json:"speed5x10sMin"`
+	Speed5x10sMax    float64    `json:"speed5x10sMax"`
+	Speed15m         Track      `json:"speed15m"`
+	Speed1h          Track      `json:"speed1h"`
+	Speed100m        Track      `json:"speed100m"`
+	Speed1NM         Track      `json:"speed1NM"`
+	Alpha500m        Track      `json:"alpha500m"`
+	Alpha500mGate    float64    `json:"alpha500mGate,omitempty"`
+	Start            *time.Time `json:"start,omitempty"`
+	Smoothed         bool       `json:"smoothed,omitempty"`
+	HrZones          []float64  `json:"hrZones,omitempty"`
+}
+
+// MarshalJSON converts the Track to JSON.
+func (t Track) MarshalJSON() ([]byte, error) {
+	res := trackJSON{
+		Valid:    t.valid,
+		Speed:    t.speed,
+		Duration: t.duration,
+		Distance: t.distance,
+	}
+	if len(t.ps) > 0 {
+		res.Start = &t.ps[0].ts
+	}
+	return json.Marshal(res)
+}
+
+// MarshalJSON converts the Stats to JSON.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	res := statsJSON{
+		SpeedUnits:     s.speedUnits.String(),
+		TotalDistance:  s.totalDistance,
+		DistanceModel:  s.distModel.String(),
+		TotalDuration:  s.totalDuration * 3600,
+		MovingDuration: s.movingDuration * 3600,
+		Speed2s:        s.speed2s,
+		Speed5x10sAvg:  s.Calc5x10sAvg(),
+		Speed5x10s:     s.speed5x10s,
+		Speed15m:       s.speed15m,
+		Speed1h:        s.speed1h,
+		Speed100m:      s.speed100m,
+		Speed1NM:       s.speed1NM,
+		Alpha500m:      s.alpha500m,
+		Alpha500mGate:  s.alpha500m.GateDistance(),
+		Smoothed:       s.smoothed,
+	}
+	res.Speed5x10sStdDev, res.Speed5x10sMin, res.Speed5x10sMax, _ = CalcTracksSpread(s.speed5x10s)
+	for i := 0; i < len(s.hrZones); i++ {
+		res.HrZones = append(res.HrZones, s.hrZones[i]*3600)
+	}
+	if !s.startTime.IsZero() {
+		res.Start = &s.startTime
+	}
+	return json.Marshal(res)
+}
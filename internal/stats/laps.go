@@ -0,0 +1,172 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+// Gate is a line between two positions which can be crossed by a track.
+type Gate struct {
+	Lat1 float64 `json:"lat1"`
+	Lon1 float64 `json:"lon1"`
+	Lat2 float64 `json:"lat2"`
+	Lon2 float64 `json:"lon2"`
+}
+
+// GateCrossing contains interpolated time and speed of a single gate crossing.
+type GateCrossing struct {
+	Gate  int       `json:"gate"`
+	Time  time.Time `json:"time"`
+	Speed float64   `json:"speed"`
+}
+
+// Lap is a time between two consecutive crossings of the start gate.
+type Lap struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"`
+}
+
+// Laps contains all gate crossings and laps found in the track.
+type Laps struct {
+	Crossings  []GateCrossing `json:"crossings"`
+	Laps       []Lap          `json:"laps"`
+	BestLap    int            `json:"bestLap"`
+	SpeedUnits string         `json:"speedUnits"`
+}
+
+// ReadGates reads gates from a JSON array of objects with lat1, lon1, lat2
+// and lon2 fields.
+func ReadGates(r io.Reader) ([]Gate, error) {
+	gates := []Gate{}
+	err := json.NewDecoder(r).Decode(&gates)
+	if err != nil {
+		return gates, err
+	}
+	if len(gates) < 1 || len(gates) > 2 {
+		return gates, errs.Errorf("Expected 1 or 2 gates, found %d.", len(gates))
+	}
+
+	return gates, nil
+}
+
+// CalculateLaps finds all crossings of given gates (crossings slower than
+// minSpeed are ignored) and laps between consecutive crossings of the first
+// gate. If the second gate is given, a lap is counted only when the second
+// gate was crossed between two crossings of the first gate. Points separated
+// by a position jump don't cross gates.
+func CalculateLaps(ps []Point, gates []Gate, minSpeed float64, speedUnits UnitsFlag) Laps {
+	res := Laps{Crossings: []GateCrossing{}, Laps: []Lap{}, BestLap: -1,
+		SpeedUnits: speedUnits.String()}
+
+	for i := 0; i < len(ps)-1; i++ {
+		dt := ps[i+1].ts.Sub(ps[i].ts)
+		if dt <= 0 || ps[i+1].jump {
+			continue
+		}
+		for gateIdx := 0; gateIdx < len(gates); gateIdx++ {
+			frac, crossed := crossGate(gates[gateIdx], ps[i], ps[i+1])
+			if !crossed {
+				continue
+			}
+			crossingSpeed := speed(ps[i], ps[i+1], speedUnits)
+			if crossingSpeed < minSpeed {
+				continue
+			}
+			res.Crossings = append(res.Crossings, GateCrossing{
+				Gate:  gateIdx,
+				Time:  ps[i].ts.Add(time.Duration(float64(dt) * frac)),
+				Speed: crossingSpeed})
+		}
+	}
+
+	lapStart := -1
+	secondGateCrossed := len(gates) < 2
+	for i := 0; i < len(res.Crossings); i++ {
+		c := res.Crossings[i]
+		if c.Gate != 0 {
+			secondGateCrossed = true
+			continue
+		}
+		if lapStart >= 0 && secondGateCrossed {
+			start := res.Crossings[lapStart].Time
+			lap := Lap{Start: start, End: c.Time, Duration: c.Time.Sub(start).Seconds()}
+			res.Laps = append(res.Laps, lap)
+			if res.BestLap < 0 || res.Laps[res.BestLap].Duration > lap.Duration {
+				res.BestLap = len(res.Laps) - 1
+			}
+		}
+		lapStart = i
+		secondGateCrossed = len(gates) < 2
+	}
+
+	return res
+}
+
+// crossGate checks if the line between two points crosses the gate and
+// returns the fraction of the way from p1 to p2 where the crossing happens.
+// Positions are projected to meters around the first gate point, the same
+// way as distSimple ignores curvature of the earth surface.
+func crossGate(g Gate, p1, p2 Point) (float64, bool) {
+	cosLat := math.Cos(g.Lat1 * math.Pi / 180)
+	toXY := func(lat, lon float64) (float64, float64) {
+		return (lon - g.Lon1) / 360 * earthCircEquator * cosLat,
+			(lat - g.Lat1) / 360 * earthCircPoles
+	}
+	gx, gy := toXY(g.Lat2, g.Lon2)
+	ax, ay := toXY(p1.lat, p1.lon)
+	bx, by := toXY(p2.lat, p2.lon)
+
+	// Solve a + t*(b-a) = u*g for t and u.
+	dx, dy := bx-ax, by-ay
+	denom := dx*gy - dy*gx
+	if denom == 0 {
+		return 0, false
+	}
+	t := (ay*gx - ax*gy) / denom
+	u := (ay*dx - ax*dy) / denom
+	// Crossing exactly at p2 is counted with the next pair of points.
+	if t < 0 || t >= 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+
+	return t, true
+}
+
+// TxtLaps formats gate crossings and laps as a human-readable text.
+func (l Laps) TxtLaps() string {
+	var sb strings.Builder
+	sb.WriteString("Gate Crossings:\n")
+	for i := 0; i < len(l.Crossings); i++ {
+		c := l.Crossings[i]
+		sb.WriteString(fmt.Sprintf("  Gate %d:          %v (%06.3f %s)\n",
+			c.Gate+1, c.Time.Round(100*time.Millisecond), c.Speed, l.SpeedUnits))
+	}
+	sb.WriteString("Laps:\n")
+	for i := 0; i < len(l.Laps); i++ {
+		lap := l.Laps[i]
+		best := ""
+		if i == l.BestLap {
+			best = " (best)"
+		}
+		sb.WriteString(fmt.Sprintf("  Lap %-3d          %.1f sec (%v)%s\n",
+			i+1, lap.Duration, lap.Start.Round(100*time.Millisecond), best))
+	}
+
+	return sb.String()
+}
+
+// JSONLaps formats gate crossings and laps as JSON.
+func (l Laps) JSONLaps() (string, error) {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
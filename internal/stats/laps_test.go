@@ -0,0 +1,225 @@
+package stats
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eastWestGate returns a 100 m long gate across the track going north
+// through the point.
+func eastWestGate(p Point) Gate {
+	return Gate{Lat1: p.lat, Lon1: p.lon - 0.00064, Lat2: p.lat, Lon2: p.lon + 0.00064}
+}
+
+// trackGate returns the gate between two positions given in meters east and
+// north of the start of the generated tracks with lat 45 and lon 14.
+func trackGate(x1, y1, x2, y2 float64) Gate {
+	mPerLat := earthCircPoles / 360.0
+	mPerLon := earthCircEquator / 360.0 * math.Cos(45*math.Pi/180)
+	return Gate{Lat1: 45 + y1/mPerLat, Lon1: 14 + x1/mPerLon, Lat2: 45 + y2/mPerLat, Lon2: 14 + x2/mPerLon}
+}
+
+// squareLegs returns legs of a square course sailed clockwise from its
+// south-west corner with the side (m) and speed (m/s).
+func squareLegs(side, speed float64) []leg {
+	d := time.Duration(side / speed * float64(time.Second))
+	return []leg{{0, speed, d}, {90, speed, d}, {180, speed, d}, {270, speed, d}}
+}
+
+func TestCrossGate(t *testing.T) {
+	// 100 m long gate going east from the start.
+	gate := trackGate(0, 0, 100, 0)
+	at := func(x, y float64) Point {
+		g := trackGate(x, y, x, y)
+		return NewPoint(g.Lat1, g.Lon1, testStart)
+	}
+
+	tests := []struct {
+		name    string
+		p1, p2  Point
+		crossed bool
+		frac    float64
+	}{
+		{"in the middle", at(50, -5), at(50, 5), true, 0.5},
+		{"backwards", at(20, 3), at(20, -1), true, 0.75},
+		{"diagonal", at(40, -2), at(60, 6), true, 0.25},
+		{"before the gate", at(50, -10), at(50, -1), false, 0},
+		{"past the gate end", at(120, -5), at(120, 5), false, 0},
+		{"before the gate start", at(-1, -5), at(-1, 5), false, 0},
+		{"along the gate", at(10, 0), at(90, 0), false, 0},
+		{"starting on the gate", at(50, 0), at(50, 5), true, 0},
+		{"ending on the gate", at(50, -5), at(50, 0), false, 0},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			frac, crossed := crossGate(gate, tt.p1, tt.p2)
+			if crossed != tt.crossed || !almostEqual(frac, tt.frac, 1e-6) {
+				t.Errorf("crossGate() = %v, %v, want %v, %v", frac, crossed, tt.frac, tt.crossed)
+			}
+		})
+	}
+}
+
+func TestCalculateLapsCrossingTime(t *testing.T) {
+	ps := straightTrack(10, 20*time.Second, time.Second)
+
+	tests := []struct {
+		name string
+		gate Gate
+		want time.Time
+	}{
+		{"between points", eastWestGate(movePoint(ps[5], 2.5)), ps[5].ts.Add(250 * time.Millisecond)},
+		{"at a point", eastWestGate(ps[5]), ps[5].ts},
+		{"near the next point", eastWestGate(movePoint(ps[12], 9)), ps[12].ts.Add(900 * time.Millisecond)},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			laps := CalculateLaps(ps, []Gate{tt.gate}, 0, UnitsMs)
+			// A crossing at a point is counted once.
+			if len(laps.Crossings) != 1 {
+				t.Fatalf("found %d crossings, want 1", len(laps.Crossings))
+			}
+			c := laps.Crossings[0]
+			if c.Gate != 0 || !almostEqual(c.Time.Sub(tt.want).Seconds(), 0, 1e-3) || !almostEqual(c.Speed, 10, 1e-3) {
+				t.Errorf("crossing = %d %v %v, want 0 %v 10", c.Gate, c.Time, c.Speed, tt.want)
+			}
+			if len(laps.Laps) != 0 || laps.BestLap != -1 {
+				t.Errorf("found %d laps, best %d, want 0, -1", len(laps.Laps), laps.BestLap)
+			}
+		})
+	}
+}
+
+func TestCalculateLapsMinSpeed(t *testing.T) {
+	ps := straightTrack(10, 20*time.Second, time.Second)
+	gate := eastWestGate(movePoint(ps[5], 5))
+
+	tests := []struct {
+		name     string
+		minSpeed float64
+		units    UnitsFlag
+		want     int
+	}{
+		{"no limit", 0, UnitsMs, 1},
+		{"slower limit", 9.9, UnitsMs, 1},
+		{"faster limit", 10.1, UnitsMs, 0},
+		{"slower limit in kts", 19, UnitsKts, 1},
+		{"faster limit in kts", 20, UnitsKts, 0},
+		{"slower limit in km/h", 35, UnitsKmh, 1},
+		{"faster limit in km/h", 37, UnitsKmh, 0},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			laps := CalculateLaps(ps, []Gate{gate}, tt.minSpeed, tt.units)
+			if len(laps.Crossings) != tt.want {
+				t.Errorf("found %d crossings, want %d", len(laps.Crossings), tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateLapsSecondGate(t *testing.T) {
+	// A 200 m square at 10 m/s (80 s), a 100 m square at 10 m/s (40 s) which
+	// doesn't reach the second gate, a 200 m square at 20 m/s (40 s) and
+	// the start of the next one.
+	legs := squareLegs(200, 10)
+	legs = append(legs, squareLegs(100, 10)...)
+	legs = append(legs, squareLegs(200, 20)...)
+	legs = append(legs, leg{0, 10, 10 * time.Second})
+	ps := generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second, legs: legs})
+	// Across the west side 50 m from the start and across the east side of
+	// the bigger square.
+	first := trackGate(-50, 50, 50, 50)
+	second := trackGate(150, 100, 250, 100)
+	never := trackGate(1000, 0, 1000, 100)
+
+	tests := []struct {
+		name      string
+		gates     []Gate
+		crossings int
+		durations []float64
+		best      int
+	}{
+		{"one gate", []Gate{first}, 4, []float64{80, 37.5, 42.5}, 1},
+		{"two gates", []Gate{first, second}, 6, []float64{80, 42.5}, 1},
+		{"second gate never crossed", []Gate{first, never}, 4, []float64{}, -1},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			laps := CalculateLaps(ps, tt.gates, 0, UnitsMs)
+			if len(laps.Crossings) != tt.crossings {
+				t.Errorf("found %d crossings, want %d", len(laps.Crossings), tt.crossings)
+			}
+			if len(laps.Laps) != len(tt.durations) {
+				t.Fatalf("found %d laps, want %d", len(laps.Laps), len(tt.durations))
+			}
+			for j := 0; j < len(tt.durations); j++ {
+				if !almostEqual(laps.Laps[j].Duration, tt.durations[j], 1e-3) {
+					t.Errorf("lap %d duration = %v, want %v", j, laps.Laps[j].Duration, tt.durations[j])
+				}
+			}
+			if laps.BestLap != tt.best {
+				t.Errorf("BestLap = %d, want %d", laps.BestLap, tt.best)
+			}
+		})
+	}
+}
+
+func TestReadGates(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    int
+		wantErr bool
+	}{
+		{"one gate", `[{"lat1": 45, "lon1": 14, "lat2": 45, "lon2": 14.001}]`, 1, false},
+		{"two gates", `[{"lat1": 45, "lon1": 14, "lat2": 45, "lon2": 14.001},
+			{"lat1": 45.01, "lon1": 14, "lat2": 45.01, "lon2": 14.001}]`, 2, false},
+		{"no gates", `[]`, 0, true},
+		{"three gates", `[{"lat1": 45}, {"lat1": 46}, {"lat1": 47}]`, 0, true},
+		{"not an array", `{"lat1": 45, "lon1": 14, "lat2": 45, "lon2": 14.001}`, 0, true},
+		{"invalid JSON", `[{"lat1": 45,`, 0, true},
+		{"wrong type", `[{"lat1": "north"}]`, 0, true},
+		{"empty", ``, 0, true},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			gates, err := ReadGates(strings.NewReader(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadGates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(gates) != tt.want {
+				t.Errorf("ReadGates() found %d gates, want %d", len(gates), tt.want)
+			}
+		})
+	}
+
+	gates, _ := ReadGates(strings.NewReader(`[{"lat1": 45.1, "lon1": 14.2, "lat2": 45.3, "lon2": 14.4}]`))
+	if gates[0] != (Gate{Lat1: 45.1, Lon1: 14.2, Lat2: 45.3, Lon2: 14.4}) {
+		t.Errorf("ReadGates() = %v, want %v", gates[0], Gate{Lat1: 45.1, Lon1: 14.2, Lat2: 45.3, Lon2: 14.4})
+	}
+}
+
+func TestCalculateLapsJump(t *testing.T) {
+	ps := jumpedTrack()
+	// Between the last point before the jump and the first one after it.
+	gate := eastWestGate(movePoint(ps[99], 1000))
+
+	laps := CalculateLaps(ps, []Gate{gate}, 0, UnitsMs)
+	if len(laps.Crossings) != 0 {
+		t.Errorf("found %d crossings over the jump, want 0", len(laps.Crossings))
+	}
+
+	ps[100].jump = false
+	laps = CalculateLaps(ps, []Gate{gate}, 0, UnitsMs)
+	if len(laps.Crossings) != 1 {
+		t.Errorf("found %d crossings without the jump, want 1", len(laps.Crossings))
+	}
+}
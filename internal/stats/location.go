@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+// Spot is a named location with a radius (in meters) used to recognize
+// where a session took place.
+type Spot struct {
+	Name   string
+	Lat    float64
+	Lon    float64
+	Radius float64
+}
+
+// Centroid calculates the average position of all points. Returns zeros
+// if there are no points.
+func (p Points) Centroid() (float64, float64) {
+	if len(p.Ps) == 0 {
+		return 0, 0
+	}
+	latSum, lonSum := 0.0, 0.0
+	for i := 0; i < len(p.Ps); i++ {
+		latSum += p.Ps[i].lat
+		lonSum += p.Ps[i].lon
+	}
+	return latSum / float64(len(p.Ps)), lonSum / float64(len(p.Ps))
+}
+
+// ReadSpots reads spots from CSV lines in the format "name,lat,lon,radius".
+// Empty lines and lines starting with '#' are ignored.
+func ReadSpots(r io.Reader) ([]Spot, error) {
+	res := []Spot{}
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 4
+	cr.TrimLeadingSpace = true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		line, _ := cr.FieldPos(0)
+		vals := make([]float64, 3)
+		for i := 0; i < 3; i++ {
+			vals[i], err = strconv.ParseFloat(strings.TrimSpace(record[i+1]), 64)
+			if err != nil {
+				return res, errs.Errorf("Invalid spot number '%s' in line %d.", record[i+1], line)
+			}
+		}
+		res = append(res, Spot{Name: strings.TrimSpace(record[0]), Lat: vals[0], Lon: vals[1], Radius: vals[2]})
+	}
+}
+
+// FindSpot returns the closest spot which contains the given position
+// within its radius.
+func FindSpot(spots []Spot, lat, lon float64) (Spot, bool) {
+	found := false
+	res := Spot{}
+	minDist := 0.0
+	for i := 0; i < len(spots); i++ {
+		d := distLatLon(lat, lon, spots[i].Lat, spots[i].Lon)
+		if d <= spots[i].Radius && (!found || d < minDist) {
+			found = true
+			res = spots[i]
+			minDist = d
+		}
+	}
+	return res, found
+}
+
+// Area is a bounding box or a circle (if Radius is greater than 0) used to
+// keep only points inside the region.
+type Area struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+	Lat    float64
+	Lon    float64
+	Radius float64
+}
+
+// ParseArea parses an area in the format "minLat,minLon,maxLat,maxLon"
+// (bounding box) or "lat,lon,radius" (circle, radius in meters).
+func ParseArea(s string) (Area, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 && len(parts) != 4 {
+		return Area{}, errs.Errorf("Area needs 3 (lat,lon,radius) or 4 (minLat,minLon,maxLat,maxLon) numbers.")
+	}
+	vals := make([]float64, len(parts))
+	for i := 0; i < len(parts); i++ {
+		var err error
+		vals[i], err = strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		if err != nil {
+			return Area{}, errs.Errorf("Invalid area number '%s'.", parts[i])
+		}
+	}
+	if len(vals) == 3 {
+		if vals[2] <= 0 {
+			return Area{}, errs.Errorf("Area radius must be greater than 0.")
+		}
+		return Area{Lat: vals[0], Lon: vals[1], Radius: vals[2]}, nil
+	}
+	if vals[0] > vals[2] || vals[1] > vals[3] {
+		return Area{}, errs.Errorf("Area min values must be less than max values.")
+	}
+	return Area{MinLat: vals[0], MinLon: vals[1], MaxLat: vals[2], MaxLon: vals[3]}, nil
+}
+
+// ParseCircle parses a circle area in the format "lat,lon,radius" (radius
+// in meters).
+func ParseCircle(s string) (Area, error) {
+	if len(strings.Split(s, ",")) != 3 {
+		return Area{}, errs.Errorf("Circle needs 3 numbers (lat,lon,radius).")
+	}
+	return ParseArea(s)
+}
+
+// Contains checks if the position is inside the area.
+func (a Area) Contains(lat, lon float64) bool {
+	if a.Radius > 0 {
+		return distLatLon(a.Lat, a.Lon, lat, lon) <= a.Radius
+	}
+	return lat >= a.MinLat && lat <= a.MaxLat && lon >= a.MinLon && lon <= a.MaxLon
+}
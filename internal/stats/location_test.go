@@ -0,0 +1,83 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseArea(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Area
+		wantErr string
+	}{
+		{"bounding box", "45.1,13.5,45.3,13.9", Area{MinLat: 45.1, MinLon: 13.5, MaxLat: 45.3, MaxLon: 13.9}, ""},
+		{"circle", "45.2, 13.7, 500", Area{Lat: 45.2, Lon: 13.7, Radius: 500}, ""},
+		{"negative coordinates", "-33.9,-70.7,-33.8,-70.6", Area{MinLat: -33.9, MinLon: -70.7, MaxLat: -33.8, MaxLon: -70.6}, ""},
+		{"empty", "", Area{},
+			"Area needs 3 (lat,lon,radius) or 4 (minLat,minLon,maxLat,maxLon) numbers."},
+		{"2 numbers", "45.2,13.7", Area{},
+			"Area needs 3 (lat,lon,radius) or 4 (minLat,minLon,maxLat,maxLon) numbers."},
+		{"5 numbers", "45.1,13.5,45.3,13.9,1", Area{},
+			"Area needs 3 (lat,lon,radius) or 4 (minLat,minLon,maxLat,maxLon) numbers."},
+		{"invalid number", "45.1,13.5,north,13.9", Area{}, "Invalid area number 'north'."},
+		{"missing number", "45.1,,45.3,13.9", Area{}, "Invalid area number ''."},
+		{"zero radius", "45.2,13.7,0", Area{}, "Area radius must be greater than 0."},
+		{"negative radius", "45.2,13.7,-100", Area{}, "Area radius must be greater than 0."},
+		{"min lat greater than max", "45.3,13.5,45.1,13.9", Area{}, "Area min values must be less than max values."},
+		{"min lon greater than max", "45.1,13.9,45.3,13.5", Area{}, "Area min values must be less than max values."},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseArea(tt.s)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("ParseArea(%q) error = %v, want %q", tt.s, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseArea(%q) = %+v, want %+v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterArea(t *testing.T) {
+	// 10 m between points going north.
+	ps := straightTrack(10, 60*time.Second, time.Second)
+
+	tests := []struct {
+		name        string
+		area        Area
+		first, last int // Indexes of the first and the last kept point, -1 if none.
+	}{
+		{"bounding box", Area{MinLat: 44.99, MinLon: 13.99, MaxLat: movePoint(ps[30], 5).lat, MaxLon: 14.01}, 0, 30},
+		{"bounding box around all", Area{MinLat: 44.99, MinLon: 13.99, MaxLat: 45.01, MaxLon: 14.01}, 0, 60},
+		{"bounding box west", Area{MinLat: 44.99, MinLon: 13.98, MaxLat: 45.01, MaxLon: 13.99}, -1, -1},
+		{"circle", Area{Lat: ps[30].lat, Lon: ps[30].lon, Radius: 105}, 20, 40},
+		{"circle at the end", Area{Lat: ps[60].lat, Lon: ps[60].lon, Radius: 55}, 55, 60},
+		{"circle away", Area{Lat: 45.1, Lon: 14, Radius: 1000}, -1, -1},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			res, report := FilterArea(tt.area)(ps)
+			want := []int{}
+			for j := tt.first; j >= 0 && j <= tt.last; j++ {
+				want = append(want, j)
+			}
+			if !equalInts(globalIdxs(res), want) {
+				t.Errorf("kept %v, want %v", globalIdxs(res), want)
+			}
+			if report.Filter != "area" || report.Removed != len(ps)-len(want) {
+				t.Errorf("report %+v, want %d removed", report, len(ps)-len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+// PointOption sets an optional value of a Point created by NewPoint.
+type PointOption func(*Point)
+
+// WithEle sets the Point elevation in meters.
+func WithEle(ele float64) PointOption {
+	return func(p *Point) {
+		p.ele = ele
+	}
+}
+
+// WithSpeed sets the device-reported Point speed in m/s.
+func WithSpeed(speedMs float64) PointOption {
+	return func(p *Point) {
+		p.speed = &speedMs
+	}
+}
+
+// WithHr sets the Point heart rate in beats per minute.
+func WithHr(hr int16) PointOption {
+	return func(p *Point) {
+		p.hr = &hr
+	}
+}
+
+// NewPoint creates a Point from a position and a timestamp, so Points read
+// by other parsers can be passed to CleanUp and CalculateStats. Use NewPoints
+// to create Points from created points. Other values (the index in the
+// track, segment, validity, position jumps and interpolation) are set by
+// NewPoints, cleanup and statistics and can't be set by callers.
+func NewPoint(lat, lon float64, ts time.Time, opts ...PointOption) Point {
+	p := Point{isPoint: true, lat: lat, lon: lon, ts: ts}
+	for i := 0; i < len(opts); i++ {
+		opts[i](&p)
+	}
+	return p
+}
+
+// NewPoints creates Points from points in time order, setting the index of
+// each point in the track, which cleanup reports removed ranges of points
+// with.
+func NewPoints(name string, ps []Point) Points {
+	res := Points{Name: name, Ps: make([]Point, len(ps))}
+	for i := 0; i < len(ps); i++ {
+		res.Ps[i] = ps[i]
+		res.Ps[i].isPoint = true
+		res.Ps[i].globalIdx = i
+	}
+	return res
+}
+
+// PointsFromSlices creates Points from slices of latitudes, longitudes and
+// timestamps of the same length, with values of a single point at the same
+// index.
+func PointsFromSlices(lats, lons []float64, times []time.Time) (Points, error) {
+	if len(lats) != len(lons) || len(lats) != len(times) {
+		return Points{}, errs.Errorf("Slices of different lengths: %d lats, %d lons, %d times.",
+			len(lats), len(lons), len(times))
+	}
+	ps := make([]Point, len(lats))
+	for i := 0; i < len(lats); i++ {
+		ps[i] = NewPoint(lats[i], lons[i], times[i])
+	}
+	return NewPoints("", ps), nil
+}
+
+// Measured returns a copy of Points without points interpolated during
+// cleanup.
+func (p Points) Measured() Points {
+	ps := []Point{}
+	for i := 0; i < len(p.Ps); i++ {
+		if !p.Ps[i].interpolated {
+			ps = append(ps, p.Ps[i])
+		}
+	}
+	p.Ps = ps
+	return p
+}
+
+// MergePoints merges points read from multiple files into a single Points
+// sorted by timestamps, for a session split across files. From points with
+// the same timestamp (overlapping files) only the first one is kept.
+// Returns merged Points and the number of removed duplicates. Name and
+// creator are taken from the first Points.
+func MergePoints(pointsList []Points) (Points, int) {
+	res := Points{}
+	if len(pointsList) == 0 {
+		return res, 0
+	}
+	res.Name = pointsList[0].Name
+	res.Creator = pointsList[0].Creator
+
+	ps := []Point{}
+	for i := 0; i < len(pointsList); i++ {
+		ps = append(ps, pointsList[i].Ps...)
+	}
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].ts.Before(ps[j].ts)
+	})
+
+	res.Ps = []Point{}
+	for i := 0; i < len(ps); i++ {
+		if len(res.Ps) > 0 && ps[i].ts.Equal(res.Ps[len(res.Ps)-1].ts) {
+			continue
+		}
+		p := ps[i]
+		p.globalIdx = len(res.Ps)
+		res.Ps = append(res.Ps, p)
+	}
+	return res, len(ps) - len(res.Ps)
+}
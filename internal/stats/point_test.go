@@ -0,0 +1,149 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNewPoint(t *testing.T) {
+	p := NewPoint(45.8, 15.9, testStart, WithEle(120), WithSpeed(5), WithHr(140))
+
+	if !p.isPoint || p.lat != 45.8 || p.lon != 15.9 || !p.ts.Equal(testStart) {
+		t.Errorf("NewPoint() = %v %v %v %v, want true 45.8 15.9 %v", p.isPoint, p.lat, p.lon, p.ts, testStart)
+	}
+	if p.ele != 120 {
+		t.Errorf("ele = %v, want 120", p.ele)
+	}
+	if p.speed == nil || *p.speed != 5 {
+		t.Errorf("speed = %v, want 5", p.speed)
+	}
+	if p.hr == nil || *p.hr != 140 {
+		t.Errorf("hr = %v, want 140", p.hr)
+	}
+
+	p = NewPoint(45.8, 15.9, testStart)
+	if p.speed != nil || p.hr != nil {
+		t.Errorf("NewPoint() without options speed = %v, hr = %v, want nil", p.speed, p.hr)
+	}
+}
+
+func TestNewPoints(t *testing.T) {
+	ps := []Point{
+		NewPoint(45.8, 15.9, testStart),
+		// Not created by NewPoint.
+		{lat: 45.8001, lon: 15.9, ts: testStart.Add(time.Second)},
+		NewPoint(45.8002, 15.9, testStart.Add(2*time.Second)),
+	}
+
+	points := NewPoints("track", ps)
+	if points.Name != "track" || len(points.Ps) != len(ps) {
+		t.Fatalf("NewPoints() = %q with %d points, want %q with %d", points.Name, len(points.Ps), "track", len(ps))
+	}
+	for i := 0; i < len(points.Ps); i++ {
+		if !points.Ps[i].isPoint || points.Ps[i].globalIdx != i {
+			t.Errorf("point %d isPoint = %v, globalIdx = %d, want true, %d",
+				i, points.Ps[i].isPoint, points.Ps[i].globalIdx, i)
+		}
+	}
+	if ps[1].isPoint {
+		t.Error("NewPoints() modified the passed points")
+	}
+}
+
+func TestPointsFromSlices(t *testing.T) {
+	lats := []float64{45.8, 45.8001, 45.8002}
+	lons := []float64{15.9, 15.9001, 15.9002}
+	times := []time.Time{testStart, testStart.Add(time.Second), testStart.Add(2 * time.Second)}
+
+	points, err := PointsFromSlices(lats, lons, times)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points.Ps) != len(lats) {
+		t.Fatalf("len(Ps) = %d, want %d", len(points.Ps), len(lats))
+	}
+	for i := 0; i < len(lats); i++ {
+		p := points.Ps[i]
+		if !p.isPoint || p.globalIdx != i || p.lat != lats[i] || p.lon != lons[i] || !p.ts.Equal(times[i]) {
+			t.Errorf("point %d = %v %d %v %v %v, want true %d %v %v %v",
+				i, p.isPoint, p.globalIdx, p.lat, p.lon, p.ts, i, lats[i], lons[i], times[i])
+		}
+	}
+
+	if _, err := PointsFromSlices(lats, lons[:2], times); err == nil {
+		t.Error("PointsFromSlices() with different lengths returned no error")
+	}
+}
+
+// ExampleNewPoints shows statistics calculated from points read by another
+// parser, here a minute going north at 10 m/s sampled every second.
+func ExampleNewPoints() {
+	start := time.Date(2022, time.October, 14, 14, 0, 0, 0, time.UTC)
+	ps := []Point{}
+	for i := 0; i <= 60; i++ {
+		// About 10 m north every second.
+		lat := 45.8 + float64(i)*10/111132.954
+		ps = append(ps, NewPoint(lat, 15.9, start.Add(time.Duration(i)*time.Second)))
+	}
+
+	cleaned := CleanUp(NewPoints("example", ps), 5, UnitsMs)
+	s := CalculateStats(cleaned, Stat2s|Stat10sAvg, UnitsMs)
+	fmt.Printf("2s: %.1f m/s\n", s.Speed2s().Speed())
+	fmt.Printf("5x10s: %.1f m/s\n", s.Calc5x10sAvg())
+	// Output:
+	// 2s: 10.0 m/s
+	// 5x10s: 10.0 m/s
+}
+
+func TestMergePoints(t *testing.T) {
+	first := straightTrack(10, time.Minute, time.Second)
+	// Overlapping the first file for 20 seconds, at another longitude.
+	second := generateTrack(trackSpec{lat: 45, lon: 14.1, start: testStart.Add(40 * time.Second), interval: time.Second,
+		legs: []leg{{0, 10, 80 * time.Second}}})
+	third := generateTrack(trackSpec{lat: 45, lon: 14.2, start: testStart.Add(200 * time.Second), interval: time.Second,
+		legs: []leg{{0, 10, 10 * time.Second}}})
+
+	tests := []struct {
+		name       string
+		pointsList []Points
+		points     int
+		dups       int
+		lon40s     float64 // Longitude of the point kept at 40 s.
+	}{
+		{"overlapping", []Points{{Name: "first", Ps: first}, {Name: "second", Ps: second}}, 121, 21, 14},
+		{"overlapping reversed", []Points{{Name: "second", Ps: second}, {Name: "first", Ps: first}}, 121, 21, 14.1},
+		{"unsorted with a gap", []Points{{Name: "third", Ps: third}, {Name: "first", Ps: first}}, 72, 0, 14},
+		{"three files", []Points{{Name: "first", Ps: first}, {Name: "third", Ps: third}, {Name: "second", Ps: second}},
+			132, 21, 14},
+		{"same file twice", []Points{{Name: "first", Ps: first}, {Name: "first", Ps: first}}, 61, 61, 14},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			merged, dups := MergePoints(tt.pointsList)
+			if len(merged.Ps) != tt.points || dups != tt.dups {
+				t.Fatalf("MergePoints() = %d points, %d duplicates, want %d, %d", len(merged.Ps), dups, tt.points, tt.dups)
+			}
+			if merged.Name != tt.pointsList[0].Name {
+				t.Errorf("Name = %q, want %q", merged.Name, tt.pointsList[0].Name)
+			}
+			for j := 0; j < len(merged.Ps); j++ {
+				p := merged.Ps[j]
+				if p.globalIdx != j {
+					t.Fatalf("point %d globalIdx = %d, want %d", j, p.globalIdx, j)
+				}
+				if j > 0 && !p.ts.After(merged.Ps[j-1].ts) {
+					t.Fatalf("point %d at %v, want after %v", j, p.ts, merged.Ps[j-1].ts)
+				}
+				if p.ts.Equal(testStart.Add(40*time.Second)) && p.lon != tt.lon40s {
+					t.Errorf("point at 40 s from longitude %v, want %v", p.lon, tt.lon40s)
+				}
+			}
+		})
+	}
+
+	if merged, dups := MergePoints([]Points{}); len(merged.Ps) != 0 || dups != 0 {
+		t.Errorf("MergePoints() without points = %d points, %d duplicates, want 0, 0", len(merged.Ps), dups)
+	}
+}
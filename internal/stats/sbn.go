@@ -3,77 +3,148 @@ package stats
 import (
 	"bytes"
 	"io"
+	"math"
 	"time"
 
 	"github.com/vvidovic/gps-stats/internal/errs"
 )
 
+const (
+	sbnMsgMeasuredNav = 0x02 // SiRF Measured Navigation Data Out (ECEF)
+	sbnMsgGeodeticNav = 0x29 // SiRF Geodetic Navigation Data
+
+	gpsLeapSeconds = 18 // GPS time is ahead of UTC by this number of seconds
+
+	wgs84A  = 6378137.0        // WGS84 semi-major axis in meters
+	wgs84E2 = 6.69437999014e-3 // WGS84 first eccentricity squared
+	wgs84B  = 6356752.314245   // WGS84 semi-minor axis in meters
+	wgs84Ep = 6.73949674228e-3 // WGS84 second eccentricity squared
+)
+
+var gpsEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
 // ReadPointsSbn reads all available SBN Points from the Reader.
-func ReadPointsSbn(r io.Reader) (Points, error) {
-	ps := []Point{}
-	res := Points{Name: "SBN track", Ps: ps}
+// Points are read from Geodetic Navigation Data (0x29) messages. If a file
+// doesn't contain those, Points from Measured Navigation Data (0x02) messages
+// are used instead. Options can set leap seconds subtracted from timestamps,
+// see WithSbnLeapSeconds.
+func ReadPointsSbn(r io.Reader, opts ...ReadOption) (Points, error) {
+	res := Points{Name: "SBN track", Ps: []Point{}}
+	err := readSbn(r, newReadConfig(opts).SbnLeapSeconds, func(p Point) error {
+		res.Ps = append(res.Ps, p)
+		return nil
+	})
+	return res, err
+}
 
-	p, err := readPointSbn(r)
-	for err == nil {
-		if err != nil {
-			res.Ps = ps
-			return res, err
-		}
+// ReadPointsSbnFunc reads SBN Points from the Reader like ReadPointsSbn,
+// calling fn for each point as soon as it is read instead of returning all
+// of them. Reading stops on the first error returned by fn, which is
+// returned. Points from Measured Navigation Data messages are passed to fn
+// only at the end of the file, when it is known there are no Geodetic
+// Navigation Data messages.
+func ReadPointsSbnFunc(r io.Reader, fn func(Point) error, opts ...ReadOption) error {
+	err := readSbn(r, newReadConfig(opts).SbnLeapSeconds, fn)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
 
+// readSbn reads SBN Points from the Reader, calling fn for each of them, and
+// returns io.EOF if the whole file was read. Timestamps of Geodetic
+// Navigation Data messages are moved back by leapSeconds.
+func readSbn(r io.Reader, leapSeconds int, fn func(Point) error) error {
+	psMeasured := []Point{}
+	pointsNo := 0
+
+	p, msgID, err := readPointSbn(r, leapSeconds)
+	for err == nil {
 		if p.isPoint {
-			p.globalIdx = len(ps)
-			ps = append(ps, p)
+			switch msgID {
+			case sbnMsgGeodeticNav:
+				p.globalIdx = pointsNo
+				pointsNo++
+				if err = fn(p); err != nil {
+					return err
+				}
+			case sbnMsgMeasuredNav:
+				if pointsNo == 0 {
+					p.globalIdx = len(psMeasured)
+					psMeasured = append(psMeasured, p)
+				}
+			}
 		}
 
-		p, err = readPointSbn(r)
+		p, msgID, err = readPointSbn(r, leapSeconds)
 	}
 
-	res.Ps = ps
-	return res, err
+	if pointsNo == 0 {
+		for i := 0; i < len(psMeasured); i++ {
+			if fnErr := fn(psMeasured[i]); fnErr != nil {
+				return fnErr
+			}
+		}
+	}
+	return err
 }
 
-// readPointSbn reads a next potential SBN Point from the Reader.
+// sbnReadErr returns ErrTruncated wrapping err if the Reader ended in the
+// middle of a message.
+func sbnReadErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errs.Errorf("%w (%v).", errs.ErrTruncated, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// readPointSbn reads a next potential SBN Point from the Reader and returns
+// it together with the message ID it was read from, subtracting leapSeconds
+// from Geodetic Navigation Data timestamps.
 // If no point is found, return Point with isPoint set to false.
-func readPointSbn(r io.Reader) (Point, error) {
+func readPointSbn(r io.Reader, leapSeconds int) (Point, byte, error) {
 	h := make([]byte, 4)
 	numBytes, err := io.ReadFull(r, h)
+	if err == io.EOF {
+		return Point{}, 0, err
+	}
 	if err != nil {
-		return Point{}, err
+		return Point{}, 0, sbnReadErr(err)
 	}
 	if numBytes != 4 {
-		return Point{}, errs.Errorf("Invalid number of header bytes read: %d.", numBytes)
+		return Point{}, 0, errs.Errorf("Invalid number of header bytes read: %d.", numBytes)
 	}
 
 	bodyLen := int(h[3])
 	body := make([]byte, h[3])
 	numBytes, err = io.ReadFull(r, body)
 	if err != nil {
-		return Point{}, err
+		return Point{}, 0, sbnReadErr(err)
 	}
 	if numBytes != bodyLen {
-		return Point{}, errs.Errorf("Invalid number of body bytes read: %d.", numBytes)
+		return Point{}, 0, errs.Errorf("Invalid number of body bytes read: %d.", numBytes)
 	}
 
 	checksum := make([]byte, 2)
 	numBytes, err = io.ReadFull(r, checksum)
 	if err != nil {
-		return Point{}, err
+		return Point{}, 0, sbnReadErr(err)
 	}
 	if numBytes != 2 {
-		return Point{}, errs.Errorf("Invalid number of checksum bytes read: %d.", numBytes)
+		return Point{}, 0, errs.Errorf("Invalid number of checksum bytes read: %d.", numBytes)
 	}
 	checksumInt := intFrom2ub(checksum)
 
 	endSequence := make([]byte, 2)
 	numBytes, err = io.ReadFull(r, endSequence)
 	if err != nil {
-		return Point{}, err
+		return Point{}, 0, sbnReadErr(err)
 	}
 	if numBytes != 2 {
-		return Point{}, errs.Errorf("Invalid number of end sequence bytes read: %d.", numBytes)
+		return Point{}, 0, errs.Errorf("Invalid number of end sequence bytes read: %d.", numBytes)
 	}
 	if bytes.Compare(endSequence, []byte("\xb0\xb3")) != 0 {
-		return Point{}, errs.Errorf("Invalid end sequence of bytes: %v.", endSequence)
+		return Point{}, 0, errs.Errorf("Invalid end sequence of bytes: %v.", endSequence)
 	}
 
 	csCalc := 0
@@ -82,13 +153,18 @@ func readPointSbn(r io.Reader) (Point, error) {
 		csCalc = csCalc & 0x7FFF
 	}
 
-	if body[0] != 0x29 {
-		return Point{}, nil
+	if bodyLen == 0 || (body[0] != sbnMsgGeodeticNav && body[0] != sbnMsgMeasuredNav) {
+		return Point{}, 0, nil
 	}
 
 	if checksumInt != csCalc {
-		return Point{}, errs.Errorf("Invalid checksum: %d (%04x), should be %d (%04x).",
-			checksumInt, checksum, csCalc, csCalc)
+		return Point{}, 0, errs.Errorf("%w: %d (%04x), should be %d (%04x).",
+			errs.ErrChecksum, checksumInt, checksum, csCalc, csCalc)
+	}
+
+	if body[0] == sbnMsgMeasuredNav {
+		p, err := readMeasuredNavSbn(body)
+		return p, sbnMsgMeasuredNav, err
 	}
 
 	navValid := body[1:3]
@@ -96,12 +172,89 @@ func readPointSbn(r io.Reader) (Point, error) {
 	ts := time.Date(
 		intFrom2ub(body[11:13]), time.Month(body[13]), int(body[14]),
 		int(body[15]), int(body[16]), msecs/1000,
-		msecs%1000*1000000, time.UTC)
+		msecs%1000*1000000, time.UTC).Add(-time.Duration(leapSeconds) * time.Second)
 	lat := float64(intFrom4sb(body[23:27])) / 10000000
 	lon := float64(intFrom4sb(body[27:31])) / 10000000
 	if navValid[0] != 0 || navValid[1] != 0 {
-		return Point{}, errs.Errorf("Nav Valid != 0: %x.", navValid)
+		return Point{}, 0, errs.Errorf("Nav Valid != 0: %x.", navValid)
+	}
+
+	return Point{isPoint: true, lat: lat, lon: lon, ts: ts}, sbnMsgGeodeticNav, nil
+}
+
+// readMeasuredNavSbn creates a Point from the body of Measured Navigation
+// Data Out (0x02) message:
+//   - X, Y, Z position (ECEF, 4 signed bytes each, m)
+//   - X, Y, Z velocity (ECEF, 2 signed bytes each, m/s * 8)
+//   - mode 1, HDOP, mode 2
+//   - GPS week (2 unsigned bytes) and GPS time of week (4 unsigned bytes, s * 100)
+//
+// If there is no navigation fix, return Point with isPoint set to false.
+func readMeasuredNavSbn(body []byte) (Point, error) {
+	if len(body) < 28 {
+		return Point{}, errs.Errorf("Invalid measured navigation data length: %d.", len(body))
 	}
 
-	return Point{isPoint: true, lat: lat, lon: lon, ts: ts}, nil
+	mode1 := body[19]
+	if mode1&0x07 == 0 {
+		return Point{}, nil
+	}
+
+	x := float64(int32From4b(body[1:5]))
+	y := float64(int32From4b(body[5:9]))
+	z := float64(int32From4b(body[9:13]))
+	vx := float64(int16From2b(body[13:15])) / 8
+	vy := float64(int16From2b(body[15:17])) / 8
+	vz := float64(int16From2b(body[17:19])) / 8
+
+	week := intFrom2ub(body[22:24])
+	tow := int(body[24])<<24 | int(body[25])<<16 | int(body[26])<<8 | int(body[27])
+	ts := gpsEpoch.
+		AddDate(0, 0, week*7).
+		Add(time.Duration(tow) * 10 * time.Millisecond).
+		Add(-gpsLeapSeconds * time.Second)
+
+	lat, lon := ecefToLatLon(x, y, z)
+	speed := math.Sqrt(vx*vx + vy*vy + vz*vz)
+	course := ecefCourse(lat, lon, vx, vy, vz)
+
+	return Point{isPoint: true, lat: lat, lon: lon, ts: ts, speed: &speed, course: &course}, nil
+}
+
+// ecefCourse calculates the course over ground (0° is north, 90° is east)
+// from the ECEF velocity at the position.
+func ecefCourse(lat, lon, vx, vy, vz float64) float64 {
+	latR := lat * math.Pi / 180
+	lonR := lon * math.Pi / 180
+	east := -math.Sin(lonR)*vx + math.Cos(lonR)*vy
+	north := -math.Sin(latR)*math.Cos(lonR)*vx - math.Sin(latR)*math.Sin(lonR)*vy + math.Cos(latR)*vz
+	c := math.Atan2(east, north) * 180 / math.Pi
+	if c < 0 {
+		c += 360
+	}
+	return c
+}
+
+// int32From4b converts 4 bytes (big-endian two's complement) to int.
+func int32From4b(b4 []byte) int {
+	return int(int32(uint32(b4[0])<<24 | uint32(b4[1])<<16 | uint32(b4[2])<<8 | uint32(b4[3])))
+}
+
+// int16From2b converts 2 bytes (big-endian two's complement) to int.
+func int16From2b(b2 []byte) int {
+	return int(int16(uint16(b2[0])<<8 | uint16(b2[1])))
+}
+
+// ecefToLatLon converts WGS84 ECEF coordinates (m) to latitude and longitude
+// (degrees) using Bowring's method.
+func ecefToLatLon(x, y, z float64) (float64, float64) {
+	p := math.Sqrt(x*x + y*y)
+	theta := math.Atan2(z*wgs84A, p*wgs84B)
+	sinTheta, cosTheta := math.Sin(theta), math.Cos(theta)
+	lat := math.Atan2(
+		z+wgs84Ep*wgs84B*sinTheta*sinTheta*sinTheta,
+		p-wgs84E2*wgs84A*cosTheta*cosTheta*cosTheta)
+	lon := math.Atan2(y, x)
+
+	return lat * 180 / math.Pi, lon * 180 / math.Pi
 }
@@ -0,0 +1,301 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vvidovic/gps-stats/internal/errs"
+)
+
+// sbnMessage returns the SBN message with the body, its header, checksum and
+// end sequence.
+func sbnMessage(body []byte) []byte {
+	checksum := 0
+	for i := 0; i < len(body); i++ {
+		checksum = (checksum + int(body[i])) & 0x7FFF
+	}
+
+	msg := []byte{0xa0, 0xa2, 0, byte(len(body))}
+	msg = append(msg, body...)
+	msg = append(msg, byte(checksum>>8), byte(checksum))
+	return append(msg, 0xb0, 0xb3)
+}
+
+// sbnGeodeticBody returns the body of a Geodetic Navigation Data (0x29)
+// message with a valid fix at the position (positive coordinates only) and
+// time. The body is 34 bytes long, like the first message detected in SBN
+// tracks, fields after the longitude are not used by the reader.
+func sbnGeodeticBody(lat, lon float64, ts time.Time) []byte {
+	body := make([]byte, 34)
+	body[0] = sbnMsgGeodeticNav
+	body[11], body[12] = byte(ts.Year()>>8), byte(ts.Year())
+	body[13] = byte(ts.Month())
+	body[14] = byte(ts.Day())
+	body[15] = byte(ts.Hour())
+	body[16] = byte(ts.Minute())
+	msecs := ts.Second()*1000 + ts.Nanosecond()/1000000
+	body[17], body[18] = byte(msecs>>8), byte(msecs)
+	putSbnCoordinate(body[23:27], lat)
+	putSbnCoordinate(body[27:31], lon)
+	return body
+}
+
+// sbnMeasuredBody returns the body of a Measured Navigation Data Out (0x02)
+// message with the ECEF position (m), velocity (m/s * 8), mode 1 and GPS
+// week and time of week (s * 100). The body is 41 bytes long, fields after
+// the time of week are not used by the reader.
+func sbnMeasuredBody(x, y, z, vx, vy, vz int, mode1 byte, week, tow int) []byte {
+	body := make([]byte, 41)
+	body[0] = sbnMsgMeasuredNav
+	put4 := func(b4 []byte, v int) {
+		b4[0], b4[1], b4[2], b4[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+	}
+	put4(body[1:5], x)
+	put4(body[5:9], y)
+	put4(body[9:13], z)
+	body[13], body[14] = byte(vx>>8), byte(vx)
+	body[15], body[16] = byte(vy>>8), byte(vy)
+	body[17], body[18] = byte(vz>>8), byte(vz)
+	body[19] = mode1
+	body[22], body[23] = byte(week>>8), byte(week)
+	put4(body[24:28], tow)
+	return body
+}
+
+// putSbnCoordinate writes the coordinate in 1e-7 degrees to 4 bytes.
+func putSbnCoordinate(b4 []byte, deg float64) {
+	v := int(deg*10000000 + 0.5)
+	b4[0], b4[1], b4[2], b4[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+}
+
+// sbnTrack returns the SBN track of the points, each one written as
+// a Geodetic Navigation Data message.
+func sbnTrack(ps []Point) []byte {
+	res := []byte{}
+	for i := 0; i < len(ps); i++ {
+		res = append(res, sbnMessage(sbnGeodeticBody(ps[i].lat, ps[i].lon, ps[i].ts))...)
+	}
+	return res
+}
+
+func TestReadPointsSbn(t *testing.T) {
+	ps := straightTrack(10, 9*time.Second, time.Second)
+
+	points, err := ReadPoints(bytes.NewReader(sbnTrack(ps)))
+	// SBN tracks read to the end return io.EOF.
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(points.Ps) != len(ps) {
+		t.Fatalf("len(Ps) = %d, want %d", len(points.Ps), len(ps))
+	}
+	for i := 0; i < len(ps); i++ {
+		p := points.Ps[i]
+		if !p.ts.Equal(ps[i].ts) || !almostEqual(p.lat, ps[i].lat, 1e-6) || !almostEqual(p.lon, ps[i].lon, 1e-6) {
+			t.Errorf("point %d = %v %.7f %.7f, want %v %.7f %.7f",
+				i, p.ts, p.lat, p.lon, ps[i].ts, ps[i].lat, ps[i].lon)
+		}
+	}
+}
+
+func TestReadPointsSbnTruncated(t *testing.T) {
+	ps := straightTrack(10, 9*time.Second, time.Second)
+	track := sbnTrack(ps)
+	// The last message ends in the middle of the body.
+	track = track[:len(track)-20]
+
+	points, err := ReadPoints(bytes.NewReader(track))
+	if !errors.Is(err, errs.ErrTruncated) {
+		t.Errorf("ReadPoints() error = %v, want %v", err, errs.ErrTruncated)
+	}
+	if len(points.Ps) != len(ps)-1 {
+		t.Errorf("len(Ps) = %d, want %d points read before the end", len(points.Ps), len(ps)-1)
+	}
+}
+
+func TestReadPointsSbnChecksum(t *testing.T) {
+	ps := straightTrack(10, 9*time.Second, time.Second)
+	track := sbnTrack(ps)
+	msgLen := len(track) / len(ps)
+	// Corrupt the latitude of the third point.
+	track[2*msgLen+4+25]++
+
+	points, err := ReadPoints(bytes.NewReader(track))
+	if !errors.Is(err, errs.ErrChecksum) {
+		t.Errorf("ReadPoints() error = %v, want %v", err, errs.ErrChecksum)
+	}
+	if len(points.Ps) != 2 {
+		t.Errorf("len(Ps) = %d, want 2 points read before the corrupted message", len(points.Ps))
+	}
+}
+
+func TestReadPointsSbnLeapSeconds(t *testing.T) {
+	ps := straightTrack(10, 4*time.Second, time.Second)
+	track := sbnTrack(ps)
+
+	tests := []struct {
+		name string
+		read func(opts ...ReadOption) ([]Point, error)
+	}{
+		{"ReadPointsSbn", func(opts ...ReadOption) ([]Point, error) {
+			points, err := ReadPointsSbn(bytes.NewReader(track), opts...)
+			return points.Ps, err
+		}},
+		{"ReadPoints", func(opts ...ReadOption) ([]Point, error) {
+			points, err := ReadPoints(bytes.NewReader(track), opts...)
+			return points.Ps, err
+		}},
+		{"ReadPointsFunc", func(opts ...ReadOption) ([]Point, error) {
+			res := []Point{}
+			err := ReadPointsFunc(bytes.NewReader(track), func(p Point) error {
+				res = append(res, p)
+				return nil
+			}, opts...)
+			return res, err
+		}},
+	}
+	leaps := []int{0, 18, -2}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			for j := 0; j < len(leaps); j++ {
+				read, err := tt.read(WithSbnLeapSeconds(leaps[j]))
+				if err != nil && err != io.EOF {
+					t.Fatal(err)
+				}
+				if len(read) != len(ps) {
+					t.Fatalf("read %d points, want %d", len(read), len(ps))
+				}
+				for k := 0; k < len(ps); k++ {
+					want := ps[k].ts.Add(-time.Duration(leaps[j]) * time.Second)
+					if !read[k].ts.Equal(want) {
+						t.Errorf("%d leap seconds: point %d time %v, want %v", leaps[j], k, read[k].ts, want)
+					}
+				}
+			}
+		})
+	}
+
+	// Without options timestamps are not changed.
+	points, _ := ReadPoints(bytes.NewReader(track))
+	if !points.Ps[0].ts.Equal(ps[0].ts) {
+		t.Errorf("point 0 time %v, want %v", points.Ps[0].ts, ps[0].ts)
+	}
+}
+
+func TestReadMeasuredNavSbn(t *testing.T) {
+	// Week 2232, 50400.25 s into the week is 18 leap seconds later than UTC.
+	wantTs := time.Date(2022, 10, 16, 13, 59, 42, 250000000, time.UTC)
+
+	tests := []struct {
+		name       string
+		body       []byte
+		lat, lon   float64
+		speed      float64
+		course     float64
+		isPoint    bool
+		wantErrMsg string
+	}{
+		// ECEF position at the sea level and the velocity of 6 m/s east and
+		// 8 m/s north.
+		{"north-east", sbnMeasuredBody(4383399, 1092904, 4487348, -56, 36, 45, 4, 2232, 5040025),
+			45, 14, 10.0444, 36.87, true, ""},
+		// Negative ECEF coordinates and the velocity of 5 m/s west and
+		// 5 m/s south.
+		{"south-west", sbnMeasuredBody(1760272, -4998564, -3537245, -45, 8, -33, 4, 2232, 5040025),
+			-33.9, -70.6, 7.0467, 225, true, ""},
+		{"no fix", sbnMeasuredBody(4383399, 1092904, 4487348, -56, 36, 45, 0x08, 2232, 5040025),
+			0, 0, 0, 0, false, ""},
+		{"short body", sbnMeasuredBody(4383399, 1092904, 4487348, -56, 36, 45, 4, 2232, 5040025)[:27],
+			0, 0, 0, 0, false, "Invalid measured navigation data length: 27."},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := readMeasuredNavSbn(tt.body)
+			if tt.wantErrMsg != "" {
+				if err == nil || err.Error() != tt.wantErrMsg {
+					t.Errorf("readMeasuredNavSbn() error = %v, want %q", err, tt.wantErrMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.isPoint != tt.isPoint {
+				t.Fatalf("isPoint = %v, want %v", p.isPoint, tt.isPoint)
+			}
+			if !tt.isPoint {
+				return
+			}
+			// Positions are encoded in whole meters.
+			if !almostEqual(p.lat, tt.lat, 1e-5) || !almostEqual(p.lon, tt.lon, 1e-5) {
+				t.Errorf("position = %.7f %.7f, want %.7f %.7f", p.lat, p.lon, tt.lat, tt.lon)
+			}
+			if !p.ts.Equal(wantTs) {
+				t.Errorf("time = %v, want %v", p.ts, wantTs)
+			}
+			if p.speed == nil || !almostEqual(*p.speed, tt.speed, 1e-3) {
+				t.Errorf("speed = %v, want %v", p.speed, tt.speed)
+			}
+			// Velocities are encoded in 1/8 m/s.
+			if p.course == nil || !almostEqual(*p.course, tt.course, 1) {
+				t.Errorf("course = %v, want %v", p.course, tt.course)
+			}
+		})
+	}
+}
+
+func TestReadPointsSbnMeasuredNav(t *testing.T) {
+	ps := straightTrack(10, 4*time.Second, time.Second)
+	measured := sbnMessage(sbnMeasuredBody(4383399, 1092904, 4487348, -56, 36, 45, 4, 2232, 5040025))
+	noFix := sbnMessage(sbnMeasuredBody(4383399, 1092904, 4487348, -56, 36, 45, 0, 2232, 5040100))
+
+	// Only Measured Navigation Data messages, the one without a fix is
+	// skipped.
+	track := append(append(append([]byte{}, measured...), noFix...), measured...)
+	points, err := ReadPointsSbn(bytes.NewReader(track))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(points.Ps) != 2 {
+		t.Fatalf("len(Ps) = %d, want 2 points with a fix", len(points.Ps))
+	}
+	for i := 0; i < len(points.Ps); i++ {
+		if points.Ps[i].globalIdx != i || !almostEqual(points.Ps[i].lat, 45, 1e-5) {
+			t.Errorf("point %d = %d %.7f, want %d 45", i, points.Ps[i].globalIdx, points.Ps[i].lat, i)
+		}
+	}
+
+	// Mixed messages, only Geodetic Navigation Data points are returned.
+	track = []byte{}
+	for i := 0; i < len(ps); i++ {
+		track = append(track, sbnMessage(sbnGeodeticBody(ps[i].lat, ps[i].lon, ps[i].ts))...)
+		track = append(track, measured...)
+	}
+	points, err = ReadPoints(bytes.NewReader(track))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(points.Ps) != len(ps) {
+		t.Fatalf("len(Ps) = %d, want %d", len(points.Ps), len(ps))
+	}
+	for i := 0; i < len(ps); i++ {
+		if !points.Ps[i].ts.Equal(ps[i].ts) || !almostEqual(points.Ps[i].lat, ps[i].lat, 1e-6) {
+			t.Errorf("point %d = %v %.7f, want %v %.7f", i, points.Ps[i].ts, points.Ps[i].lat, ps[i].ts, ps[i].lat)
+		}
+	}
+
+	// Measured Navigation Data message too short for the time of week.
+	track = append(sbnTrack(ps[:2]), sbnMessage(sbnMeasuredBody(0, 0, 0, 0, 0, 0, 4, 0, 0)[:20])...)
+	points, err = ReadPoints(bytes.NewReader(track))
+	if err == nil || err == io.EOF {
+		t.Errorf("ReadPoints() error = %v, want the message length error", err)
+	}
+	if len(points.Ps) != 2 {
+		t.Errorf("len(Ps) = %d, want 2 points read before the short message", len(points.Ps))
+	}
+}
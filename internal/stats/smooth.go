@@ -0,0 +1,137 @@
+package stats
+
+import "math"
+
+const (
+	// smoothPositionNoise is the expected GPS position error (m) of a phone.
+	smoothPositionNoise = 3.0
+	// smoothAccelNoise is the expected acceleration (m/s²) while sailing,
+	// higher values follow genuine accelerations better but smooth less.
+	smoothAccelNoise = 2.0
+	// smoothMaxGap is the max interval between points (s) smoothed together,
+	// parts of the track between longer gaps are smoothed separately.
+	smoothMaxGap = 2.0
+)
+
+// mat2 is a 2x2 matrix.
+type mat2 [2][2]float64
+
+// mul multiplies 2 matrices.
+func (a mat2) mul(b mat2) mat2 {
+	return mat2{
+		{a[0][0]*b[0][0] + a[0][1]*b[1][0], a[0][0]*b[0][1] + a[0][1]*b[1][1]},
+		{a[1][0]*b[0][0] + a[1][1]*b[1][0], a[1][0]*b[0][1] + a[1][1]*b[1][1]}}
+}
+
+// transpose returns a transposed matrix.
+func (a mat2) transpose() mat2 {
+	return mat2{{a[0][0], a[1][0]}, {a[0][1], a[1][1]}}
+}
+
+// inverse returns an inverse matrix.
+func (a mat2) inverse() mat2 {
+	det := a[0][0]*a[1][1] - a[0][1]*a[1][0]
+	return mat2{{a[1][1] / det, -a[0][1] / det}, {-a[1][0] / det, a[0][0] / det}}
+}
+
+// kalmanState is a position & velocity with a covariance for a single axis.
+type kalmanState struct {
+	x [2]float64
+	p mat2
+}
+
+// smoothAxis smooths positions z measured at times t (s) using a
+// constant-velocity Kalman filter followed by a Rauch-Tung-Striebel
+// smoother, so positions are not delayed after accelerations and turns.
+func smoothAxis(z, t []float64) []float64 {
+	n := len(z)
+	r := sq(smoothPositionNoise)
+	q := sq(smoothAccelNoise)
+
+	filtered := make([]kalmanState, n)
+	predicted := make([]kalmanState, n)
+	fs := make([]mat2, n)
+
+	filtered[0] = kalmanState{x: [2]float64{z[0], 0}, p: mat2{{r, 0}, {0, q}}}
+	for i := 1; i < n; i++ {
+		dt := t[i] - t[i-1]
+		f := mat2{{1, dt}, {0, 1}}
+		prev := filtered[i-1]
+		pred := kalmanState{x: [2]float64{prev.x[0] + dt*prev.x[1], prev.x[1]}}
+		pred.p = f.mul(prev.p).mul(f.transpose())
+		pred.p[0][0] += q * math.Pow(dt, 4) / 4
+		pred.p[0][1] += q * math.Pow(dt, 3) / 2
+		pred.p[1][0] += q * math.Pow(dt, 3) / 2
+		pred.p[1][1] += q * dt * dt
+
+		y := z[i] - pred.x[0]
+		s := pred.p[0][0] + r
+		k0 := pred.p[0][0] / s
+		k1 := pred.p[1][0] / s
+		curr := kalmanState{x: [2]float64{pred.x[0] + k0*y, pred.x[1] + k1*y}}
+		curr.p = mat2{
+			{(1 - k0) * pred.p[0][0], (1 - k0) * pred.p[0][1]},
+			{pred.p[1][0] - k1*pred.p[0][0], pred.p[1][1] - k1*pred.p[0][1]}}
+
+		fs[i] = f
+		predicted[i] = pred
+		filtered[i] = curr
+	}
+
+	res := make([]float64, n)
+	smoothed := filtered[n-1]
+	res[n-1] = smoothed.x[0]
+	for i := n - 2; i >= 0; i-- {
+		c := filtered[i].p.mul(fs[i+1].transpose()).mul(predicted[i+1].p.inverse())
+		d0 := smoothed.x[0] - predicted[i+1].x[0]
+		d1 := smoothed.x[1] - predicted[i+1].x[1]
+		smoothed = kalmanState{x: [2]float64{
+			filtered[i].x[0] + c[0][0]*d0 + c[0][1]*d1,
+			filtered[i].x[1] + c[1][0]*d0 + c[1][1]*d1}}
+		res[i] = smoothed.x[0]
+	}
+	return res
+}
+
+// Smooth reduces the position noise using a constant-velocity Kalman
+// smoother on local coordinates (meters from the first point). Points
+// should be cleaned up first, timestamps must be increasing. Parts of the
+// track between gaps longer than smoothMaxGap or position jumps are smoothed
+// separately.
+// It returns new points, timestamps and all other values are unchanged.
+func Smooth(ps []Point) []Point {
+	res := make([]Point, len(ps))
+	copy(res, ps)
+	if len(ps) < 2 {
+		return res
+	}
+
+	lat0 := ps[0].lat
+	lon0 := ps[0].lon
+	mPerLat := earthCircPoles / 360.0
+	mPerLon := earthCircEquator / 360.0 * math.Cos(lat0*math.Pi/180)
+
+	xs := make([]float64, len(ps))
+	ys := make([]float64, len(ps))
+	ts := make([]float64, len(ps))
+	for i := 0; i < len(ps); i++ {
+		xs[i] = (ps[i].lon - lon0) * mPerLon
+		ys[i] = (ps[i].lat - lat0) * mPerLat
+		ts[i] = ps[i].ts.Sub(ps[0].ts).Seconds()
+	}
+
+	start := 0
+	for i := 1; i <= len(ps); i++ {
+		if i < len(ps) && ts[i]-ts[i-1] <= smoothMaxGap && !ps[i].jump {
+			continue
+		}
+		xsSmooth := smoothAxis(xs[start:i], ts[start:i])
+		ysSmooth := smoothAxis(ys[start:i], ts[start:i])
+		for j := start; j < i; j++ {
+			res[j].lon = lon0 + xsSmooth[j-start]/mPerLon
+			res[j].lat = lat0 + ysSmooth[j-start]/mPerLat
+		}
+		start = i
+	}
+	return res
+}
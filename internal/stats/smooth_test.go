@@ -0,0 +1,123 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// addNoise moves points randomly (normal distribution, m) like phone
+// position errors, the same seed always gives the same noise.
+func addNoise(ps []Point, sigma float64, seed int64) []Point {
+	r := rand.New(rand.NewSource(seed))
+	mPerLat := earthCircPoles / 360.0
+	res := make([]Point, len(ps))
+	copy(res, ps)
+	for i := 0; i < len(res); i++ {
+		mPerLon := earthCircEquator / 360.0 * math.Cos(res[i].lat*math.Pi/180)
+		res[i].lat += r.NormFloat64() * sigma / mPerLat
+		res[i].lon += r.NormFloat64() * sigma / mPerLon
+	}
+	return res
+}
+
+func TestSmoothNoise(t *testing.T) {
+	ps := addNoise(straightTrack(10, 10*time.Minute, time.Second), smoothPositionNoise, 1)
+
+	raw := CalculateStats(ps, StatAll, UnitsMs)
+	smoothed := CalculateStats(Smooth(ps), StatAll, UnitsMs)
+
+	// Noise inflates the max speeds, smoothing should remove most of it.
+	if raw.Speed2s().Speed() < 11 {
+		t.Fatalf("raw 2s speed %.3f m/s not inflated by noise", raw.Speed2s().Speed())
+	}
+	rawExcess := raw.Speed2s().Speed() - 10
+	if got := smoothed.Speed2s().Speed() - 10; got < 0 || got > rawExcess/3 {
+		t.Errorf("smoothed 2s speed %.3f m/s over 10 m/s, want below a third of raw %.3f m/s",
+			got, rawExcess)
+	}
+	rawExcess = raw.Speed100m().Speed() - 10
+	if got := smoothed.Speed100m().Speed() - 10; got < 0 || got > rawExcess/3 {
+		t.Errorf("smoothed 100m speed %.3f m/s over 10 m/s, want below a third of raw %.3f m/s",
+			got, rawExcess)
+	}
+	if !almostEqual(smoothed.Distance(), 6000, 60) {
+		t.Errorf("smoothed distance %.3f m, want 6000 ± 60 m", smoothed.Distance())
+	}
+}
+
+func TestSmoothAcceleration(t *testing.T) {
+	// Bear away to a run: 6 m/s, 5 s at 15 m/s and back to 6 m/s.
+	spec := trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second, legs: []leg{
+		{0, 6, time.Minute}, {0, 15, 5 * time.Second}, {0, 6, time.Minute}}}
+	ps := generateTrack(spec)
+
+	raw := CalculateStats(ps, StatAll, UnitsMs)
+	smoothed := CalculateStats(Smooth(ps), StatAll, UnitsMs)
+	// Genuine accelerations are smeared only slightly.
+	if got, want := smoothed.Speed2s().Speed(), raw.Speed2s().Speed(); got < want*0.9 {
+		t.Errorf("smoothed 2s speed %.3f m/s, want at least 90%% of %.3f m/s", got, want)
+	}
+}
+
+func TestSmoothPoints(t *testing.T) {
+	ps := straightTrack(10, time.Minute, time.Second)
+	// A gap longer than smoothMaxGap splits the smoothing.
+	ps = append(ps[:30:30], ps[35:]...)
+	noisy := addNoise(ps, smoothPositionNoise, 2)
+	orig := make([]Point, len(noisy))
+	copy(orig, noisy)
+
+	res := Smooth(noisy)
+	if len(res) != len(noisy) {
+		t.Fatalf("got %d points, want %d", len(res), len(noisy))
+	}
+	for i := 0; i < len(res); i++ {
+		if noisy[i].lat != orig[i].lat || noisy[i].lon != orig[i].lon {
+			t.Fatalf("point %d of the input changed", i)
+		}
+		if !res[i].ts.Equal(noisy[i].ts) || res[i].globalIdx != noisy[i].globalIdx {
+			t.Errorf("point %d: time or index changed", i)
+		}
+		if d := distance(res[i], ps[i]); d > 3*smoothPositionNoise {
+			t.Errorf("point %d smoothed %.1f m from the true position", i, d)
+		}
+	}
+
+	if got := Smooth(nil); len(got) != 0 {
+		t.Errorf("Smooth(nil) returned %d points", len(got))
+	}
+	single := Smooth(ps[:1])
+	if len(single) != 1 || single[0].lat != ps[0].lat {
+		t.Errorf("a single point changed: %v", single)
+	}
+}
+
+func BenchmarkSmooth(b *testing.B) {
+	ps := addNoise(straightTrack(10, time.Hour, time.Second), smoothPositionNoise, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Smooth(ps)
+	}
+}
+
+func TestSmoothJump(t *testing.T) {
+	ps := jumpedTrack()
+
+	res := Smooth(ps)
+	for i := 0; i < len(res); i++ {
+		if d := distance(res[i], ps[i]); d > 3*smoothPositionNoise {
+			t.Errorf("point %d smoothed %.1f m from the true position", i, d)
+		}
+	}
+	s := CalculateStats(res, Stat2s|Stat5x10s, UnitsMs)
+	// Smoothing starts again after the jump, from an unknown velocity.
+	if !almostEqual(s.Speed2s().Speed(), 10, 1) || !almostEqual(s.Calc5x10sAvg(), 10, 0.2) {
+		t.Errorf("2s %.2f m/s, 5x10 %.2f m/s, want 10 m/s", s.Speed2s().Speed(), s.Calc5x10sAvg())
+	}
+	if !almostEqual(s.Distance(), 1980, 10) {
+		t.Errorf("distance %.0f m, want 1980 m without the jump", s.Distance())
+	}
+}
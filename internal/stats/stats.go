@@ -1,11 +1,13 @@
 package stats
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/vvidovic/gps-stats/internal/errs"
@@ -20,16 +22,20 @@ const (
 	mPerSecToKmh     = 3.6      // Number of km/h in 1 m/s
 	earthCircPoles   = 40007863 // Earth Circumference around poles
 	earthCircEquator = 40075017 // Earth Circumference around equator
+
+	samplingMaxGapWarning = 5     // Max interval between points (s) before warning
+	movingMinSpeed        = 1.029 // Min speed (m/s, 2 kts) counted as moving time
+	defaultPrecision      = 3     // Default number of decimal places for speeds and distances
 )
 
 // StatFlag shows which statistics are we calculating/printing.
+// Multiple statistics can be combined as a bit set.
 type StatFlag int64
 
 // StatFlag shows which statistics are we calculating/printing.
 const (
-	StatNone StatFlag = iota
-	StatAll
-	Stat2s
+	StatNone StatFlag = 0
+	Stat2s   StatFlag = 1 << (iota - 1)
 	Stat10sAvg
 	Stat10s1
 	Stat10s2
@@ -41,8 +47,57 @@ const (
 	Stat100m
 	Stat1nm
 	StatAlpha
+	StatRose
+	StatSummary
+	StatBest
+	StatDuration
+	StatDistance
+)
+
+// StatFlag combinations.
+const (
+	Stat5x10s = Stat10sAvg | Stat10s1 | Stat10s2 | Stat10s3 | Stat10s4 | Stat10s5
+	StatAll   = Stat2s | Stat5x10s | Stat15m | Stat1h | Stat100m | Stat1nm | StatAlpha
 )
 
+// statFlagNames contains names of single statistics, same as the names used
+// for the "-t" flag.
+var statFlagNames = []struct {
+	flag StatFlag
+	name string
+}{
+	{Stat2s, "2s"}, {Stat10sAvg, "10sAvg"},
+	{Stat10s1, "10s1"}, {Stat10s2, "10s2"}, {Stat10s3, "10s3"},
+	{Stat10s4, "10s4"}, {Stat10s5, "10s5"},
+	{Stat15m, "15m"}, {Stat1h, "1h"}, {Stat100m, "100m"}, {Stat1nm, "1nm"},
+	{StatAlpha, "alpha"}, {StatRose, "rose"}, {StatSummary, "summary"},
+	{StatBest, "best"}, {StatDuration, "dur"}, {StatDistance, "dist"},
+}
+
+// Flags returns all single statistics contained in the StatFlag.
+func (f StatFlag) Flags() []StatFlag {
+	res := []StatFlag{}
+	for i := 0; i < len(statFlagNames); i++ {
+		if f&statFlagNames[i].flag != 0 {
+			res = append(res, statFlagNames[i].flag)
+		}
+	}
+	return res
+}
+
+func (f StatFlag) String() string {
+	if f == StatAll {
+		return "all"
+	}
+	names := []string{}
+	for i := 0; i < len(statFlagNames); i++ {
+		if f&statFlagNames[i].flag != 0 {
+			names = append(names, statFlagNames[i].name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
 // UnitsFlag shows which speed units are we printing.
 type UnitsFlag int64
 
@@ -68,8 +123,14 @@ func (u UnitsFlag) String() string {
 }
 
 // TrackType defines type of track file.
+//
+// Deprecated: track formats are detected by registered Formats, TrackType is
+// not used anymore. See RegisterFormat.
 type TrackType int64
 
+// Track types.
+//
+// Deprecated: see TrackType.
 const (
 	TrackSbn TrackType = iota
 	TrackGpx
@@ -82,6 +143,9 @@ type Points struct {
 	Name    string
 	Type    string
 	Ps      []Point
+	// TimesFilled is the number of points without a timestamp in the file,
+	// with the timestamp interpolated while reading.
+	TimesFilled int
 }
 
 // Point represent one GPS point with timestamp.
@@ -93,16 +157,85 @@ type Point struct {
 	lat        float64
 	lon        float64
 	ts         time.Time
-	usedFor10s bool
-	globalIdx  int
+	globalIdx  int // Index of the point in the track file.
+	idx        int // Index used for bookkeeping by cleanup and statistics.
+	segment    int
 	speed      *float64 // MetersPerSecond_t: This type contains a speed measured in meters per second.
 	hr         *int16   // BeatsPerMinute_t: This type contains a heart rate measured in beats per minute.
+	hdop       *float64 // Horizontal dilution of precision.
+	sat        *int     // Number of satellites used to calculate the position.
+	course     *float64 // Course over ground reported by the device (0° is north, 90° is east).
+
+	interpolated bool // Point is not measured but interpolated between neighbors.
+	removedBy    int  // Number of the cleanup filter (from 1) which marked the point invalid.
+	jump         bool // Point is after a position jump, not connected to the previous point.
+
+	// nextDist is the distance to the next point (by idx) in meters, cached
+	// while calculating statistics if nextDistOk.
+	nextDist   float64
+	nextDistOk bool
+}
+
+// isValid returns true if the Point is not checked or it is checked and
+// marked as valid by cleanup.
+func (p Point) isValid() bool {
+	return !p.validCheck || p.valid
+}
+
+// ValidPoints returns a copy of points without points marked invalid by
+// cleanup.
+func ValidPoints(ps []Point) []Point {
+	res := []Point{}
+	for i := 0; i < len(ps); i++ {
+		if ps[i].isValid() {
+			res = append(res, ps[i])
+		}
+	}
+	return res
+}
+
+// Time returns the Point timestamp.
+func (p Point) Time() time.Time {
+	return p.ts
+}
+
+// Lat returns the Point latitude.
+func (p Point) Lat() float64 {
+	return p.lat
+}
+
+// Lon returns the Point longitude.
+func (p Point) Lon() float64 {
+	return p.lon
 }
 
 func (p Point) String() string {
 	return fmt.Sprintf("{%v/%v (%v)}", p.lat, p.lon, p.ts)
 }
 
+// SpeedSample is a speed calculated between two consecutive points,
+// timestamped with the time of the later point.
+type SpeedSample struct {
+	T     time.Time
+	Speed float64
+}
+
+// SpeedSeries returns speeds between all consecutive points in given units.
+// Pairs of points with the same timestamp are skipped.
+func (p Points) SpeedSeries(speedUnits UnitsFlag) []SpeedSample {
+	res := []SpeedSample{}
+	for i := 1; i < len(p.Ps); i++ {
+		if !p.Ps[i].ts.After(p.Ps[i-1].ts) {
+			continue
+		}
+		res = append(res, SpeedSample{
+			T:     p.Ps[i].ts,
+			Speed: speed(p.Ps[i-1], p.Ps[i], speedUnits)})
+	}
+
+	return res
+}
+
 // Track is a collection of points and can contain sum of durations,
 //
 //	sum of calculated distances and calculated speed.
@@ -117,17 +250,131 @@ type Track struct {
 	speed      float64
 	speedUnits UnitsFlag
 	valid      bool
+	// window contains points indexed by idx the Track points are a window
+	// over, nil if the Track owns its points.
+	window []Point
+}
+
+// Valid returns true if the Track is a found statistic.
+func (t Track) Valid() bool {
+	return t.valid
+}
+
+// Speed returns the Track average speed in SpeedUnits.
+func (t Track) Speed() float64 {
+	return t.speed
+}
+
+// SpeedUnits returns units of the Track speed.
+func (t Track) SpeedUnits() UnitsFlag {
+	return t.speedUnits
+}
+
+// Distance returns the Track distance in meters.
+func (t Track) Distance() float64 {
+	return t.distance
+}
+
+// Duration returns the Track duration.
+func (t Track) Duration() time.Duration {
+	return time.Duration(t.duration * float64(time.Second))
+}
+
+// Start returns the timestamp of the first Track point, zero time for an
+// empty Track.
+func (t Track) Start() time.Time {
+	if len(t.ps) == 0 {
+		return time.Time{}
+	}
+	return t.ps[0].ts
+}
+
+// End returns the timestamp of the last Track point, zero time for an
+// empty Track.
+func (t Track) End() time.Time {
+	if len(t.ps) == 0 {
+		return time.Time{}
+	}
+	return t.ps[len(t.ps)-1].ts
+}
+
+// Points returns a copy of the Track points.
+func (t Track) Points() []Point {
+	res := make([]Point, len(t.ps))
+	copy(res, t.ps)
+	return res
 }
 
 // TxtLine display human-readable entry for each track.
 func (t Track) TxtLine() string {
-	var timestamp time.Time
-	if len(t.ps) > 0 {
-		timestamp = t.ps[0].ts
+	return t.TxtLinePrecision(defaultPrecision)
+}
+
+// TxtLinePrecision display human-readable entry for each track with speed
+// and distance rounded to given number of decimal places.
+func (t Track) TxtLinePrecision(precision int) string {
+	if !t.valid {
+		return notAvailable
+	}
+	return fmt.Sprintf("%s %s (%0.0f sec, %s m, %v)",
+		formatNumber(t.Speed(), precision), t.SpeedUnits(), t.Duration().Seconds(),
+		formatNumber(t.Distance(), precision), t.Start())
+}
+
+// TxtPoints formats all points of the track, a line per point with its
+// index in the track file ("-" for interpolated points), time, position and
+// heading & speed from the previous point, to check how the track was found.
+func (t Track) TxtPoints(precision int) string {
+	res := fmt.Sprintf("%6s  %-12s  %11s  %11s  %7s  %s\n", "index", "time", "lat", "lon", "heading", "speed")
+	for i := 0; i < len(t.ps); i++ {
+		p := t.ps[i]
+		idx := "-"
+		if !p.interpolated {
+			idx = strconv.Itoa(p.globalIdx)
+		}
+		headingTxt, speedTxt := "-", "-"
+		if i > 0 {
+			headingTxt = fmt.Sprintf("%.1f", pointsHeading(t.ps[i-1], p))
+			speedTxt = formatNumber(speed(t.ps[i-1], p, t.speedUnits), precision) + " " + t.speedUnits.String()
+		}
+		res += fmt.Sprintf("%6s  %s  %11.7f  %11.7f  %7s  %s\n",
+			idx, p.ts.Format("15:04:05.000"), p.lat, p.lon, headingTxt, speedTxt)
+	}
+	return res
+}
+
+// notAvailable is printed instead of statistics which couldn't be calculated
+// (e.g. 1 NM in a shorter track).
+const notAvailable = "n/a"
+
+// formatNumber formats a number with given number of decimal places and at
+// least 2 digits before the decimal point.
+func formatNumber(n float64, precision int) string {
+	width := precision + 3
+	if precision <= 0 {
+		precision = 0
+		width = 2
 	}
-	return fmt.Sprintf("%06.3f %s (%0.0f sec, %06.3f m, %v)",
-		t.speed, t.speedUnits, t.duration, t.distance, timestamp)
+	return fmt.Sprintf("%0*.*f", width, precision, n)
 }
+
+// DeviceSpeed calculates the average of device-reported speeds of the Track
+// points. Returns false if the device speed is not available.
+func (t Track) DeviceSpeed() (float64, bool) {
+	sum := 0.0
+	count := 0
+	for i := 0; i < len(t.ps); i++ {
+		if t.ps[i].speed != nil {
+			sum += *t.ps[i].speed
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return MsToUnits(sum/float64(count), t.speedUnits), true
+}
+
 func (t Track) String() string {
 	return fmt.Sprintf("dur: %v, dist: %v, speed: %v, ps[0]: %v\n",
 		t.duration, t.distance, t.speed, t.ps[0])
@@ -142,12 +389,32 @@ func (t Track) reCalculate() Track {
 	t.speed = 0
 	for i := 0; i < len(t.ps)-1; i++ {
 		t.duration += t.ps[i+1].ts.Sub(t.ps[i].ts).Seconds()
-		t.distance += distance(t.ps[i], t.ps[i+1])
+		t.distance += segDistance(t.ps[i], t.ps[i+1])
 	}
-	if t.duration > 0 {
-		t.speed = MsToUnits(t.distance/t.duration, t.speedUnits)
+	t.speed = t.avgSpeed()
+
+	return t
+}
+
+// avgSpeed calculates the average speed of the Track from its distance and
+// duration, 0 if the duration is 0 (e.g. points with the same timestamp).
+func (t Track) avgSpeed() float64 {
+	if t.duration <= 0 {
+		return 0
 	}
+	return MsToUnits(t.distance/t.duration, t.speedUnits)
+}
 
+// appendPoint adds the Point to the end of the Track. If the Track is a
+// window over points containing the Point, the window is extended instead of
+// copying the Point.
+func (t Track) appendPoint(p Point) Track {
+	l := len(t.ps)
+	if p.idx < len(t.window) && p.idx >= l && (l == 0 || t.ps[l-1].idx == p.idx-1) {
+		t.ps = t.window[p.idx-l : p.idx+1 : p.idx+1]
+		return t
+	}
+	t.ps = append(t.ps, p)
 	return t
 }
 
@@ -156,25 +423,12 @@ func (t Track) reCalculate() Track {
 //   - ensures the Track is no shorter than minDuration (removing Points from the
 //     beginning of the Track if possible)
 func (t Track) addPointMinDuration(p Point, minDuration float64) Track {
-	return t.addPointMinDurationUnused10s(p, minDuration, false)
-}
-
-// addPointMinDurationUnused10s
-//   - start a new track if unused10sOnly is true and the current point is used or
-//   - add a new Point to the end of the Track
-//   - ensures the Track is no shorter than minDuration (removing Points from the
-//     beginning of the Track if possible)
-func (t Track) addPointMinDurationUnused10s(
-	p Point, minDuration float64, unused10sOnly bool) Track {
-	if unused10sOnly && p.usedFor10s {
-		return Track{speedUnits: t.speedUnits}
-	}
-	t.ps = append(t.ps, p)
+	t = t.appendPoint(p)
 	l := len(t.ps)
 	if l > 1 {
 		t.duration = t.duration + t.ps[l-1].ts.Sub(t.ps[l-2].ts).Seconds()
-		t.distance = t.distance + distance(t.ps[l-2], t.ps[l-1])
-		t.speed = MsToUnits(t.distance/t.duration, t.speedUnits)
+		t.distance = t.distance + segDistance(t.ps[l-2], t.ps[l-1])
+		t.speed = t.avgSpeed()
 		t.valid = t.duration >= minDuration
 
 		// Let's check if we can remove some points from the start of this track.
@@ -183,11 +437,11 @@ func (t Track) addPointMinDurationUnused10s(
 			durTest := t.duration - t.ps[1].ts.Sub(t.ps[0].ts).Seconds()
 			for durTest >= minDuration && len(t.ps) > 2 {
 				t.duration = durTest
-				t.distance = t.distance - distance(t.ps[0], t.ps[1])
+				t.distance = t.distance - segDistance(t.ps[0], t.ps[1])
 				t.ps = t.ps[1:]
 				durTest = t.duration - t.ps[1].ts.Sub(t.ps[0].ts).Seconds()
 			}
-			t.speed = MsToUnits(t.distance/t.duration, t.speedUnits)
+			t.speed = t.avgSpeed()
 		}
 	}
 
@@ -199,39 +453,38 @@ func (t Track) addPointMinDurationUnused10s(
 //   - ensures the Track is no shorter than minDistance (removing Points from the
 //     beginning of the Track if possible)
 func (t Track) addPointMinDistance(p Point, minDistance float64) Track {
-	t.ps = append(t.ps, p)
+	t = t.appendPoint(p)
 	l := len(t.ps)
 	if l > 1 {
 		t.duration = t.duration + t.ps[l-1].ts.Sub(t.ps[l-2].ts).Seconds()
-		t.distance = t.distance + distance(t.ps[l-2], t.ps[l-1])
-		t.speed = MsToUnits(t.distance/t.duration, t.speedUnits)
-		t.valid = t.distance >= minDistance
+		t.distance = t.distance + segDistance(t.ps[l-2], t.ps[l-1])
+		t.speed = t.avgSpeed()
+		t.valid = t.distance >= minDistance && t.duration > 0
 
 		// Let's check if we can remove some points from the start of this track.
 		// If duration is not at minimum and we have some points to remove...
 		if t.distance > minDistance && len(t.ps) > 2 {
-			distTest := t.distance - distance(t.ps[0], t.ps[1])
+			distTest := t.distance - segDistance(t.ps[0], t.ps[1])
 			for distTest >= minDistance && len(t.ps) > 2 {
 				t.distance = distTest
 				t.duration = t.duration - t.ps[1].ts.Sub(t.ps[0].ts).Seconds()
 				t.ps = t.ps[1:]
-				distTest = t.distance - distance(t.ps[0], t.ps[1])
+				distTest = t.distance - segDistance(t.ps[0], t.ps[1])
 			}
-			t.speed = MsToUnits(t.distance/t.duration, t.speedUnits)
+			t.speed = t.avgSpeed()
 		}
 	}
 
 	return t
 }
 
-// addPointAlpha500
-//   - add a new Point to the end of the Track for Alpha 500 m calculation
-//   - ensures the Track is as close but no longer than 500 m
-//   - try to find the subtrack that contains alpha for entry/exit gate max 50 m
-//   - return two Tracks: "this" Track and subtrack containing best alpha
-//     (as described above)
-func (t Track) addPointAlpha500(p Point) (Track, Track) {
-	return t.addPointAlphaMaxDistance(p, 500, 100, 50)
+// GateDistance returns the distance (m) between the first and the last
+// point of the Track, the gate size of an alpha.
+func (t Track) GateDistance() float64 {
+	if len(t.ps) < 2 {
+		return 0
+	}
+	return distance(t.ps[0], t.ps[len(t.ps)-1])
 }
 
 // addPointAlphaMaxDistance
@@ -245,22 +498,22 @@ func (t Track) addPointAlpha500(p Point) (Track, Track) {
 //     (as described above)
 func (t Track) addPointAlphaMaxDistance(p Point,
 	maxDistance, minDistance, gateSize float64) (Track, Track) {
-	t.ps = append(t.ps, p)
+	t = t.appendPoint(p)
 	l := len(t.ps)
 	if l > 1 {
 		t.duration = t.duration + t.ps[l-1].ts.Sub(t.ps[l-2].ts).Seconds()
-		t.distance = t.distance + distance(t.ps[l-2], t.ps[l-1])
+		t.distance = t.distance + segDistance(t.ps[l-2], t.ps[l-1])
 
 		// 1. Do we need to remove some points from the start of this track?
 		//    - find a track with length most close to the maxDistance
 		if t.distance > maxDistance && l > 2 {
-			distTest := t.distance - distance(t.ps[0], t.ps[1])
+			distTest := t.distance - segDistance(t.ps[0], t.ps[1])
 			for distTest > maxDistance && l > 2 {
 				t.distance = distTest
 				t.duration = t.duration - t.ps[1].ts.Sub(t.ps[0].ts).Seconds()
 				t.ps = t.ps[1:]
 				l = len(t.ps)
-				distTest = t.distance - distance(t.ps[0], t.ps[1])
+				distTest = t.distance - segDistance(t.ps[0], t.ps[1])
 			}
 			t.distance = distTest
 			t.duration = t.duration - t.ps[1].ts.Sub(t.ps[0].ts).Seconds()
@@ -272,15 +525,14 @@ func (t Track) addPointAlphaMaxDistance(p Point,
 		// Distance between the first and the last point must be max gateSize (50m).
 		subtrackDistance := t.distance
 		for i := 0; i < l-2; i++ {
-			gateDistance := distance(t.ps[i], t.ps[l-1])
 			if subtrackDistance < minDistance {
 				break
 			}
-			if gateDistance <= gateSize && subtrackDistance >= minDistance {
+			if distance(t.ps[i], t.ps[l-1]) <= gateSize {
 				subtrack := Track{ps: t.ps[i:], valid: true, speedUnits: t.speedUnits}.reCalculate()
 				return t, subtrack
 			}
-			subtrackDistance = subtrackDistance - distance(t.ps[i], t.ps[i+1])
+			subtrackDistance = subtrackDistance - segDistance(t.ps[i], t.ps[i+1])
 		}
 	}
 
@@ -289,79 +541,344 @@ func (t Track) addPointAlphaMaxDistance(p Point,
 
 // Stats constains calculated statistics.
 type Stats struct {
-	totalDistance float64
-	totalDuration float64
-	speed2s       Track
-	speed5x10s    []Track
-	speed15m      Track
-	speed1h       Track
-	speed100m     Track
-	speed1NM      Track
-	alpha500m     Track
-	speedUnits    UnitsFlag
+	totalDistance  float64
+	totalDuration  float64
+	movingDuration float64
+	speed2s        Track
+	speed5x10s     []Track
+	speed15m       Track
+	speed1h        Track
+	speed100m      Track
+	speed1NM       Track
+	alpha500m      Track
+	alphas         []Track
+	headingRose    HeadingRose
+	startTime      time.Time
+	medianInt      float64
+	maxInt         float64
+	startLat       float64
+	startLon       float64
+	endLat         float64
+	endLon         float64
+	centroidLat    float64
+	centroidLon    float64
+	spot           string
+	speedUnits     UnitsFlag
+	deviceSpeed    bool
+	smoothed       bool
+	hrMax          int
+	hrZones        []float64
+	precision      int
+	distModel      DistanceModel
+}
+
+// Distance returns the total distance in meters.
+func (s Stats) Distance() float64 {
+	return s.totalDistance
+}
+
+// DistanceModel returns the DistanceModel used to calculate distances.
+func (s Stats) DistanceModel() DistanceModel {
+	return s.distModel
+}
+
+// Duration returns the total duration.
+func (s Stats) Duration() time.Duration {
+	return time.Duration(s.totalDuration * float64(time.Hour))
+}
+
+// MovingDuration returns the duration of moving faster than 2 kts.
+func (s Stats) MovingDuration() time.Duration {
+	return time.Duration(s.movingDuration * float64(time.Hour))
+}
+
+// SpeedUnits returns units of all speeds.
+func (s Stats) SpeedUnits() UnitsFlag {
+	return s.speedUnits
+}
+
+// StartTime returns the timestamp of the first point.
+func (s Stats) StartTime() time.Time {
+	return s.startTime
+}
+
+// StartPosition returns the latitude and longitude of the first point.
+func (s Stats) StartPosition() (float64, float64) {
+	return s.startLat, s.startLon
+}
+
+// EndPosition returns the latitude and longitude of the last point.
+func (s Stats) EndPosition() (float64, float64) {
+	return s.endLat, s.endLon
+}
+
+// Displacement returns the straight-line distance in meters between the
+// first and the last point.
+func (s Stats) Displacement() float64 {
+	return distance(Point{lat: s.startLat, lon: s.startLon}, Point{lat: s.endLat, lon: s.endLon})
+}
+
+// Centroid returns the latitude and longitude of the centroid of points.
+func (s Stats) Centroid() (float64, float64) {
+	return s.centroidLat, s.centroidLon
+}
+
+// SamplingIntervals returns the median and the max interval (in seconds)
+// between points.
+func (s Stats) SamplingIntervals() (float64, float64) {
+	return s.medianInt, s.maxInt
+}
+
+// Speed2s returns the 2 second peak.
+func (s Stats) Speed2s() Track {
+	return s.speed2s
+}
+
+// Speed5x10s returns the 5 best non-overlapping 10 second tracks.
+func (s Stats) Speed5x10s() []Track {
+	res := make([]Track, len(s.speed5x10s))
+	copy(res, s.speed5x10s)
+	return res
+}
+
+// Speed15m returns the best 15 minutes.
+func (s Stats) Speed15m() Track {
+	return s.speed15m
+}
+
+// Speed1h returns the best hour.
+func (s Stats) Speed1h() Track {
+	return s.speed1h
+}
+
+// Speed100m returns the 100 m peak.
+func (s Stats) Speed100m() Track {
+	return s.speed100m
+}
+
+// Speed1NM returns the best nautical mile.
+func (s Stats) Speed1NM() Track {
+	return s.speed1NM
+}
+
+// Alpha500 returns the best alpha 500.
+func (s Stats) Alpha500() Track {
+	return s.alpha500m
+}
+
+// Alphas returns the fastest non-overlapping alpha tracks, from the fastest
+// one.
+func (s Stats) Alphas() []Track {
+	return s.alphas
+}
+
+// HeadingRose returns the heading rose (calculated only with StatRose).
+func (s Stats) HeadingRose() HeadingRose {
+	return s.headingRose
+}
+
+// HrZones returns the duration spent in each heart rate zone (calculated
+// only with WithHrZones).
+func (s Stats) HrZones() []time.Duration {
+	res := []time.Duration{}
+	for i := 0; i < len(s.hrZones); i++ {
+		res = append(res, time.Duration(s.hrZones[i]*float64(time.Hour)))
+	}
+	return res
+}
+
+// WithPrecision returns a copy of Stats which shows speeds and distances
+// rounded to given number of decimal places.
+func (s Stats) WithPrecision(precision int) Stats {
+	s.precision = precision
+	return s
+}
+
+// fmtNum formats a speed or distance with Stats precision.
+func (s Stats) fmtNum(n float64) string {
+	return formatNumber(n, s.precision)
+}
+
+// WithSpot returns a copy of Stats which shows the given spot name next to
+// the session location.
+func (s Stats) WithSpot(spot string) Stats {
+	s.spot = spot
+	return s
+}
+
+// ShowDeviceSpeed returns a copy of Stats which shows the average device
+// speed next to each statistic calculated from positions.
+func (s Stats) ShowDeviceSpeed(show bool) Stats {
+	s.deviceSpeed = show
+	return s
+}
+
+// WithSmoothing returns a copy of Stats which shows that statistics were
+// calculated from smoothed positions.
+func (s Stats) WithSmoothing(smoothed bool) Stats {
+	s.smoothed = smoothed
+	return s
+}
+
+// txtLine display human-readable entry for the track, with device speed
+// if requested.
+func (s Stats) txtLine(t Track) string {
+	line := t.TxtLinePrecision(s.precision)
+	if !s.deviceSpeed {
+		return line
+	}
+	deviceSpeed, ok := t.DeviceSpeed()
+	if !ok {
+		return line + " [device: n/a]"
+	}
+	return fmt.Sprintf("%s [device: %s %s]", line, s.fmtNum(deviceSpeed), t.SpeedUnits())
+}
+
+// txtAlphaLine display human-readable entry for the alpha track with its
+// gate size and course length.
+func (s Stats) txtAlphaLine(t Track) string {
+	line := s.txtLine(t)
+	if !t.Valid() {
+		return line
+	}
+	return fmt.Sprintf("%s [gate: %.1f m, course: %.0f m]", line, t.GateDistance(), t.Distance())
+}
+
+// TxtTopAlpha display human-readable entry for the alpha track with given
+// index in Alphas, "n/a" if there is no such track.
+func (s Stats) TxtTopAlpha(idx int) string {
+	alphas := s.Alphas()
+	if idx < 0 || idx >= len(alphas) {
+		return notAvailable
+	}
+	return s.txtAlphaLine(alphas[idx])
+}
+
+// TxtTop10s display human-readable entry for the 10 second track with
+// given index, "n/a" if there is no such track (e.g. Stats calculated with
+// fewer 10 second tracks).
+func (s Stats) TxtTop10s(idx int) string {
+	tracks := s.Speed5x10s()
+	if idx < 0 || idx >= len(tracks) {
+		return notAvailable
+	}
+	return s.txtLine(tracks[idx])
 }
 
 // TxtSingleStat returns a single statistic.
 func (s Stats) TxtSingleStat(statType StatFlag) string {
 	switch statType {
 	case Stat2s:
-		return s.speed2s.TxtLine()
+		return s.txtLine(s.Speed2s())
 	case Stat10sAvg:
-		return fmt.Sprintf("%06.3f", s.Calc5x10sAvg())
+		return s.fmt5x10sAvg()
 	case Stat10s1:
-		return s.speed5x10s[0].TxtLine()
+		return s.TxtTop10s(0)
 	case Stat10s2:
-		return s.speed5x10s[1].TxtLine()
+		return s.TxtTop10s(1)
 	case Stat10s3:
-		return s.speed5x10s[2].TxtLine()
+		return s.TxtTop10s(2)
 	case Stat10s4:
-		return s.speed5x10s[3].TxtLine()
+		return s.TxtTop10s(3)
 	case Stat10s5:
-		return s.speed5x10s[4].TxtLine()
+		return s.TxtTop10s(4)
 	case Stat15m:
-		return s.speed15m.TxtLine()
+		return s.txtLine(s.Speed15m())
 	case Stat1h:
-		return s.speed1h.TxtLine()
+		return s.txtLine(s.Speed1h())
 	case Stat100m:
-		return s.speed100m.TxtLine()
+		return s.txtLine(s.Speed100m())
 	case Stat1nm:
-		return s.speed1NM.TxtLine()
+		return s.txtLine(s.Speed1NM())
 	case StatAlpha:
-		return s.alpha500m.TxtLine()
+		return s.txtAlphaLine(s.Alpha500())
+	case StatRose:
+		return s.HeadingRose().TxtRose()
+	case StatDuration:
+		return fmt.Sprintf("total %06.3f h, moving %06.3f h", s.Duration().Hours(), s.MovingDuration().Hours())
+	case StatDistance:
+		return fmt.Sprintf("path %s km, straight %s km",
+			s.fmtNum(s.Distance()/1000), s.fmtNum(s.Displacement()/1000))
 	}
 	return ""
 }
 
 // TxtStats formats statistics as a human-readable text.
 func (s Stats) TxtStats() string {
+	medianInt, maxInt := s.SamplingIntervals()
+	samplingWarning := ""
+	if maxInt > samplingMaxGapWarning {
+		samplingWarning = fmt.Sprintf(
+			"  Warning: max gap longer than %d s, 2s/10s peaks may be unreliable\n",
+			samplingMaxGapWarning)
+	}
+	if s.smoothed {
+		samplingWarning += "  Positions smoothed, results are not comparable with raw tracks\n"
+	}
+	startLat, startLon := s.StartPosition()
+	centroidLat, centroidLon := s.Centroid()
+	spot := ""
+	if s.spot != "" {
+		spot = " (" + s.spot + ")"
+	}
+	top10s := ""
+	tracks10s := s.Speed5x10s()
+	for i := 0; i < len(tracks10s); i++ {
+		top10s += fmt.Sprintf("  Top %d 5x10 speed: %s\n", i+1, s.txtLine(tracks10s[i]))
+	}
 	return fmt.Sprintf(
-		`Total Distance:     %06.3f km
+		`Total Distance:     %s km (%s distance model)
 Total Duration:     %06.3f h
+Sampling:           ~%.1f s, max gap %.0f s
+%sStart Position:     %.5f, %.5f
+Centroid:           %.5f, %.5f%s
 2 Second Peak:      %s
-5x10 Average:       %06.3f %s
-  Top 1 5x10 speed: %s
-  Top 2 5x10 speed: %s
-  Top 3 5x10 speed: %s
-  Top 4 5x10 speed: %s
-  Top 5 5x10 speed: %s
-15 Min:             %s
+5x10 Average:       %s
+5x10 Spread:        %s
+%s15 Min:             %s
 1 Hr:               %s
 100m peak:          %s
 Nautical Mile:      %s
 Alpha 500:          %s
 `,
-		s.totalDistance/1000,
-		s.totalDuration,
-		s.speed2s.TxtLine(),
-		s.Calc5x10sAvg(),
-		s.speedUnits,
-		s.speed5x10s[0].TxtLine(), s.speed5x10s[1].TxtLine(),
-		s.speed5x10s[2].TxtLine(), s.speed5x10s[3].TxtLine(),
-		s.speed5x10s[4].TxtLine(),
-		s.speed15m.TxtLine(), s.speed1h.TxtLine(),
-		s.speed100m.TxtLine(), s.speed1NM.TxtLine(),
-		s.alpha500m.TxtLine())
+		s.fmtNum(s.Distance()/1000), s.DistanceModel(),
+		s.Duration().Hours(),
+		medianInt, maxInt, samplingWarning,
+		startLat, startLon, centroidLat, centroidLon, spot,
+		s.txtLine(s.Speed2s()),
+		s.txt5x10sAvg(), s.txt5x10sSpread(), top10s,
+		s.txtLine(s.Speed15m()), s.txtLine(s.Speed1h()),
+		s.txtLine(s.Speed100m()), s.txtLine(s.Speed1NM()),
+		s.txtAlphaLine(s.Alpha500())) + s.TxtHrZones()
+}
+
+// TxtSummary formats the most important statistics as a single
+// tab-delimited line: date, 2 second peak, 5x10 average, 100m peak,
+// nautical mile, alpha 500 and speed units.
+func (s Stats) TxtSummary() string {
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s",
+		s.StartTime().Format("2006-01-02"),
+		s.fmtSpeed(s.Speed2s()), s.fmt5x10sAvg(), s.fmtSpeed(s.Speed100m()),
+		s.fmtSpeed(s.Speed1NM()), s.fmtSpeed(s.Alpha500()), s.SpeedUnits())
+}
+
+// TxtBest formats the headline statistics (2 second peak, 5x10 average,
+// nautical mile and alpha 500) as a human-readable text.
+func (s Stats) TxtBest() string {
+	return fmt.Sprintf(
+		`Date:               %s
+2 Second Peak:      %s
+5x10 Average:       %s
+Nautical Mile:      %s
+Alpha 500:          %s
+`,
+		s.StartTime().Format("2006-01-02"),
+		s.txtLine(s.Speed2s()),
+		s.txt5x10sAvg(),
+		s.txtLine(s.Speed1NM()),
+		s.txtAlphaLine(s.Alpha500()))
 }
+
 func (s Stats) String() string {
 	return fmt.Sprintf(
 		"dist: %v\n  2s: %v\n  5x10s: %v\n  %v\n  15m: %v\n  1h: %v\n  100m: %v\n  1NM: %v\n  alpha: %v\n",
@@ -372,13 +889,100 @@ func (s Stats) String() string {
 
 // Calc5x10sAvg calculate average from 5 10s speed records.
 func (s Stats) Calc5x10sAvg() float64 {
+	res, _ := CalcTracksAvg(s.speed5x10s)
+	return res
+}
+
+// CalcTracksAvg calculates the average speed of valid tracks. Returns false
+// (and 0) if there is no valid track.
+func CalcTracksAvg(tracks []Track) (float64, bool) {
 	res := 0.0
-	for i := 0; i < len(s.speed5x10s); i++ {
-		res += s.speed5x10s[i].speed
+	validNo := 0
+	for i := 0; i < len(tracks); i++ {
+		if tracks[i].valid {
+			res += tracks[i].speed
+			validNo++
+		}
 	}
-	res = res / float64(len(s.speed5x10s))
+	if validNo == 0 {
+		return 0, false
+	}
+	return res / float64(validNo), true
+}
 
-	return res
+// CalcTracksSpread calculates the (population) standard deviation, the min
+// and the max speed of valid tracks, showing how consistent they are.
+// Returns false (and zeros) if there is no valid track.
+func CalcTracksSpread(tracks []Track) (stdDev, min, max float64, ok bool) {
+	avg, ok := CalcTracksAvg(tracks)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	sqSum := 0.0
+	validNo := 0
+	for i := 0; i < len(tracks); i++ {
+		if !tracks[i].valid {
+			continue
+		}
+		if validNo == 0 || tracks[i].speed < min {
+			min = tracks[i].speed
+		}
+		if validNo == 0 || tracks[i].speed > max {
+			max = tracks[i].speed
+		}
+		sqSum += sq(tracks[i].speed - avg)
+		validNo++
+	}
+	return math.Sqrt(sqSum / float64(validNo)), min, max, true
+}
+
+// fmtSpeed formats the speed of the track, "n/a" if the track is not valid.
+func (s Stats) fmtSpeed(t Track) string {
+	if !t.valid {
+		return notAvailable
+	}
+	return s.fmtNum(t.speed)
+}
+
+// fmt5x10sAvg formats the 5x10 average speed, "n/a" if there are no valid
+// 10 second tracks.
+func (s Stats) fmt5x10sAvg() string {
+	avg, ok := CalcTracksAvg(s.speed5x10s)
+	if !ok {
+		return notAvailable
+	}
+	return s.fmtNum(avg)
+}
+
+// txt5x10sAvg formats the 5x10 average speed with speed units, "n/a" if
+// there are no valid 10 second tracks.
+func (s Stats) txt5x10sAvg() string {
+	avg := s.fmt5x10sAvg()
+	if avg == notAvailable {
+		return avg
+	}
+	return avg + " " + s.SpeedUnits().String()
+}
+
+// txt5x10sSpread formats the standard deviation, the min and the max speed
+// of valid 5x10 tracks, "n/a" if there are no valid 10 second tracks.
+func (s Stats) txt5x10sSpread() string {
+	stdDev, min, max, ok := CalcTracksSpread(s.speed5x10s)
+	if !ok {
+		return notAvailable
+	}
+	validNo := 0
+	for i := 0; i < len(s.speed5x10s); i++ {
+		if s.speed5x10s[i].valid {
+			validNo++
+		}
+	}
+	runs := "runs"
+	if validNo == 1 {
+		runs = "run"
+	}
+	return fmt.Sprintf("std dev %s, min %s, max %s %s (%d %s)",
+		s.fmtNum(stdDev), s.fmtNum(min), s.fmtNum(max), s.SpeedUnits(), validNo, runs)
 }
 
 // intFrom2ub converts 2 unsigned bytes to int.
@@ -394,36 +998,63 @@ func intFrom4sb(b4 []byte) int {
 	return int(b4[0])*256*256*256 + int(b4[1])*256*256 + int(b4[2])*256 + int(b4[3])
 }
 
-// ReadPoints read all Points from the Reader.
-func ReadPoints(r io.Reader) (Points, error) {
-	tt := determineType(r)
+// ReadPoints read all Points from the Reader, detecting the format from the
+// first bytes. Options change how built-in formats are read.
+func ReadPoints(r io.Reader, opts ...ReadOption) (Points, error) {
+	return ReadPointsNamed(r, "", opts...)
+}
 
-	switch tt {
-	case TrackSbn:
-		return ReadPointsSbn(r)
-	case TrackGpx:
-		return ReadPointsGpx(r)
-	default:
-		return Points{Ps: []Point{}}, errs.Errorf("Unknown track type (%v).", tt)
+// ReadPointsNamed read all Points from the Reader like ReadPoints, formats
+// can detect the track also by the name (e.g. file name extension).
+func ReadPointsNamed(r io.Reader, name string, opts ...ReadOption) (Points, error) {
+	f, br, err := detectFormat(r, name, newReadConfig(opts))
+	if err != nil {
+		return Points{Ps: []Point{}}, err
+	}
+
+	points, err := f.Read(br)
+	if (err == nil || err == io.EOF) && len(points.Ps) == 0 {
+		return points, errs.Errorf("%w.", errs.ErrNoPoints)
 	}
+	return points, err
 }
 
-func determineType(r io.Reader) TrackType {
-	br := bufio.NewReaderSize(r, 100)
-	startBytes, _ := br.Peek(100)
+// ReadPointsFunc reads Points from the Reader like ReadPoints, calling fn
+// for each point as soon as it is read instead of returning all of them,
+// e.g. to show progress or stop reading early. Reading stops on the first
+// error returned by fn, which is returned. Points are not cleaned up and
+// GPX timestamps missing in the track are not filled.
+func ReadPointsFunc(r io.Reader, fn func(Point) error, opts ...ReadOption) error {
+	f, br, err := detectFormat(r, "", newReadConfig(opts))
+	if err != nil {
+		return err
+	}
 
-	if len(startBytes) >= 4 {
-		// 160 162 0 34 253 86 86 105 100 111 118
-		if bytes.Equal(startBytes[0:4], []byte{160, 162, 0, 34}) {
-			return TrackSbn
+	pointsNo := 0
+	count := func(p Point) error {
+		pointsNo++
+		return fn(p)
+	}
+	if ff, ok := f.(FuncFormat); ok {
+		err = ff.ReadFunc(br, count)
+	} else {
+		// Points read before an error (e.g. a truncated track) are passed
+		// first, the read error is returned after them.
+		points, readErr := f.Read(br)
+		for i := 0; i < len(points.Ps) && err == nil; i++ {
+			err = count(points.Ps[i])
 		}
-		// 60 63 120 109 108 32 118 101 114 115 105
-		if bytes.Equal(startBytes[0:6], []byte("<?xml ")) {
-			return TrackGpx
+		if err == nil {
+			err = readErr
 		}
 	}
-
-	return TrackUnknown
+	if (err == nil || err == io.EOF) && pointsNo == 0 {
+		return errs.Errorf("%w.", errs.ErrNoPoints)
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
 }
 
 // speed calculate speed as a result of moving between two Points.
@@ -436,9 +1067,18 @@ func speed(p1, p2 Point, speedUnits UnitsFlag) float64 {
 	return speed
 }
 
+// segDistance calculates a distance between two consecutive Points, using
+// the distance cached while calculating statistics if available.
+func segDistance(p1, p2 Point) float64 {
+	if p1.nextDistOk && p2.idx == p1.idx+1 {
+		return p1.nextDist
+	}
+	return distance(p1, p2)
+}
+
 // distance calculates a distance between two Points.
 func distance(p1, p2 Point) float64 {
-	return distSimple(p1.lat, p1.lon, p2.lat, p2.lon)
+	return distLatLon(p1.lat, p1.lon, p2.lat, p2.lon)
 }
 
 // sq calculate square of a float64 number.
@@ -466,173 +1106,419 @@ func distSimple(lat1, lon1, lat2, lon2 float64) float64 {
 	return math.Sqrt(sq(dLatM) + sq(dLonM))
 }
 
-// CleanUp removes points that seems not valid.
-func CleanUp(points Points, deltaSpeedMax float64, speedUnits UnitsFlag) []Point {
+// FilterTimeWindow keeps only points with timestamps inside the [from, to]
+// time window. Zero from or to value means the window is open on that side.
+// Returns an error if the window doesn't overlap the track.
+func FilterTimeWindow(points Points, from, to time.Time) (Points, error) {
 	psCurr := points.Ps
-	res := []Point{}
-	if len(psCurr) > 1 {
-		// Simple cleanup strategies working great for Amazfit T-Rex Pro:
-		// - if points have same timestamp, remove both points
-		// - removing points "around" missing points (1 before, 3 after)
-		//
-		// When we find missing point(s):
-		// - remove 1 point before the first missing point
-		// - remove 3 points after the last missing point
-		//
-		// For example, we should have seconds:
-		// - 43, 44, 45, 46, 47. 48, 49, 50, 51, 52, 53, 54
-		// There are only:
-		// - 43, 44, 45, 46,     48,     50, 51, 52, 53, 54
-		// We need to produce:
-		// - 43, 44, 45,         48,                 53, 54
-		psCleaned := []Point{}
-		psCleaned = append(psCleaned, psCurr[0])
-		psLen := len(psCurr)
-		for idxPs := 1; idxPs < psLen; idxPs++ {
-			pCurr := psCurr[idxPs]
-
-			if idxPs < psLen-1 {
-				pNext := psCurr[idxPs+1]
-				// fmt.Printf("curr / next ts: %v / %v, next - curr: %v\n", pCurr.ts, pNext.ts, pNext.ts.Sub(pCurr.ts).Seconds())
-				if pCurr.ts == pNext.ts {
-					// Skip both points if times are equal.
-					idxPs++
-					// fmt.Printf("====> skipping curr & next: %v & %v\n", pCurr, pNext)
-				} else {
-					// Remove points "around" missing points.
-					// Missing point is point more than 1 second after previous point.
-					dt := pNext.ts.Sub(pCurr.ts).Seconds()
-					if dt > 1 {
-						idxNext := idxPs + 1
-						idxLast := idxNext
-						// fmt.Printf("====> dt > 1, idxPs, idxNext, idxLast, pNext: %v, %v, %v, %v\n", idxPs, idxNext, idxLast, pNext)
-						for idxNext < psLen-1 && dt > 1 {
-							p1 := psCurr[idxNext]
-							p2 := psCurr[idxNext+1]
-							dt = p2.ts.Sub(p1.ts).Seconds()
-							idxLast = idxNext
-							idxNext++
-							// fmt.Printf("====> dt: %v, idxPs, idxNext, idxLast: %v, %v, %v\n", dt, idxPs, idxNext, idxLast)
-						}
-						// Skip points from the pCurr (first before first missing) to pLast + 2 (third after last missing)
-						idxPs += idxLast - idxPs + 2
-						// fmt.Printf("====> skipping from %v to %v\n", pCurr, psCurr[idxLast])
-					} else {
-						// fmt.Printf("adding %v\n", pCurr)
-						psCleaned = append(psCleaned, pCurr)
-						pCurr = pNext
-					}
-				}
-			} else {
-				psCleaned = append(psCleaned, pCurr)
-			}
+	if len(psCurr) == 0 || (from.IsZero() && to.IsZero()) {
+		return points, nil
+	}
+
+	first := psCurr[0].ts
+	last := psCurr[len(psCurr)-1].ts
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return points, errs.Errorf("Time window start (%v) is not before its end (%v).", from, to)
+	}
+	if !from.IsZero() && from.After(last) {
+		return points, errs.Errorf("Time window start (%v) is after the track end (%v).", from, last)
+	}
+	if !to.IsZero() && to.Before(first) {
+		return points, errs.Errorf("Time window end (%v) is before the track start (%v).", to, first)
+	}
+
+	ps := []Point{}
+	for idxPs := 0; idxPs < len(psCurr); idxPs++ {
+		p := psCurr[idxPs]
+		if !from.IsZero() && p.ts.Before(from) {
+			continue
 		}
-		psCurr = psCleaned
-		psCleaned = nil
-		// res = psCurr
-
-		// Cleanup speeds - remove outlier points:
-		// - fast stops are permitted - crashes or near stops
-		// - fast speedups are not permitted - errors
-		// - filter out series of points where the speed increases, decreases
-		//   and again increases in a short time period
-		res = append(res, psCurr[0], psCurr[1])
-		speedPrev := speed(psCurr[0], psCurr[1], speedUnits)
-		idxRes := 1
-		for idxPs := 2; idxPs < len(psCurr)-1; idxPs++ {
-			// Compare speed changes between 3 points
-			// (previous, current & next point).
-			// 3 speeds: 2 speeds between 3 points + previous speed.
-			speedCur := speed(res[idxRes], psCurr[idxPs], speedUnits)
-			speedNext1 := speed(psCurr[idxPs], psCurr[idxPs+1], speedUnits)
-			// 2 speed changes
-			speed0Delta := speedCur - speedPrev
-			speed1Delta := speedNext1 - speedCur
-			// 1 differences between speed changes
-			diffDelta1 := speed0Delta - speed1Delta
-
-			// Ignore points where the speed difference between last two points
-			//   increases more than given params.
-			// if (diffDelta1 < deltaKtsMax && diffDelta2 < deltaKtsMax) || speed0DeltaKts < 0 {
-			if (diffDelta1 < deltaSpeedMax) || speed0Delta < 0 {
-				// fmt.Printf("OK  idxPs: %v, idxRes: %v, speedCur/n1/n2: %v/%v/%v, sd0: %v, sd1: %v, dd1: %v (%v)\n", idxPs, idxRes, speedCur, speedNext1, speedNext2, speed0DeltaKts, speed1DeltaKts, diffDelta1, psCurr[idxPs].ts)
-				speedPrev = speedCur
-				res = append(res, psCurr[idxPs])
-				idxRes++
-				res[idxRes].globalIdx = idxRes
-			} else {
-				// fmt.Printf("==== NOK idxPs: %v, idxRes: %v, speedCur/n1/n2: %v/%v/%v, sd0: %v, sd1: %v, dd1: %v (%v)\n", idxPs, idxRes, speedCur, speedNext1, speedNext2, speed0DeltaKts, speed1DeltaKts, diffDelta1, psCurr[idxPs].ts)
-			}
+		if !to.IsZero() && p.ts.After(to) {
+			continue
+		}
+		ps = append(ps, p)
+	}
+	if len(ps) == 0 {
+		return points, errs.Errorf("No track points inside the time window (%v - %v).", from, to)
+	}
+
+	points.Ps = ps
+	return points, nil
+}
+
+// Segment is a range of points [Start, End) recorded without breaks (a GPX
+// track segment or a part of the track between long gaps).
+type Segment struct {
+	Start     int
+	End       int
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// Segments returns ranges of points belonging to the same segment.
+func (p Points) Segments() []Segment {
+	res := []Segment{}
+	for i := 0; i < len(p.Ps); i++ {
+		if i == 0 || p.Ps[i].segment != p.Ps[i-1].segment {
+			res = append(res, Segment{Start: i, StartTime: p.Ps[i].ts})
+		}
+		res[len(res)-1].End = i + 1
+		res[len(res)-1].EndTime = p.Ps[i].ts
+	}
+	return res
+}
+
+// MarkGapSegments returns a copy of Points where a new segment starts after
+// each gap between consecutive points longer than maxGap. This is useful for
+// SBN files, which contain no segment markers, so multiple sessions recorded
+// in a single file are a single stream of points.
+func (p Points) MarkGapSegments(maxGap time.Duration) Points {
+	ps := make([]Point, len(p.Ps))
+	copy(ps, p.Ps)
+	segment := 0
+	for i := 0; i < len(ps); i++ {
+		if i > 0 && (p.Ps[i].segment != p.Ps[i-1].segment || ps[i].ts.Sub(ps[i-1].ts) > maxGap) {
+			segment++
+		}
+		ps[i].segment = segment
+	}
+	p.Ps = ps
+	return p
+}
+
+// SplitSessions splits points into sessions on track segment boundaries and
+// on gaps between consecutive points longer than maxGap. Points in each
+// session are copied, keeping their indexes in the track file, so each
+// session can be passed to CalculateStats on its own.
+func SplitSessions(ps []Point, maxGap time.Duration) [][]Point {
+	res := [][]Point{}
+	session := []Point{}
+	for i := 0; i < len(ps); i++ {
+		if i > 0 && (ps[i].segment != ps[i-1].segment || ps[i].ts.Sub(ps[i-1].ts) > maxGap) {
+			res = append(res, session)
+			session = []Point{}
 		}
+		session = append(session, ps[i])
+	}
+	if len(session) > 0 {
+		res = append(res, session)
 	}
 
 	return res
 }
 
-// CalculateStats calculate statistics from cleaned up points.
+// SamplingIntervals calculates median and max interval (in seconds) between
+// consecutive points.
+func SamplingIntervals(ps []Point) (float64, float64) {
+	if len(ps) < 2 {
+		return 0, 0
+	}
+	intervals := make([]float64, len(ps)-1)
+	for i := 1; i < len(ps); i++ {
+		intervals[i-1] = ps[i].ts.Sub(ps[i-1].ts).Seconds()
+	}
+	sort.Float64s(intervals)
+
+	median := intervals[len(intervals)/2]
+	if len(intervals)%2 == 0 {
+		median = (intervals[len(intervals)/2-1] + intervals[len(intervals)/2]) / 2
+	}
+	return median, intervals[len(intervals)-1]
+}
+
+// CalcOptions contains thresholds used by CalculateStatsWith. Fields which
+// are 0 are taken from DefaultCalcOptions.
+type CalcOptions struct {
+	// AlphaMaxDistance is the max distance (m) of an alpha.
+	AlphaMaxDistance float64
+	// AlphaMinDistance is the min distance (m) of an alpha, so riding
+	// straight is not an alpha.
+	AlphaMinDistance float64
+	// AlphaGateSize is the max distance (m) between the alpha entry and exit.
+	AlphaGateSize float64
+	// AlphaTopCount is the number of the fastest non-overlapping alphas
+	// kept.
+	AlphaTopCount int
+	// Top10sCount is the number of non-overlapping 10 second tracks averaged
+	// for the 5x10 statistic.
+	Top10sCount int
+	// HeadingBinSize is the heading rose bin size in degrees.
+	HeadingBinSize float64
+	// HeadingMinDistance is the min distance (m) between points to trust the
+	// heading.
+	HeadingMinDistance float64
+	// MovingMinSpeed is the min speed (m/s) counted as moving time.
+	MovingMinSpeed float64
+}
+
+// DefaultCalcOptions returns thresholds used by CalculateStats.
+func DefaultCalcOptions() CalcOptions {
+	return CalcOptions{
+		AlphaMaxDistance:   500,
+		AlphaMinDistance:   100,
+		AlphaGateSize:      50,
+		AlphaTopCount:      5,
+		Top10sCount:        5,
+		HeadingBinSize:     headingBinSize,
+		HeadingMinDistance: minHeadingDistance,
+		MovingMinSpeed:     movingMinSpeed,
+	}
+}
+
+// withDefaults returns a copy of options with fields which are 0 taken from
+// DefaultCalcOptions.
+func (o CalcOptions) withDefaults() CalcOptions {
+	d := DefaultCalcOptions()
+	if o.AlphaMaxDistance == 0 {
+		o.AlphaMaxDistance = d.AlphaMaxDistance
+	}
+	if o.AlphaMinDistance == 0 {
+		o.AlphaMinDistance = d.AlphaMinDistance
+	}
+	if o.AlphaGateSize == 0 {
+		o.AlphaGateSize = d.AlphaGateSize
+	}
+	if o.AlphaTopCount == 0 {
+		o.AlphaTopCount = d.AlphaTopCount
+	}
+	if o.Top10sCount == 0 {
+		o.Top10sCount = d.Top10sCount
+	}
+	if o.HeadingBinSize == 0 {
+		o.HeadingBinSize = d.HeadingBinSize
+	}
+	if o.HeadingMinDistance == 0 {
+		o.HeadingMinDistance = d.HeadingMinDistance
+	}
+	if o.MovingMinSpeed == 0 {
+		o.MovingMinSpeed = d.MovingMinSpeed
+	}
+	return o
+}
+
+// overlaps returns true if tracks share any point.
+func (t Track) overlaps(o Track) bool {
+	if len(t.ps) == 0 || len(o.ps) == 0 {
+		return false
+	}
+	return !t.ps[len(t.ps)-1].ts.Before(o.ps[0].ts) &&
+		!o.ps[len(o.ps)-1].ts.Before(t.ps[0].ts)
+}
+
+// addTopTrack adds the track to tracks ordered from the fastest one, keeping
+// at most n tracks. Tracks overlapping the track are replaced by it if it is
+// faster than all of them, otherwise the track is not added, so every kept
+// track is the fastest one of a different part of the session (e.g. a turn).
+func addTopTrack(tracks []Track, t Track, n int) []Track {
+	for i := 0; i < len(tracks); i++ {
+		if tracks[i].overlaps(t) && tracks[i].speed >= t.speed {
+			return tracks
+		}
+	}
+	res := []Track{}
+	added := false
+	for i := 0; i < len(tracks); i++ {
+		if tracks[i].overlaps(t) {
+			continue
+		}
+		if !added && tracks[i].speed < t.speed {
+			res = append(res, t)
+			added = true
+		}
+		res = append(res, tracks[i])
+	}
+	if !added {
+		res = append(res, t)
+	}
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// candidate10s is a valid 10 second track found while calculating
+// statistics, with indexes of its first and the last point.
+type candidate10s struct {
+	start    int
+	end      int
+	duration float64
+	distance float64
+	speed    float64
+}
+
+// precedes returns true if the candidate is selected before the other one:
+// it is faster or equally fast and earlier.
+func (c candidate10s) precedes(o candidate10s) bool {
+	if c.speed == o.speed {
+		return c.end < o.end
+	}
+	return c.speed > o.speed
+}
+
+// overlaps returns true if candidates share any point.
+func (c candidate10s) overlaps(o candidate10s) bool {
+	return c.start <= o.end && o.start <= c.end
+}
+
+// select5x10s selects up to n fastest non-overlapping 10 second tracks from
+// candidates (ordered by the last point), ordered from the fastest one. The
+// earlier one of equally fast tracks is selected first.
+func select5x10s(ctx context.Context, progress Progress, ps []Point,
+	candidates []candidate10s, n int, speedUnits UnitsFlag) ([]Track, error) {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].precedes(candidates[j])
+	})
+	selected := []candidate10s{}
+	for i := 0; i < len(candidates) && len(selected) < n; i++ {
+		if err := checkCtx(ctx, progress, "5x10", i, len(candidates)); err != nil {
+			return nil, err
+		}
+		c := candidates[i]
+		if c.speed <= 0 {
+			break
+		}
+		used := false
+		for j := 0; j < len(selected); j++ {
+			if c.overlaps(selected[j]) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			selected = append(selected, c)
+		}
+	}
+	if progress != nil {
+		progress("5x10", len(ps), 1)
+	}
+
+	res := []Track{}
+	for i := 0; i < len(selected); i++ {
+		c := selected[i]
+		res = append(res, Track{
+			ps:         ps[c.start : c.end+1],
+			duration:   c.duration,
+			distance:   c.distance,
+			speed:      c.speed,
+			speedUnits: speedUnits,
+			valid:      true,
+		})
+	}
+	return res, nil
+}
+
+// expandStatType adds statistics contained in summary and best results to
+// the statType.
+func expandStatType(statType StatFlag) StatFlag {
+	// Summary and best results contain a subset of all statistics.
+	if statType&StatSummary != 0 {
+		statType |= Stat2s | Stat10sAvg | Stat100m | Stat1nm | StatAlpha
+	}
+	if statType&StatBest != 0 {
+		statType |= Stat2s | Stat10sAvg | Stat1nm | StatAlpha
+	}
+	return statType
+}
+
+// CalculateStats calculate statistics from cleaned up points, points
+// marked invalid by cleanup are skipped.
 func CalculateStats(ps []Point, statType StatFlag, speedUnits UnitsFlag) Stats {
-	switch speedUnits {
-	case UnitsMs:
+	return CalculateStatsWith(ps, statType, speedUnits, DefaultCalcOptions())
+}
+
+// CalculateStatsWith calculate statistics like CalculateStats using given
+// thresholds.
+func CalculateStatsWith(ps []Point, statType StatFlag, speedUnits UnitsFlag, opts CalcOptions) Stats {
+	res, _ := CalculateStatsCtx(context.Background(), ps, statType, speedUnits, opts, nil)
+	return res
+}
+
+// CalculateStatsCtx calculate statistics like CalculateStatsWith until the
+// context is done, reporting progress if progress is not nil.
+func CalculateStatsCtx(ctx context.Context, ps []Point, statType StatFlag, speedUnits UnitsFlag,
+	opts CalcOptions, progress Progress) (Stats, error) {
+	statType = expandStatType(statType)
+	opts = opts.withDefaults()
+	res := Stats{speedUnits: speedUnits, precision: defaultPrecision, distModel: distanceModel}
+	// Points may come from any source (not only from CleanUp), so index a
+	// copy of valid points here for the 5x10 bookkeeping and cache distances
+	// between them, tracks calculate them repeatedly.
+	ps = ValidPoints(ps)
+	for i := 0; i < len(ps); i++ {
+		ps[i].idx = i
+		ps[i].nextDistOk = i < len(ps)-1
+		if ps[i].nextDistOk {
+			ps[i].nextDist = distance(ps[i], ps[i+1])
+		}
+	}
+	for i := 0; i < opts.Top10sCount; i++ {
+		res.speed5x10s = append(res.speed5x10s, Track{speedUnits: speedUnits})
+	}
+	if len(ps) > 0 {
+		res.startTime = ps[0].ts
+		res.startLat, res.startLon = ps[0].lat, ps[0].lon
+		res.endLat, res.endLon = ps[len(ps)-1].lat, ps[len(ps)-1].lon
+		res.centroidLat, res.centroidLon = Points{Ps: ps}.Centroid()
 	}
-	res := Stats{speedUnits: speedUnits}
-	res.speed5x10s = append(res.speed5x10s,
-		Track{speedUnits: speedUnits}, Track{speedUnits: speedUnits}, Track{speedUnits: speedUnits}, Track{speedUnits: speedUnits}, Track{speedUnits: speedUnits})
 	if len(ps) > 1 {
-		track2s := Track{speedUnits: speedUnits}
-		track15m := Track{speedUnits: speedUnits}
-		track1h := Track{speedUnits: speedUnits}
-		track100m := Track{speedUnits: speedUnits}
-		track1NM := Track{speedUnits: speedUnits}
-		trackAlpha500m := Track{speedUnits: speedUnits}
-		subtrackAlpha500m := Track{speedUnits: speedUnits}
-
-		switch statType {
-		case StatAll:
-			track2s = track2s.addPointMinDuration(ps[0], 2)
-			track15m = track15m.addPointMinDuration(ps[0], 900)
-			track1h = track1h.addPointMinDuration(ps[0], 3600)
-			track100m = track100m.addPointMinDistance(ps[0], 100)
-			track1NM = track1NM.addPointMinDistance(ps[0], 1852)
-			trackAlpha500m, subtrackAlpha500m =
-				trackAlpha500m.addPointAlpha500(ps[0])
-		case Stat2s:
-			track2s = track2s.addPointMinDuration(ps[0], 2)
-		case Stat15m:
-			track15m = track15m.addPointMinDuration(ps[0], 900)
-		case Stat1h:
-			track1h = track1h.addPointMinDuration(ps[0], 3600)
-		case Stat100m:
-			track100m = track100m.addPointMinDistance(ps[0], 100)
-		case Stat1nm:
-			track1NM = track1NM.addPointMinDistance(ps[0], 1852)
-		case StatAlpha:
-			trackAlpha500m, subtrackAlpha500m =
-				trackAlpha500m.addPointAlpha500(ps[0])
-		}
-		for i := 1; i < len(ps); i++ {
-			res.totalDistance = res.totalDistance + distance(ps[i-1], ps[i])
-			switch statType {
-			case StatAll:
-				track2s = track2s.addPointMinDuration(ps[i], 2)
-				track15m = track15m.addPointMinDuration(ps[i], 900)
-				track1h = track1h.addPointMinDuration(ps[i], 3600)
-				track100m = track100m.addPointMinDistance(ps[i], 100)
-				track1NM = track1NM.addPointMinDistance(ps[i], 1852)
-				trackAlpha500m, subtrackAlpha500m =
-					trackAlpha500m.addPointAlpha500(ps[i])
-			case Stat2s:
+		track2s := Track{speedUnits: speedUnits, window: ps}
+		track10s := Track{speedUnits: speedUnits, window: ps}
+		track15m := Track{speedUnits: speedUnits, window: ps}
+		track1h := Track{speedUnits: speedUnits, window: ps}
+		track100m := Track{speedUnits: speedUnits, window: ps}
+		track1NM := Track{speedUnits: speedUnits, window: ps}
+		trackAlpha500m := Track{speedUnits: speedUnits, window: ps}
+		subtrackAlpha500m := Track{speedUnits: speedUnits, window: ps}
+		candidates10s := []candidate10s{}
+
+		for i := 0; i < len(ps); i++ {
+			if err := checkCtx(ctx, progress, "stats", i, len(ps)); err != nil {
+				return res, err
+			}
+			if ps[i].jump {
+				// Tracks can't span a position jump.
+				track2s = Track{speedUnits: speedUnits, window: ps}
+				track10s = Track{speedUnits: speedUnits, window: ps}
+				track15m = Track{speedUnits: speedUnits, window: ps}
+				track1h = Track{speedUnits: speedUnits, window: ps}
+				track100m = Track{speedUnits: speedUnits, window: ps}
+				track1NM = Track{speedUnits: speedUnits, window: ps}
+				trackAlpha500m = Track{speedUnits: speedUnits, window: ps}
+				subtrackAlpha500m = Track{speedUnits: speedUnits, window: ps}
+			} else if i > 0 {
+				res.totalDistance = res.totalDistance + ps[i-1].nextDist
+				if ps[i-1].nextDist/ps[i].ts.Sub(ps[i-1].ts).Seconds() > opts.MovingMinSpeed {
+					res.movingDuration += ps[i].ts.Sub(ps[i-1].ts).Hours()
+				}
+			}
+			if statType&Stat2s != 0 {
 				track2s = track2s.addPointMinDuration(ps[i], 2)
-			case Stat15m:
+			}
+			if statType&Stat5x10s != 0 {
+				track10s = track10s.addPointMinDuration(ps[i], 10)
+				if track10s.valid {
+					candidates10s = append(candidates10s, candidate10s{
+						start: track10s.ps[0].idx, end: i,
+						duration: track10s.duration, distance: track10s.distance, speed: track10s.speed,
+					})
+				}
+			}
+			if statType&Stat15m != 0 {
 				track15m = track15m.addPointMinDuration(ps[i], 900)
-			case Stat1h:
+			}
+			if statType&Stat1h != 0 {
 				track1h = track1h.addPointMinDuration(ps[i], 3600)
-			case Stat100m:
+			}
+			if statType&Stat100m != 0 {
 				track100m = track100m.addPointMinDistance(ps[i], 100)
-			case Stat1nm:
+			}
+			if statType&Stat1nm != 0 {
 				track1NM = track1NM.addPointMinDistance(ps[i], 1852)
-			case StatAlpha:
+			}
+			if statType&StatAlpha != 0 {
 				trackAlpha500m, subtrackAlpha500m =
-					trackAlpha500m.addPointAlpha500(ps[i])
+					trackAlpha500m.addPointAlphaMaxDistance(ps[i],
+						opts.AlphaMaxDistance, opts.AlphaMinDistance, opts.AlphaGateSize)
 			}
 			// If any of calculated statistics is prepared (valid) and the statistic
 			//   is a highest one, save it.
@@ -654,33 +1540,33 @@ func CalculateStats(ps []Point, statType StatFlag, speedUnits UnitsFlag) Stats {
 			if subtrackAlpha500m.valid && res.alpha500m.speed < subtrackAlpha500m.speed {
 				res.alpha500m = subtrackAlpha500m
 			}
+			if subtrackAlpha500m.valid {
+				res.alphas = addTopTrack(res.alphas, subtrackAlpha500m, opts.AlphaTopCount)
+			}
+		}
+
+		if progress != nil {
+			progress("stats", len(ps), 1)
 		}
 
 		res.totalDuration = ps[len(ps)-1].ts.Sub(ps[0].ts).Hours()
+		res.medianInt, res.maxInt = SamplingIntervals(ps)
 
-		switch statType {
-		case StatAll, Stat10sAvg, Stat10s1, Stat10s2, Stat10s3, Stat10s4, Stat10s5:
-			// 5 x 10 secs need to gather 5 different, non-overlapping tracks.
-			for track5x10sIdx := 0; track5x10sIdx < 5; track5x10sIdx++ {
-				track5x10s := Track{speedUnits: speedUnits}
-				track5x10s = track5x10s.addPointMinDurationUnused10s(ps[0], 10, true)
-				for i := 1; i < len(ps); i++ {
-					track5x10s = track5x10s.addPointMinDurationUnused10s(ps[i], 10, true)
-					if track5x10s.valid && res.speed5x10s[track5x10sIdx].speed < track5x10s.speed {
-						res.speed5x10s[track5x10sIdx] = track5x10s
-					}
-				}
+		if statType&StatRose != 0 {
+			res.headingRose = calculateHeadingRose(ps, opts.HeadingBinSize, opts.HeadingMinDistance)
+		}
 
-				track5x10s = res.speed5x10s[track5x10sIdx]
-				for i := 0; i < len(track5x10s.ps); i++ {
-					ps[track5x10s.ps[i].globalIdx].usedFor10s = true
-				}
+		if statType&Stat5x10s != 0 {
+			speed5x10s, err := select5x10s(ctx, progress, ps, candidates10s, opts.Top10sCount, speedUnits)
+			if err != nil {
+				return res, err
 			}
+			copy(res.speed5x10s, speed5x10s)
 		}
 
 	}
 
-	return res
+	return res, nil
 }
 
 // KtsToMs converts kts to m/s.
@@ -688,6 +1574,45 @@ func KtsToMs(speedKts float64) float64 {
 	return speedKts / mPerSecToKts
 }
 
+// KmhToMs converts km/h to m/s.
+func KmhToMs(speedKmh float64) float64 {
+	return speedKmh / mPerSecToKmh
+}
+
+// KtsToKmh converts kts to km/h.
+func KtsToKmh(speedKts float64) float64 {
+	return speedKts / mPerSecToKts * mPerSecToKmh
+}
+
+// KmhToKts converts km/h to kts.
+func KmhToKts(speedKmh float64) float64 {
+	return speedKmh / mPerSecToKmh * mPerSecToKts
+}
+
+// UnitsToMs converts speed in specified units to m/s, the inverse of
+// MsToUnits.
+func UnitsToMs(speed float64, speedUnits UnitsFlag) float64 {
+	switch speedUnits {
+	case UnitsMs:
+		return speed
+	case UnitsKmh:
+		return KmhToMs(speed)
+	case UnitsKts:
+		return KtsToMs(speed)
+	default:
+		return speed
+	}
+}
+
+// ConvertSpeed converts speed between units. Speed in the same units is
+// returned unchanged.
+func ConvertSpeed(speed float64, from, to UnitsFlag) float64 {
+	if from == to {
+		return speed
+	}
+	return MsToUnits(UnitsToMs(speed, from), to)
+}
+
 // MsToUnits converts m/s to specified units.
 func MsToUnits(speedMs float64, speedUnits UnitsFlag) float64 {
 	switch speedUnits {
@@ -0,0 +1,740 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		n         float64
+		precision int
+		want      string
+	}{
+		{36.4213, 3, "36.421"},
+		{36.4213, 2, "36.42"},
+		{36.4213, 1, "36.4"},
+		{36.4213, 0, "36"},
+		{36.4213, -1, "36"},
+		{5.5, 2, "05.50"},
+		{0, 0, "00"},
+		{1234.5678, 2, "1234.57"},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		if got := formatNumber(tt.n, tt.precision); got != tt.want {
+			t.Errorf("formatNumber(%v, %d) = %q, want %q", tt.n, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestStatsWithPrecision(t *testing.T) {
+	ps := straightTrack(KtsToMs(36.4213), 2*time.Minute, time.Second)
+	s := CalculateStats(ps, StatAll, UnitsKts)
+
+	tests := []struct {
+		precision int
+		want      string
+	}{
+		{2, "36.42 kts"},
+		{3, "36.421 kts"},
+		{0, "36 kts"},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		got := s.WithPrecision(tt.precision).TxtSingleStat(Stat2s)
+		if !strings.HasPrefix(got, tt.want+" (") {
+			t.Errorf("precision %d: got %q, want prefix %q", tt.precision, got, tt.want)
+		}
+	}
+	// The default precision is kept if not set.
+	if got := s.TxtSingleStat(Stat2s); !strings.HasPrefix(got, "36.421 kts") {
+		t.Errorf("default precision: got %q", got)
+	}
+}
+
+// alphaTrack generates a track with a single alpha: 200 m east, a gybe and
+// 200 m back west 20 m north of the way out.
+func alphaTrack() []Point {
+	return generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second, legs: []leg{
+		{90, 10, 60 * time.Second}, {0, 10, 2 * time.Second}, {270, 10, 20 * time.Second}, {0, 5, time.Minute}}})
+}
+
+func TestCalculateStatsWithDefaults(t *testing.T) {
+	ps := alphaTrack()
+	want := CalculateStats(ps, StatAll|StatRose, UnitsMs)
+	if !want.Alpha500().Valid() {
+		t.Fatal("no alpha in the test track")
+	}
+
+	tests := []struct {
+		name     string
+		opts     CalcOptions
+		top10s   int
+		alphaLen float64
+	}{
+		{"zero", CalcOptions{}, 5, want.Alpha500().Distance()},
+		{"only top 10s", CalcOptions{Top10sCount: 3}, 3, want.Alpha500().Distance()},
+		{"defaults", DefaultCalcOptions(), 5, want.Alpha500().Distance()},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			s := CalculateStatsWith(ps, StatAll|StatRose, UnitsMs, tt.opts)
+			if len(s.Speed5x10s()) != tt.top10s {
+				t.Errorf("got %d 10s tracks, want %d", len(s.Speed5x10s()), tt.top10s)
+			}
+			if !s.Alpha500().Valid() || s.Alpha500().Distance() != tt.alphaLen {
+				t.Errorf("got alpha %s, want %s", s.Alpha500().TxtLine(), want.Alpha500().TxtLine())
+			}
+			if len(s.HeadingRose().Bins) != len(want.HeadingRose().Bins) {
+				t.Errorf("got %d heading bins, want %d", len(s.HeadingRose().Bins), len(want.HeadingRose().Bins))
+			}
+			if s.MovingDuration() != want.MovingDuration() {
+				t.Errorf("got moving duration %v, want %v", s.MovingDuration(), want.MovingDuration())
+			}
+
+			b := NewStatsBuilder(StatAll|StatRose, UnitsMs, tt.opts)
+			for j := 0; j < len(ps); j++ {
+				b.Add(ps[j])
+			}
+			if got := b.Snapshot(); len(got.Speed5x10s()) != tt.top10s || got.Alpha500().Distance() != tt.alphaLen {
+				t.Errorf("builder: got %d 10s tracks, alpha %s", len(got.Speed5x10s()), got.Alpha500().TxtLine())
+			}
+		})
+	}
+
+	// Set fields are used, the way back is 20 m from the way out.
+	s := CalculateStatsWith(ps, StatAll, UnitsMs, CalcOptions{AlphaGateSize: 10})
+	if s.Alpha500().Valid() {
+		t.Errorf("got alpha %s with 10 m gate", s.Alpha500().TxtLine())
+	}
+}
+
+func TestTxtSingleStatShortTrack(t *testing.T) {
+	tests := []struct {
+		name   string
+		legs   []leg
+		valid  int // Number of non-overlapping 10 second windows.
+		avg    float64
+		avgTxt string
+	}{
+		{"15 seconds", []leg{{0, 12, 15 * time.Second}}, 1, 12, "12.000"},
+		{"30 seconds", []leg{{0, 12, 10 * time.Second}, {0, 8, 20 * time.Second}}, 2, 10, "10.000"},
+	}
+	flags := []StatFlag{Stat10s1, Stat10s2, Stat10s3, Stat10s4, Stat10s5}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			ps := generateTrack(trackSpec{lat: 45.8, lon: 15.9, start: testStart, interval: time.Second, legs: tt.legs})
+			s := CalculateStats(ps, Stat5x10s, UnitsMs)
+
+			for j := 0; j < len(flags); j++ {
+				line := s.TxtSingleStat(flags[j])
+				if (j < tt.valid) == (line == notAvailable) {
+					t.Errorf("TxtSingleStat(%v) = %q, want n/a only for missing windows", flags[j], line)
+				}
+			}
+			if got := s.TxtSingleStat(Stat10sAvg); got != tt.avgTxt {
+				t.Errorf("TxtSingleStat(%v) = %q, want %q", Stat10sAvg, got, tt.avgTxt)
+			}
+			// Missing windows don't lower the average.
+			if got := s.Calc5x10sAvg(); !almostEqual(got, tt.avg, 0.001) {
+				t.Errorf("Calc5x10sAvg() = %v, want %v", got, tt.avg)
+			}
+		})
+	}
+
+	// Stats calculated without 5x10 tracks.
+	s := CalculateStats(straightTrack(10, 30*time.Second, time.Second), Stat2s, UnitsMs)
+	for j := 0; j < len(flags); j++ {
+		if got := s.TxtSingleStat(flags[j]); got != notAvailable {
+			t.Errorf("TxtSingleStat(%v) without 5x10 tracks = %q, want %q", flags[j], got, notAvailable)
+		}
+	}
+	if got := s.TxtSingleStat(Stat10sAvg); got != notAvailable {
+		t.Errorf("TxtSingleStat(%v) without 5x10 tracks = %q, want %q", Stat10sAvg, got, notAvailable)
+	}
+}
+
+// multiPass5x10s selects n 10 second tracks like CalculateStats did before
+// select5x10s: the fastest rolling track without points used by the tracks
+// already selected, scanning all points again for each track.
+func multiPass5x10s(ps []Point, n int, speedUnits UnitsFlag) []Track {
+	ps = ValidPoints(ps)
+	for i := 0; i < len(ps); i++ {
+		ps[i].idx = i
+	}
+	used := make([]bool, len(ps))
+	res := make([]Track, n)
+	for k := 0; k < n; k++ {
+		res[k] = Track{speedUnits: speedUnits}
+		track := Track{speedUnits: speedUnits}
+		for i := 0; i < len(ps); i++ {
+			if ps[i].jump {
+				track = Track{speedUnits: speedUnits}
+			}
+			if used[i] {
+				track = Track{speedUnits: speedUnits}
+				continue
+			}
+			track = track.addPointMinDuration(ps[i], 10)
+			if track.valid && res[k].speed < track.speed {
+				res[k] = track
+			}
+		}
+		for i := 0; i < len(res[k].ps); i++ {
+			used[res[k].ps[i].idx] = true
+		}
+	}
+	return res
+}
+
+func TestSelect5x10sMultiPass(t *testing.T) {
+	jumped := runsTrack(time.Second, 3, 12, 13, 11, 14, 12, 13)
+	for i := 200; i < len(jumped); i++ {
+		jumped[i] = movePoint(jumped[i], 3000)
+	}
+	jumped[200].jump = true
+	gap := runsTrack(time.Second, 8, 11, 12, 11.5, 12.5)
+	gap = append(gap[:100:100], gap[130:]...)
+
+	tests := []struct {
+		name string
+		ps   []Point
+	}{
+		{"shorter than 10s", straightTrack(10, 5*time.Second, time.Second)},
+		{"30 seconds", straightTrack(10, 30*time.Second, time.Second)},
+		{"constant speed", straightTrack(10, 10*time.Minute, time.Second)},
+		{"accelerating", generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second,
+			legs: []leg{{0, 5, time.Minute}, {0, 7, time.Minute}, {0, 9, time.Minute}, {0, 11, time.Minute}}})},
+		{"3 runs", runsTrack(time.Second, 1, 10, 11, 10.5)},
+		{"8 runs 10 Hz", runsTrack(100*time.Millisecond, 4, 9, 10, 9.6, 10.1, 9.8, 10.4, 9.1, 9.9)},
+		{"noisy", addNoise(runsTrack(time.Second, 5, 9, 10, 9.6, 10.1, 9.8, 9.3), 2, 6)},
+		{"noisy 10 Hz", addNoise(runsTrack(100*time.Millisecond, 5, 9, 10, 9.6, 10.1, 9.8, 9.3), 1, 6)},
+		{"jump", jumped},
+		{"gap", gap},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			want := multiPass5x10s(tt.ps, 5, UnitsKts)
+			got := CalculateStats(tt.ps, Stat5x10s, UnitsKts).Speed5x10s()
+			if len(got) != len(want) {
+				t.Fatalf("got %d 10s tracks, want %d", len(got), len(want))
+			}
+			for j := 0; j < len(want); j++ {
+				g, w := got[j], want[j]
+				if g.Valid() != w.Valid() || !almostEqual(g.Speed(), w.Speed(), 1e-9) ||
+					!g.Start().Equal(w.Start()) || !g.End().Equal(w.End()) {
+					t.Errorf("10s track %d: got %s, want %s", j+1, g.TxtLine(), w.TxtLine())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCalculateStats5x10s(b *testing.B) {
+	ps := runsTrack(100*time.Millisecond, 4, 9, 10, 9.6, 10.1, 9.8, 10.4, 9.1, 9.9)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CalculateStats(ps, Stat5x10s, UnitsKts)
+	}
+}
+
+func BenchmarkMultiPass5x10s(b *testing.B) {
+	ps := runsTrack(100*time.Millisecond, 4, 9, 10, 9.6, 10.1, 9.8, 10.4, 9.1, 9.9)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		multiPass5x10s(ps, 5, UnitsKts)
+	}
+}
+
+func TestSegDistance(t *testing.T) {
+	ps := runsTrack(time.Second, 9, 10, 11)
+	cached := make([]Point, len(ps))
+	copy(cached, ps)
+	for i := 0; i < len(cached); i++ {
+		cached[i].idx = i
+		cached[i].nextDistOk = i < len(cached)-1
+		if cached[i].nextDistOk {
+			// Wrong on purpose, to see when the cache is used.
+			cached[i].nextDist = -1
+		}
+	}
+
+	tests := []struct {
+		name   string
+		p1, p2 Point
+		want   float64
+	}{
+		{"consecutive cached", cached[10], cached[11], -1},
+		{"not consecutive", cached[10], cached[12], distance(ps[10], ps[12])},
+		{"reversed", cached[11], cached[10], distance(ps[11], ps[10])},
+		{"not cached", ps[10], ps[11], distance(ps[10], ps[11])},
+		{"last point", cached[len(cached)-1], cached[0], distance(ps[len(ps)-1], ps[0])},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			if got := segDistance(tt.p1, tt.p2); got != tt.want {
+				t.Errorf("segDistance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateStatsDistanceCache(t *testing.T) {
+	ps := addNoise(runsTrack(time.Second, 10, 10, 11, 10.5, 12), 2, 11)
+
+	s := CalculateStats(ps, StatAll, UnitsKts)
+
+	want := 0.0
+	for i := 1; i < len(ps); i++ {
+		want += distance(ps[i-1], ps[i])
+	}
+	if s.Distance() != want {
+		t.Errorf("Distance() = %v, want %v", s.Distance(), want)
+	}
+	// Tracks have the same values as recalculated without the cache.
+	tracks := append([]Track{s.Speed2s(), s.Speed100m(), s.Speed1NM(), s.Alpha500()}, s.Speed5x10s()...)
+	for i := 0; i < len(tracks); i++ {
+		ps := tracks[i].Points()
+		for j := 0; j < len(ps); j++ {
+			ps[j].nextDistOk = false
+		}
+		want := Track{ps: ps, speedUnits: UnitsKts}.reCalculate()
+		if !almostEqual(tracks[i].Distance(), want.distance, 1e-6) || !almostEqual(tracks[i].Speed(), want.speed, 1e-9) {
+			t.Errorf("track %d = %s, want %v m at %v kts", i, tracks[i].TxtLine(), want.distance, want.speed)
+		}
+	}
+	// Points passed to CalculateStats are not modified.
+	for i := 0; i < len(ps); i++ {
+		if ps[i].nextDistOk {
+			t.Fatalf("point %d has the distance cached", i)
+		}
+	}
+}
+
+func BenchmarkCalculateStats(b *testing.B) {
+	ps := longTrack()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CalculateStats(ps, StatAll, UnitsKts)
+	}
+}
+
+// BenchmarkTrackDistanceCache compares tracks built over points with
+// distances cached like in CalculateStats with the ones calculating all
+// distances.
+func BenchmarkTrackDistanceCache(b *testing.B) {
+	ps := longTrack()
+	for i := 0; i < len(ps); i++ {
+		ps[i].idx = i
+		ps[i].nextDistOk = i < len(ps)-1
+		if ps[i].nextDistOk {
+			ps[i].nextDist = distance(ps[i], ps[i+1])
+		}
+	}
+	uncached := make([]Point, len(ps))
+	copy(uncached, ps)
+	for i := 0; i < len(uncached); i++ {
+		uncached[i].nextDistOk = false
+	}
+
+	benchmarks := []struct {
+		name string
+		ps   []Point
+	}{
+		{"cached", ps},
+		{"uncached", uncached},
+	}
+	for i := 0; i < len(benchmarks); i++ {
+		bm := benchmarks[i]
+		b.Run(bm.name, func(b *testing.B) {
+			for j := 0; j < b.N; j++ {
+				track10s := Track{speedUnits: UnitsKts, window: bm.ps}
+				track100m := Track{speedUnits: UnitsKts, window: bm.ps}
+				track1NM := Track{speedUnits: UnitsKts, window: bm.ps}
+				for k := 0; k < len(bm.ps); k++ {
+					track10s = track10s.addPointMinDuration(bm.ps[k], 10)
+					track100m = track100m.addPointMinDistance(bm.ps[k], 100)
+					track1NM = track1NM.addPointMinDistance(bm.ps[k], 1852)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackAppendPointWindow(t *testing.T) {
+	ps := straightTrack(10, 9*time.Second, time.Second)
+	for i := 0; i < len(ps); i++ {
+		ps[i].idx = i
+	}
+	orig := make([]Point, len(ps))
+	copy(orig, ps)
+
+	track := Track{speedUnits: UnitsMs, window: ps}
+	for i := 0; i < 5; i++ {
+		track = track.addPointMinDuration(ps[i], 2)
+	}
+	if len(track.ps) != 3 || &track.ps[0] != &ps[2] {
+		t.Fatalf("track points %v are not a window over points 2-4", globalIdxs(track.ps))
+	}
+
+	// A point which doesn't follow the last one is copied together with the
+	// track points, the window isn't modified.
+	gapped := track.addPointMinDuration(ps[7], 2)
+	if l := len(gapped.ps); gapped.ps[l-1].idx != 7 || &gapped.ps[0] == &ps[gapped.ps[0].idx] {
+		t.Fatalf("track points %v after a gap are still a window", globalIdxs(gapped.ps))
+	}
+	gapped.ps[0].lat++
+	for i := 0; i < len(ps); i++ {
+		if ps[i] != orig[i] {
+			t.Errorf("point %d modified by the track after a gap", i)
+		}
+	}
+	if len(track.ps) != 3 || track.ps[2].idx != 4 {
+		t.Errorf("track points %v modified by adding a point to its copy", globalIdxs(track.ps))
+	}
+	next := track.addPointMinDuration(ps[5], 2)
+	if &next.ps[len(next.ps)-1] != &ps[5] {
+		t.Error("the next point doesn't extend the window")
+	}
+}
+
+// bestRollingTrack returns the fastest valid track created by adding points
+// to tracks owning their points (not windows over points).
+func bestRollingTrack(ps []Point, add func(Track, Point) Track) Track {
+	res := Track{speedUnits: UnitsKts}
+	track := Track{speedUnits: UnitsKts}
+	for i := 0; i < len(ps); i++ {
+		if ps[i].jump {
+			track = Track{speedUnits: UnitsKts}
+		}
+		track = add(track, ps[i])
+		if track.valid && res.speed < track.speed {
+			res = track
+		}
+	}
+	return res
+}
+
+// BenchmarkTrackAddPoint compares rolling tracks kept as windows over the
+// indexed points with tracks copying points, which aren't windows.
+func BenchmarkTrackAddPoint(b *testing.B) {
+	ps := longTrack()
+	for i := 0; i < len(ps); i++ {
+		ps[i].idx = i
+	}
+
+	benchmarks := []struct {
+		name   string
+		window []Point
+		add    func(Track, Point) Track
+	}{
+		{"addPointMinDuration window", ps, func(t Track, p Point) Track { return t.addPointMinDuration(p, 900) }},
+		{"addPointMinDuration copy", nil, func(t Track, p Point) Track { return t.addPointMinDuration(p, 900) }},
+		{"addPointMinDistance window", ps, func(t Track, p Point) Track { return t.addPointMinDistance(p, 1852) }},
+		{"addPointMinDistance copy", nil, func(t Track, p Point) Track { return t.addPointMinDistance(p, 1852) }},
+	}
+	for i := 0; i < len(benchmarks); i++ {
+		bm := benchmarks[i]
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for j := 0; j < b.N; j++ {
+				track := Track{speedUnits: UnitsKts, window: bm.window}
+				for k := 0; k < len(ps); k++ {
+					track = bm.add(track, ps[k])
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateStatsTrackWindows(t *testing.T) {
+	ps := addNoise(runsTrack(time.Second, 20, 10, 11, 10.5, 12, 11.5, 13, 12), 2, 21)
+	for i := 300; i < len(ps); i++ {
+		ps[i] = movePoint(ps[i], 5000)
+	}
+	ps[300].jump = true
+	orig := make([]Point, len(ps))
+	copy(orig, ps)
+
+	s := CalculateStats(ps, StatAll, UnitsKts)
+
+	indexed := ValidPoints(ps)
+	for i := 0; i < len(indexed); i++ {
+		indexed[i].idx = i
+	}
+	tests := []struct {
+		name string
+		got  Track
+		add  func(Track, Point) Track
+	}{
+		{"2s", s.Speed2s(), func(t Track, p Point) Track { return t.addPointMinDuration(p, 2) }},
+		{"15m", s.Speed15m(), func(t Track, p Point) Track { return t.addPointMinDuration(p, 900) }},
+		{"100m", s.Speed100m(), func(t Track, p Point) Track { return t.addPointMinDistance(p, 100) }},
+		{"1NM", s.Speed1NM(), func(t Track, p Point) Track { return t.addPointMinDistance(p, 1852) }},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			want := bestRollingTrack(indexed, tt.add)
+			if tt.got.Speed() != want.Speed() || !equalInts(globalIdxs(tt.got.Points()), globalIdxs(want.Points())) {
+				t.Errorf("got %s, want %s", tt.got.TxtLine(), want.TxtLine())
+			}
+		})
+	}
+
+	// Tracks share points, but they don't modify them.
+	for i := 0; i < len(ps); i++ {
+		if ps[i] != orig[i] {
+			t.Fatalf("point %d modified", i)
+		}
+	}
+	tracks := append([]Track{s.Speed2s(), s.Speed15m(), s.Speed100m(), s.Speed1NM(), s.Alpha500()}, s.Speed5x10s()...)
+	for i := 0; i < len(tracks); i++ {
+		tps := tracks[i].ps
+		for j := 0; j < len(tps); j++ {
+			want := indexed[tps[j].idx]
+			if tps[j].globalIdx != want.globalIdx || tps[j].lat != want.lat || tps[j].lon != want.lon ||
+				(j > 0 && tps[j].idx != tps[j-1].idx+1) {
+				t.Errorf("track %d point %d (%d in the file) is not the indexed point %d", i, j, tps[j].globalIdx, tps[j].idx)
+				break
+			}
+		}
+	}
+}
+
+func TestConvertSpeed(t *testing.T) {
+	units := []UnitsFlag{UnitsMs, UnitsKmh, UnitsKts}
+	speeds := []float64{0, 1, 12.5, 42.42}
+	for i := 0; i < len(units); i++ {
+		for j := 0; j < len(units); j++ {
+			from, to := units[i], units[j]
+			t.Run(from.String()+"-"+to.String(), func(t *testing.T) {
+				for k := 0; k < len(speeds); k++ {
+					v := speeds[k]
+					converted := ConvertSpeed(v, from, to)
+					if got := ConvertSpeed(converted, to, from); !almostEqual(got, v, 1e-9) {
+						t.Errorf("ConvertSpeed(ConvertSpeed(%v)) = %v, want %v", v, got, v)
+					}
+					if want := MsToUnits(UnitsToMs(v, from), to); !almostEqual(converted, want, 1e-9) {
+						t.Errorf("ConvertSpeed(%v) = %v, want %v", v, converted, want)
+					}
+				}
+			})
+		}
+		u := units[i]
+		for k := 0; k < len(speeds); k++ {
+			if got := UnitsToMs(MsToUnits(speeds[k], u), u); !almostEqual(got, speeds[k], 1e-9) {
+				t.Errorf("UnitsToMs(MsToUnits(%v, %v)) = %v, want %v", speeds[k], u, got, speeds[k])
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"1 kt in km/h", KtsToKmh(1), 1.852},
+		{"1.852 km/h in kts", KmhToKts(1.852), 1},
+		{"1 kt in km/h via ConvertSpeed", ConvertSpeed(1, UnitsKts, UnitsKmh), 1.852},
+		{"1 kt in m/s", KtsToMs(1), 1852.0 / 3600},
+		{"3.6 km/h in m/s", KmhToMs(3.6), 1},
+		{"1 m/s in km/h", MsToUnits(1, UnitsKmh), 3.6},
+		{"10 m/s in kts", ConvertSpeed(10, UnitsMs, UnitsKts), 19.4384},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			// The kts factor is rounded to 1.94384 kts in 1 m/s.
+			if !almostEqual(tt.got, tt.want, 1e-5) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+// sessionsTrack returns points of three sessions sailed with different
+// speeds: 10 m/s for 5 minutes, 12 m/s for 3 minutes after a 40 minute gap
+// and 8 m/s for 2 minutes in the next track segment after a 10 minute gap.
+func sessionsTrack() []Point {
+	specs := []struct {
+		start   time.Duration
+		speed   float64
+		d       time.Duration
+		segment int
+	}{
+		{0, 10, 5 * time.Minute, 0},
+		{45 * time.Minute, 12, 3 * time.Minute, 0},
+		{58 * time.Minute, 8, 2 * time.Minute, 1},
+	}
+	res := []Point{}
+	for i := 0; i < len(specs); i++ {
+		ps := generateTrack(trackSpec{lat: 45, lon: 14 + float64(i)*0.01, start: testStart.Add(specs[i].start),
+			interval: time.Second, legs: []leg{{0, specs[i].speed, specs[i].d}}})
+		for j := 0; j < len(ps); j++ {
+			ps[j].globalIdx = len(res)
+			ps[j].segment = specs[i].segment
+			res = append(res, ps[j])
+		}
+	}
+	return res
+}
+
+func TestSplitSessions(t *testing.T) {
+	ps := sessionsTrack()
+	oneSegment := make([]Point, len(ps))
+	copy(oneSegment, ps)
+	for i := 0; i < len(oneSegment); i++ {
+		oneSegment[i].segment = 0
+	}
+
+	tests := []struct {
+		name   string
+		ps     []Point
+		maxGap time.Duration
+		starts []int // Indexes of the first points of sessions.
+		speeds []float64
+	}{
+		{"gap and segment", ps, 30 * time.Minute, []int{0, 301, 482}, []float64{10, 12, 8}},
+		{"shorter gap", ps, 5 * time.Minute, []int{0, 301, 482}, []float64{10, 12, 8}},
+		{"gap only", oneSegment, 30 * time.Minute, []int{0, 301}, []float64{10, 12}},
+		{"no gaps", oneSegment, time.Hour, []int{0}, []float64{12}},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := SplitSessions(tt.ps, tt.maxGap)
+			if len(sessions) != len(tt.starts) {
+				t.Fatalf("found %d sessions, want %d", len(sessions), len(tt.starts))
+			}
+			for j := 0; j < len(sessions); j++ {
+				end := len(tt.ps)
+				if j < len(tt.starts)-1 {
+					end = tt.starts[j+1]
+				}
+				if len(sessions[j]) != end-tt.starts[j] || sessions[j][0].globalIdx != tt.starts[j] {
+					t.Errorf("session %d starts at %d with %d points, want %d with %d",
+						j, sessions[j][0].globalIdx, len(sessions[j]), tt.starts[j], end-tt.starts[j])
+				}
+				// 5x10 of each session is calculated from its points only.
+				s := CalculateStats(sessions[j], Stat5x10s, UnitsMs)
+				if !almostEqual(s.Calc5x10sAvg(), tt.speeds[j], 1e-6) {
+					t.Errorf("session %d 5x10 %.3f m/s, want %v m/s", j, s.Calc5x10sAvg(), tt.speeds[j])
+				}
+			}
+		})
+	}
+
+	if sessions := SplitSessions([]Point{}, time.Minute); len(sessions) != 0 {
+		t.Errorf("found %d sessions without points, want 0", len(sessions))
+	}
+}
+
+func TestMarkGapSegments(t *testing.T) {
+	ps := sessionsTrack()
+	oneSegment := make([]Point, len(ps))
+	copy(oneSegment, ps)
+	for i := 0; i < len(oneSegment); i++ {
+		oneSegment[i].segment = 0
+	}
+
+	tests := []struct {
+		name   string
+		ps     []Point
+		maxGap time.Duration
+		starts []int // Indexes of the first points of segments.
+	}{
+		{"gaps", oneSegment, 5 * time.Minute, []int{0, 301, 482}},
+		{"longer gap", oneSegment, 30 * time.Minute, []int{0, 301}},
+		// The gap between the first two sessions is 40 minutes.
+		{"gap equal to max", oneSegment, 40 * time.Minute, []int{0}},
+		{"existing segments kept", ps, time.Hour, []int{0, 482}},
+		{"gaps and existing segments", ps, 30 * time.Minute, []int{0, 301, 482}},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			orig := make([]Point, len(tt.ps))
+			copy(orig, tt.ps)
+
+			marked := Points{Ps: tt.ps}.MarkGapSegments(tt.maxGap)
+			segments := marked.Segments()
+			if len(segments) != len(tt.starts) {
+				t.Fatalf("found %d segments, want %d", len(segments), len(tt.starts))
+			}
+			for j := 0; j < len(segments); j++ {
+				if segments[j].Start != tt.starts[j] || marked.Ps[segments[j].Start].segment != j {
+					t.Errorf("segment %d starts at %d numbered %d, want %d numbered %d",
+						j, segments[j].Start, marked.Ps[segments[j].Start].segment, tt.starts[j], j)
+				}
+			}
+			for j := 0; j < len(tt.ps); j++ {
+				if tt.ps[j] != orig[j] {
+					t.Fatalf("point %d modified", j)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterTimeWindow(t *testing.T) {
+	// Points every 2 seconds.
+	ps := straightTrack(10, time.Minute, 2*time.Second)
+	at := func(d time.Duration) time.Time { return testStart.Add(d) }
+
+	tests := []struct {
+		name        string
+		from, to    time.Time
+		first, last int    // Indexes of the first and the last kept point.
+		wantErr     string // A part of the error message.
+	}{
+		{"no window", time.Time{}, time.Time{}, 0, 30, ""},
+		{"open end", at(41 * time.Second), time.Time{}, 21, 30, ""},
+		{"open start", time.Time{}, at(10 * time.Second), 0, 5, ""},
+		{"both ends", at(10 * time.Second), at(21 * time.Second), 5, 10, ""},
+		{"wider than the track", at(-time.Hour), at(time.Hour), 0, 30, ""},
+		{"start after the track end", at(61 * time.Second), time.Time{}, 0, 0, "is after the track end"},
+		{"end before the track start", time.Time{}, at(-time.Second), 0, 0, "is before the track start"},
+		{"start after end", at(20 * time.Second), at(10 * time.Second), 0, 0, "is not before its end"},
+		{"start equal to end", at(20 * time.Second), at(20 * time.Second), 0, 0, "is not before its end"},
+		{"between points", at(20*time.Second + 200*time.Millisecond), at(21*time.Second + 800*time.Millisecond),
+			0, 0, "No track points inside the time window"},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := FilterTimeWindow(Points{Name: "window", Ps: ps}, tt.from, tt.to)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("FilterTimeWindow() error = %v, want %q", err, tt.wantErr)
+				}
+				// Points are returned unchanged.
+				if len(res.Ps) != len(ps) {
+					t.Errorf("FilterTimeWindow() returned %d points, want %d", len(res.Ps), len(ps))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []int{}
+			for j := tt.first; j <= tt.last; j++ {
+				want = append(want, j)
+			}
+			if res.Name != "window" || !equalInts(globalIdxs(res.Ps), want) {
+				t.Errorf("FilterTimeWindow() = %q %v, want %q %v", res.Name, globalIdxs(res.Ps), "window", want)
+			}
+		})
+	}
+
+	res, err := FilterTimeWindow(Points{Ps: []Point{}}, at(0), at(time.Second))
+	if err != nil || len(res.Ps) != 0 {
+		t.Errorf("FilterTimeWindow() without points = %d points, %v, want 0, nil", len(res.Ps), err)
+	}
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+)
+
+// validateMaxSpeedMs is a speed between 2 points (in m/s) considered
+// a suspicious speed spike, it is faster than speed sailing world records.
+const validateMaxSpeedMs = 35.0
+
+// Anomaly is an issue found in track points.
+type Anomaly struct {
+	Idx         int
+	Time        time.Time
+	Description string
+}
+
+// String returns a text representation of the Anomaly.
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%6d %s %s", a.Idx, a.Time.Format(time.RFC3339), a.Description)
+}
+
+// Validate checks points and returns found anomalies without changing
+// points:
+//   - out-of-range coordinates
+//   - non-monotonic timestamps
+//   - zero-time gaps
+//   - suspicious speed spikes
+//   - missing device speed (if other points have speed)
+func (p Points) Validate() []Anomaly {
+	res := []Anomaly{}
+	ps := p.Ps
+
+	withSpeed := false
+	for i := 0; i < len(ps); i++ {
+		if ps[i].speed != nil {
+			withSpeed = true
+			break
+		}
+	}
+
+	for i := 0; i < len(ps); i++ {
+		pt := ps[i]
+		if pt.lat < -90 || pt.lat > 90 || pt.lon < -180 || pt.lon > 180 {
+			res = append(res, Anomaly{i, pt.ts,
+				fmt.Sprintf("Coordinates out of range (%f, %f).", pt.lat, pt.lon)})
+		}
+		if withSpeed && pt.speed == nil {
+			res = append(res, Anomaly{i, pt.ts, "Missing speed."})
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := ps[i-1]
+		dt := pt.ts.Sub(prev.ts).Seconds()
+		if dt < 0 {
+			res = append(res, Anomaly{i, pt.ts,
+				fmt.Sprintf("Timestamp before previous point (%s).", prev.ts.Format(time.RFC3339))})
+		} else if dt == 0 {
+			res = append(res, Anomaly{i, pt.ts, "Zero time from previous point."})
+		} else if s := speed(prev, pt, UnitsMs); s > validateMaxSpeedMs {
+			res = append(res, Anomaly{i, pt.ts,
+				fmt.Sprintf("Speed spike from previous point (%.3f m/s).", s)})
+		}
+	}
+
+	return res
+}
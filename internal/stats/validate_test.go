@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	withSpeeds := generateTrack(trackSpec{lat: 45, lon: 14, start: testStart, interval: time.Second,
+		legs: []leg{{0, 10, 10 * time.Second}}, deviceSpeed: true})
+
+	tests := []struct {
+		name   string
+		change func(ps []Point)
+		want   []Anomaly // Descriptions are prefixes.
+	}{
+		{"clean", func(ps []Point) {}, []Anomaly{}},
+		// The last point, so the out-of-range point is only reached from
+		// the previous one.
+		{"latitude out of range", func(ps []Point) { ps[10].lat = 91 },
+			[]Anomaly{{Idx: 10, Description: "Coordinates out of range"}, {Idx: 10, Description: "Speed spike"}}},
+		{"longitude out of range", func(ps []Point) { ps[10].lon = -180.5 },
+			[]Anomaly{{Idx: 10, Description: "Coordinates out of range"}, {Idx: 10, Description: "Speed spike"}}},
+		{"timestamp before previous point", func(ps []Point) {
+			ps[5].ts, ps[6].ts = ps[6].ts, ps[5].ts
+			// Positions follow the timestamps, without a speed spike.
+			ps[5].lat, ps[6].lat = ps[6].lat, ps[5].lat
+		}, []Anomaly{{Idx: 6, Description: "Timestamp before previous point"}}},
+		{"zero time", func(ps []Point) { ps[7].ts = ps[6].ts; ps[7].lat = ps[6].lat },
+			[]Anomaly{{Idx: 7, Description: "Zero time from previous point"}}},
+		// Away from the previous point and back to the next one.
+		{"speed spike", func(ps []Point) { ps[8] = movePoint(ps[8], 30) },
+			[]Anomaly{{Idx: 8, Description: "Speed spike from previous point"}}},
+		{"missing speed", func(ps []Point) { ps[2].speed = nil },
+			[]Anomaly{{Idx: 2, Description: "Missing speed"}}},
+	}
+	for i := 0; i < len(tests); i++ {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			ps := make([]Point, len(withSpeeds))
+			copy(ps, withSpeeds)
+			tt.change(ps)
+
+			anomalies := Points{Ps: ps}.Validate()
+			if len(anomalies) != len(tt.want) {
+				t.Fatalf("Validate() = %v, want %d anomalies", anomalies, len(tt.want))
+			}
+			for j := 0; j < len(tt.want); j++ {
+				a, want := anomalies[j], tt.want[j]
+				if a.Idx != want.Idx || !a.Time.Equal(ps[want.Idx].ts) || !strings.HasPrefix(a.Description, want.Description) {
+					t.Errorf("anomaly %d = %v, want point %d %q", j, a, want.Idx, want.Description)
+				}
+			}
+		})
+	}
+
+	// Points without device speed don't miss it.
+	if anomalies := (Points{Ps: straightTrack(10, 10*time.Second, time.Second)}).Validate(); len(anomalies) != 0 {
+		t.Errorf("Validate() without speeds = %v, want no anomalies", anomalies)
+	}
+}